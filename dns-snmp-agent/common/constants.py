@@ -34,6 +34,8 @@ class StatisticPerType():
     SERVER = "perServer"
     VIEW = "perView"
     BIND_VIEW = "perBindView"
+    # Aggregate entries of the clients/servers beyond maximum_clients, they have no row in mib table
+    OTHER_ENTRIES = ("__other__", "__other_servers__")
 
 
 class QryType():
@@ -54,7 +56,9 @@ class QryType():
         "other_rcode": 12,
         "successful_recursive": 13,
         "successful_noauthans": 14,
-        "successful_authans": 15
+        "successful_authans": 15,
+        "timeouts": 16,
+        "orphaned_responses": 17
     }
     METRIC_FOR_BIND_VIEW = {
         "totalQueries": 1,
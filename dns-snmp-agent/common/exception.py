@@ -18,3 +18,8 @@
 class NamedNotExist(Exception):
     def __str__(self):
         return("Named.conf is not exist")
+
+
+class InvalidRequest(Exception):
+    """[The request sent to the HTTP server can't be parsed, sending it again doesn't help]
+    """
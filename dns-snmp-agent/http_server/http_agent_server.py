@@ -33,6 +33,7 @@ except ImportError:
 
 import json
 import traceback
+from collections import deque
 from config import logger, HTTP_CONFIGURATION
 from common import exception as c_except
 from common.constants import QryType, StatisticPerType, TableOidStr
@@ -44,6 +45,10 @@ global AGENT, MIB_TABLE, ROW_DICT, NAMED_CONFIGURATION
 AGENT, MIB_TABLE, NAMED_CONFIGURATION = None, None, None
 ROW_DICT = {}
 
+# Idempotency keys of the last statistics received from PB, a replayed interval is counted once
+IDEMPOTENCY_KEY_HEADER = 'Idempotency-Key'
+RECEIVED_KEYS = deque(maxlen=1440)
+
 
 def get_old_counter_value(ip, dns_query_type_id, table_value):
     """[Get old statistic value of metric]
@@ -234,6 +239,8 @@ def reformat_pb_content(pb_request_content):
 class AgentServer(BaseHTTPRequestHandler):
     @classmethod
     def validate_traffic_input(cls, per_type, ip_or_view):
+        if ip_or_view in StatisticPerType.OTHER_ENTRIES:
+            return False
         if per_type == StatisticPerType.VIEW:
             return NAMED_CONFIGURATION.dns_view.is_available(ip_or_view)
         elif per_type == StatisticPerType.SERVER:
@@ -329,8 +336,8 @@ class AgentServer(BaseHTTPRequestHandler):
             logger.error("Input {} wrong format".format(type_exception))
 
     @classmethod
-    def update_mib_stat_counter(cls, list_statistic_data):
-        """[Update all statistic dns receiver from PacketBeat and Bind]
+    def get_mib_updates(cls, list_statistic_data):
+        """[Read the metrics of PacketBeat and Bind to update in the mib tables, the tables are not changed]
 
         Arguments:
             list_statistic_data {[list]} -- [statistic content get from request's data packetbeat
@@ -339,14 +346,10 @@ class AgentServer(BaseHTTPRequestHandler):
                 "total_responses":2,"total_responses_new":2,"recursive":0,"duplicated":0,"average_time":0,"successful":0,
                 "server_fail":0,"nx_domain":0,"format_error":2,"nx_rrset":0,"referral":0,"refused":0,"other_rcode":0}},
                 ...]]
+        Returns:
+            [list] -- [(stat_type, ip_or_view, metric_name, value) of each metric to update]
         """
-        global MIB_TABLE
-        
-        # Sync-up with data in mib
-        MIB_TABLE[TableOidStr.STAT_PER_CLIENT]["table_value"] = MIB_TABLE[TableOidStr.STAT_PER_CLIENT]["table"].value()
-        MIB_TABLE[TableOidStr.STAT_PER_SERVER]["table_value"] = MIB_TABLE[TableOidStr.STAT_PER_SERVER]["table"].value()
-        MIB_TABLE[TableOidStr.STAT_PER_VIEW]["table_value"] = MIB_TABLE[TableOidStr.STAT_PER_VIEW]["table"].value()
-
+        mib_updates = []
         logger.info("Update {} client/server/view from PB to mib table".format(
             len(list_statistic_data)))
         for statistic in list_statistic_data:
@@ -356,19 +359,37 @@ class AgentServer(BaseHTTPRequestHandler):
                 continue
             metrics = statistic['dnsmetrics']
             for metric_name in metrics:
-                value = metrics[metric_name]
-                cls.update_to_mib_table(stat_type, ip_or_view, metric_name, value)
+                # Only counters and average time have rows in mib table (e.g. skip latency histogram)
+                if metric_name not in QryType.METRIC_FOR_AGENT and metric_name != QryType.METRIC_AVG_TIME:
+                    continue
+                mib_updates.append((stat_type, ip_or_view, metric_name, metrics[metric_name]))
 
-        # Update statistic of view from bind to mib
+        # Statistic of view from bind
         stats_views = get_stats_views()
         logger.info("Update {} view from bind channel to mib table".format(
             len(stats_views)))
         for view in stats_views:
             metrics = stats_views[view]
             for metric_name in metrics:
-                value = metrics[metric_name]
-                cls.update_to_mib_table(
-                    StatisticPerType.BIND_VIEW, view, metric_name, value)
+                mib_updates.append((StatisticPerType.BIND_VIEW, view, metric_name, metrics[metric_name]))
+        return mib_updates
+
+    @classmethod
+    def apply_mib_updates(cls, mib_updates):
+        """[Update the metrics read by get_mib_updates in the mib tables]
+
+        Arguments:
+            mib_updates {[list]} -- [(stat_type, ip_or_view, metric_name, value) of each metric to update]
+        """
+        global MIB_TABLE
+
+        # Sync-up with data in mib
+        MIB_TABLE[TableOidStr.STAT_PER_CLIENT]["table_value"] = MIB_TABLE[TableOidStr.STAT_PER_CLIENT]["table"].value()
+        MIB_TABLE[TableOidStr.STAT_PER_SERVER]["table_value"] = MIB_TABLE[TableOidStr.STAT_PER_SERVER]["table"].value()
+        MIB_TABLE[TableOidStr.STAT_PER_VIEW]["table_value"] = MIB_TABLE[TableOidStr.STAT_PER_VIEW]["table"].value()
+
+        for stat_type, ip_or_view, metric_name, value in mib_updates:
+            cls.update_to_mib_table(stat_type, ip_or_view, metric_name, value)
 
         # Sync-up with data in mib
         # Sync-up again after update data from Packetbeat and then set default for another client/server/view missing
@@ -381,6 +402,15 @@ class AgentServer(BaseHTTPRequestHandler):
         logger.info("Set default zero value for metrics missing")
         cls.set_default_stats()
 
+    @classmethod
+    def update_mib_stat_counter(cls, list_statistic_data):
+        """[Update all statistic dns receiver from PacketBeat and Bind]
+
+        Arguments:
+            list_statistic_data {[list]} -- [statistic content get from request's data packetbeat, see get_mib_updates]
+        """
+        cls.apply_mib_updates(cls.get_mib_updates(list_statistic_data))
+
     def _repsonse_template(self, code, status):
         """[Prepare content for response]
 
@@ -396,25 +426,86 @@ class AgentServer(BaseHTTPRequestHandler):
             'code': code
         }
 
+    def _response(self, code, status):
+        """[Send the response to the client]
+
+        Arguments:
+            code {[Int]} -- [HTTP code status]
+            status {[String]} -- [Status message]
+        """
+        self.send_response(code)
+        self.send_header('Content-Type', 'application/json')
+        self.end_headers()
+        self.wfile.write(json.dumps(self._repsonse_template(code, status)).encode())
+
+    def _read_json_content(self):
+        """[Read the JSON object in the body of the request]
+
+        Raises:
+            c_except.InvalidRequest -- [Content-Length or the body is not valid]
+        """
+        try:
+            content_len = int(self.headers.get('content-length'))
+            if content_len < 0:
+                raise ValueError("negative Content-Length {}".format(content_len))
+            content = json.loads(self.rfile.read(content_len))
+        except (TypeError, ValueError) as error:
+            raise c_except.InvalidRequest(str(error))
+        if not isinstance(content, dict):
+            raise c_except.InvalidRequest("the statistics are not a JSON object")
+        return content
+
+    def _update_counter(self):
+        """[Update the MIB counters with the statistics of an interval sent from PB]
+        """
+        idempotency_key = self.headers.get(IDEMPOTENCY_KEY_HEADER)
+        content = self._read_json_content()
+        if idempotency_key is not None and idempotency_key in RECEIVED_KEYS:
+            logger.info("Statistics {} received already".format(idempotency_key))
+            self._response(200, 'DUPLICATED')
+            return
+        logger.debug("Content receive from PB")
+        logger.debug(content)
+        list_statistic_data = reformat_pb_content(content)
+        # Nothing is counted yet if the statistics can't be read, PB sends them again
+        mib_updates = self.get_mib_updates(list_statistic_data)
+        # The key is recorded before counting, the statistics are never counted twice
+        if idempotency_key is not None:
+            RECEIVED_KEYS.append(idempotency_key)
+        try:
+            self.apply_mib_updates(mib_updates)
+        except Exception:
+            logger.error("Statistics {} counted partially".format(idempotency_key))
+            logger.error(traceback.format_exc())
+
+        # Response
+        logger.info("Response to client")
+        self._response(200, 'SUCCESSFUL')
+
+    def do_POST(self):
+        logger.info("Have request POST API")
+        try:
+            if self.path == '/counter':
+                logger.info("Receive POST Counter api")
+                self._update_counter()
+            else:
+                logger.info("Wrong request url")
+                self._response(404, 'NOT FOUND')
+        except c_except.InvalidRequest as error:
+            # PB drops the statistics refused with 400 rather than sending them again
+            logger.warning("Invalid statistics request: {}".format(error))
+            self._response(400, 'BAD REQUEST')
+        except Exception:
+            logger.error(traceback.format_exc())
+            self._response(500, 'FAILED')
+
     def do_GET(self):
-        import traceback
         logger.info("Have request GET API")
         try:
             if self.path == '/counter':
+                # Statistics sent by the previous versions of PB
                 logger.info("Receive GET Counter api")
-                content_len = int(self.headers.get('content-length'))
-                content = json.loads(self.rfile.read(content_len))
-                logger.debug("Content receive from PB")
-                logger.debug(content)
-                list_statistic_data = reformat_pb_content(content)
-                self.update_mib_stat_counter(list_statistic_data)
-
-                # Response
-                logger.info("Response to client")
-                self.send_response(200)
-                self.end_headers()
-                response_content = self._repsonse_template(200, 'SUCCESSFUL')
-                self.wfile.write(json.dumps(response_content).encode())
+                self._update_counter()
             elif self.path == '/announcement-deploy-from-bam':
                 logger.info("Announcement deploy from bam")
                 NAMED_CONFIGURATION.load_configuration()
@@ -430,8 +521,12 @@ class AgentServer(BaseHTTPRequestHandler):
             else:
                 logger.info("Wrong request url")
                 self.send_response(404, "NOT FOUND")
+        except c_except.InvalidRequest as error:
+            logger.warning("Invalid statistics request: {}".format(error))
+            self._response(400, 'BAD REQUEST')
         except Exception:
                 logger.error(traceback.format_exc())
+                self._response(500, 'FAILED')
 
 
 class HTTPAgentServer(HTTPServer):
@@ -89,6 +89,6 @@ headers = {
     'Postman-Token': "03c8144a-11b1-4688-976a-734aadf218b7"
     }
 
-response = requests.request("GET", url, data=json.dumps(payload), headers=headers)
+response = requests.request("POST", url, data=json.dumps(payload), headers=headers)
 
 print(response.text)
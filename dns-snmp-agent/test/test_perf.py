@@ -61,7 +61,7 @@ headers = {
 
 print(datetime.datetime.now())
 response = requests.request(
-    "GET", url, data=json.dumps(payload), headers=headers)
+    "POST", url, data=json.dumps(payload), headers=headers)
 
 print(response.text)
 print(datetime.datetime.now())
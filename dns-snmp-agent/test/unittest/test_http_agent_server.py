@@ -0,0 +1,129 @@
+# Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""[Tests of the statistics requests of the HTTP agent server]
+
+Run from the dns-snmp-agent directory: python -m unittest discover -s test/unittest
+"""
+
+import io
+import json
+import unittest
+
+from common.constants import TableOidStr
+from http_server import http_agent_server
+from http_server.http_agent_server import AgentServer, IDEMPOTENCY_KEY_HEADER, RECEIVED_KEYS
+
+
+class FakeTable(object):
+    def value(self):
+        return {}
+
+
+class FakeAcl(object):
+    def is_ip_available_acl(self, ip):
+        return True
+
+
+class FakeNamedConfiguration(object):
+    acl_traffic_client = FakeAcl()
+    acl_traffic_server = FakeAcl()
+
+
+class FakeAgentServer(AgentServer):
+    """[Request handler without socket, the metrics updated in the mib tables are recorded]
+    """
+    updated = []
+    failing_metric = None
+
+    def __init__(self, content, idempotency_key):
+        body = json.dumps(content).encode()
+        self.path = '/counter'
+        self.headers = {'content-length': str(len(body)), IDEMPOTENCY_KEY_HEADER: idempotency_key}
+        self.rfile = io.BytesIO(body)
+        self.responses = []
+
+    def _response(self, code, status):
+        self.responses.append((code, status))
+
+    @classmethod
+    def update_to_mib_table(cls, stat_type, ip_or_view, dns_query_type, value):
+        if dns_query_type == cls.failing_metric:
+            raise RuntimeError("mib table error")
+        cls.updated.append((stat_type, ip_or_view, dns_query_type, value))
+
+    @classmethod
+    def set_default_stats(cls):
+        pass
+
+
+STATISTICS = {
+    "start": "2020-01-01T00:00:00Z",
+    "end": "2020-01-01T00:01:00Z",
+    "stats_map": {
+        "192.168.88.23": {"type": "perClient", "dnsmetrics": {"total_queries": 3, "total_responses": 2}}
+    }
+}
+
+
+class TestUpdateCounter(unittest.TestCase):
+    def setUp(self):
+        self.saved = (http_agent_server.MIB_TABLE, http_agent_server.NAMED_CONFIGURATION,
+                      http_agent_server.get_stats_views)
+        http_agent_server.MIB_TABLE = dict(
+            (oid, {"table": FakeTable(), "table_value": {}})
+            for oid in (TableOidStr.STAT_PER_CLIENT, TableOidStr.STAT_PER_SERVER, TableOidStr.STAT_PER_VIEW))
+        http_agent_server.NAMED_CONFIGURATION = FakeNamedConfiguration()
+        http_agent_server.get_stats_views = lambda: {}
+        RECEIVED_KEYS.clear()
+        FakeAgentServer.updated = []
+        FakeAgentServer.failing_metric = None
+
+    def tearDown(self):
+        (http_agent_server.MIB_TABLE, http_agent_server.NAMED_CONFIGURATION,
+         http_agent_server.get_stats_views) = self.saved
+        RECEIVED_KEYS.clear()
+
+    def post(self, idempotency_key):
+        server = FakeAgentServer(STATISTICS, idempotency_key)
+        server.do_POST()
+        return server.responses
+
+    def test_statistics_counted_once(self):
+        self.assertEqual([(200, 'SUCCESSFUL')], self.post('pb-1-2'))
+        self.assertEqual([(200, 'DUPLICATED')], self.post('pb-1-2'))
+        self.assertEqual(2, len(FakeAgentServer.updated))
+
+    def test_statistics_not_read_are_not_counted(self):
+        def bind_unavailable():
+            raise IOError("bind statistics channel unavailable")
+        http_agent_server.get_stats_views = bind_unavailable
+        self.assertEqual([(500, 'FAILED')], self.post('pb-1-2'))
+        self.assertEqual([], FakeAgentServer.updated)
+
+        # The statistics sent again are counted
+        http_agent_server.get_stats_views = lambda: {}
+        self.assertEqual([(200, 'SUCCESSFUL')], self.post('pb-1-2'))
+        self.assertEqual(2, len(FakeAgentServer.updated))
+
+    def test_statistics_counted_partially_are_not_counted_again(self):
+        FakeAgentServer.failing_metric = "total_responses"
+        self.assertEqual([(200, 'SUCCESSFUL')], self.post('pb-1-2'))
+        counted = list(FakeAgentServer.updated)
+        self.assertEqual([(200, 'DUPLICATED')], self.post('pb-1-2'))
+        self.assertEqual(counted, FakeAgentServer.updated)
+
+
+if __name__ == '__main__':
+    unittest.main()
@@ -0,0 +1,92 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Object identifier
+type OID []uint32
+
+// Parse a dotted OID, e.g. 1.3.6.1.4.1
+func ParseOID(value string) (OID, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), ".")
+	if value == "" {
+		return OID{}, nil
+	}
+	parts := strings.Split(value, ".")
+	oid := make(OID, len(parts))
+	for i, part := range parts {
+		subID, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %v", value)
+		}
+		oid[i] = uint32(subID)
+	}
+	return oid, nil
+}
+
+func (oid OID) String() string {
+	parts := make([]string, len(oid))
+	for i, subID := range oid {
+		parts[i] = strconv.FormatUint(uint64(subID), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Lexicographic order: -1, 0 or 1 if oid is before, equal to or after other
+func (oid OID) Compare(other OID) int {
+	for i := 0; i < len(oid) && i < len(other); i++ {
+		if oid[i] != other[i] {
+			if oid[i] < other[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(oid) < len(other):
+		return -1
+	case len(oid) > len(other):
+		return 1
+	}
+	return 0
+}
+
+func (oid OID) Equal(other OID) bool {
+	return oid.Compare(other) == 0
+}
+
+func (oid OID) HasPrefix(prefix OID) bool {
+	return len(oid) >= len(prefix) && oid[:len(prefix)].Equal(prefix)
+}
+
+// Return a new OID made of oid followed by the sub-identifiers
+func (oid OID) Append(subIDs ...uint32) OID {
+	result := make(OID, 0, len(oid)+len(subIDs))
+	return append(append(result, oid...), subIDs...)
+}
+
+// Append an octet string table index: its length then its octets
+func (oid OID) AppendString(value string) OID {
+	result := oid.Append(uint32(len(value)))
+	for i := 0; i < len(value); i++ {
+		result = append(result, uint32(value[i]))
+	}
+	return result
+}
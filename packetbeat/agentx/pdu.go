@@ -0,0 +1,475 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AgentX protocol (RFC 2741), version 1
+const (
+	VERSION     = 1
+	HEADER_SIZE = 20
+	// Maximum payload accepted from the master agent
+	MAX_PAYLOAD_SIZE = 1 << 20
+)
+
+// PDU types
+const (
+	PDU_OPEN              = 1
+	PDU_CLOSE             = 2
+	PDU_REGISTER          = 3
+	PDU_UNREGISTER        = 4
+	PDU_GET               = 5
+	PDU_GET_NEXT          = 6
+	PDU_GET_BULK          = 7
+	PDU_TEST_SET          = 8
+	PDU_COMMIT_SET        = 9
+	PDU_UNDO_SET          = 10
+	PDU_CLEANUP_SET       = 11
+	PDU_NOTIFY            = 12
+	PDU_PING              = 13
+	PDU_INDEX_ALLOCATE    = 14
+	PDU_INDEX_DEALLOCATE  = 15
+	PDU_ADD_AGENT_CAPS    = 16
+	PDU_REMOVE_AGENT_CAPS = 17
+	PDU_RESPONSE          = 18
+)
+
+// Header flags
+const (
+	FLAG_INSTANCE_REGISTRATION = 0x01
+	FLAG_NEW_INDEX             = 0x02
+	FLAG_ANY_INDEX             = 0x04
+	FLAG_NON_DEFAULT_CONTEXT   = 0x08
+	FLAG_NETWORK_BYTE_ORDER    = 0x10
+)
+
+// VarBind types
+const (
+	TYPE_INTEGER           = 2
+	TYPE_OCTET_STRING      = 4
+	TYPE_NULL              = 5
+	TYPE_OBJECT_IDENTIFIER = 6
+	TYPE_IP_ADDRESS        = 64
+	TYPE_COUNTER32         = 65
+	TYPE_GAUGE32           = 66
+	TYPE_TIME_TICKS        = 67
+	TYPE_OPAQUE            = 68
+	TYPE_COUNTER64         = 70
+	TYPE_NO_SUCH_OBJECT    = 128
+	TYPE_NO_SUCH_INSTANCE  = 129
+	TYPE_END_OF_MIB_VIEW   = 130
+)
+
+// Errors of the Response PDU
+const (
+	ERROR_NONE                   = 0
+	ERROR_NOT_WRITABLE           = 17
+	ERROR_OPEN_FAILED            = 256
+	ERROR_NOT_OPEN               = 257
+	ERROR_DUPLICATE_REGISTRATION = 263
+	ERROR_UNKNOWN_REGISTRATION   = 264
+	ERROR_PARSE                  = 266
+	ERROR_REQUEST_DENIED         = 267
+	ERROR_PROCESSING             = 268
+)
+
+// Reasons of the Close PDU
+const (
+	CLOSE_OTHER          = 1
+	CLOSE_PARSE_ERROR    = 2
+	CLOSE_PROTOCOL_ERROR = 3
+	CLOSE_TIMEOUTS       = 4
+	CLOSE_SHUTDOWN       = 5
+	CLOSE_BY_MANAGER     = 6
+)
+
+type (
+	Header struct {
+		Version       uint8
+		Type          uint8
+		Flags         uint8
+		SessionID     uint32
+		TransactionID uint32
+		PacketID      uint32
+		PayloadLength uint32
+	}
+
+	// Range of a Get, GetNext or GetBulk request: from Start (included if Include) to End (excluded, empty for no end)
+	SearchRange struct {
+		Start   OID
+		Include bool
+		End     OID
+	}
+
+	VarBind struct {
+		Type  uint16
+		Name  OID
+		Value interface{}
+	}
+
+	// AgentX PDU, only the fields of its type are used
+	PDU struct {
+		Header
+		Context string
+
+		// Open
+		Timeout     uint8
+		ID          OID
+		Description string
+		// Register
+		Priority uint8
+		Subtree  OID
+		// Get, GetNext and GetBulk
+		NonRepeaters   uint16
+		MaxRepetitions uint16
+		SearchRanges   []SearchRange
+		// Response
+		SysUpTime uint32
+		Error     uint16
+		Index     uint16
+		VarBinds  []VarBind
+		// Close
+		Reason uint8
+	}
+)
+
+var errShortPDU = errors.New("agentx: short PDU")
+
+func Integer(name OID, value int32) VarBind {
+	return VarBind{Type: TYPE_INTEGER, Name: name, Value: value}
+}
+
+func Counter64(name OID, value uint64) VarBind {
+	return VarBind{Type: TYPE_COUNTER64, Name: name, Value: value}
+}
+
+func OctetString(name OID, value string) VarBind {
+	return VarBind{Type: TYPE_OCTET_STRING, Name: name, Value: value}
+}
+
+// Read a PDU, its byte order is given by its header
+func ReadPDU(reader io.Reader) (*PDU, error) {
+	buf := make([]byte, HEADER_SIZE)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, err
+	}
+	pdu := &PDU{}
+	pdu.Version, pdu.Type, pdu.Flags = buf[0], buf[1], buf[2]
+	if pdu.Version != VERSION {
+		return nil, fmt.Errorf("agentx: unsupported version %d", pdu.Version)
+	}
+	d := &decoder{order: byteOrder(pdu.Flags), buf: buf[4:]}
+	pdu.SessionID, pdu.TransactionID, pdu.PacketID, pdu.PayloadLength = d.uint32(), d.uint32(), d.uint32(), d.uint32()
+	if pdu.PayloadLength > MAX_PAYLOAD_SIZE || pdu.PayloadLength%4 != 0 {
+		return nil, fmt.Errorf("agentx: invalid payload length %d", pdu.PayloadLength)
+	}
+	payload := make([]byte, pdu.PayloadLength)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	return pdu, pdu.decodePayload(&decoder{order: d.order, buf: payload})
+}
+
+func (pdu *PDU) decodePayload(d *decoder) error {
+	if pdu.Flags&FLAG_NON_DEFAULT_CONTEXT != 0 {
+		pdu.Context = d.octetString()
+	}
+	switch pdu.Type {
+	case PDU_OPEN:
+		pdu.Timeout = d.uint8()
+		d.skip(3)
+		pdu.ID, _ = d.oid()
+		pdu.Description = d.octetString()
+	case PDU_CLOSE:
+		pdu.Reason = d.uint8()
+		d.skip(3)
+	case PDU_REGISTER, PDU_UNREGISTER:
+		if pdu.Type == PDU_REGISTER {
+			pdu.Timeout = d.uint8()
+		} else {
+			d.skip(1)
+		}
+		pdu.Priority = d.uint8()
+		rangeSubID := d.uint8()
+		d.skip(1)
+		pdu.Subtree, _ = d.oid()
+		if rangeSubID != 0 {
+			d.uint32()
+		}
+	case PDU_GET_BULK:
+		pdu.NonRepeaters, pdu.MaxRepetitions = d.uint16(), d.uint16()
+		fallthrough
+	case PDU_GET, PDU_GET_NEXT:
+		for d.err == nil && len(d.buf) > 0 {
+			var searchRange SearchRange
+			searchRange.Start, searchRange.Include = d.oid()
+			searchRange.End, _ = d.oid()
+			pdu.SearchRanges = append(pdu.SearchRanges, searchRange)
+		}
+	case PDU_RESPONSE:
+		pdu.SysUpTime = d.uint32()
+		pdu.Error, pdu.Index = d.uint16(), d.uint16()
+		for d.err == nil && len(d.buf) > 0 {
+			pdu.VarBinds = append(pdu.VarBinds, d.varBind())
+		}
+	case PDU_TEST_SET:
+		for d.err == nil && len(d.buf) > 0 {
+			pdu.VarBinds = append(pdu.VarBinds, d.varBind())
+		}
+	}
+	// The payload of the other PDUs isn't used
+	return d.err
+}
+
+// Encode a PDU in network byte order
+func (pdu *PDU) MarshalBinary() ([]byte, error) {
+	e := &encoder{}
+	if pdu.Context != "" {
+		pdu.Flags |= FLAG_NON_DEFAULT_CONTEXT
+		e.octetString(pdu.Context)
+	}
+	switch pdu.Type {
+	case PDU_OPEN:
+		e.uint8(pdu.Timeout)
+		e.skip(3)
+		e.oid(pdu.ID, false)
+		e.octetString(pdu.Description)
+	case PDU_CLOSE:
+		e.uint8(pdu.Reason)
+		e.skip(3)
+	case PDU_REGISTER, PDU_UNREGISTER:
+		e.uint8(pdu.Timeout)
+		e.uint8(pdu.Priority)
+		e.skip(2)
+		e.oid(pdu.Subtree, false)
+	case PDU_GET_BULK:
+		e.uint16(pdu.NonRepeaters)
+		e.uint16(pdu.MaxRepetitions)
+		fallthrough
+	case PDU_GET, PDU_GET_NEXT:
+		for _, searchRange := range pdu.SearchRanges {
+			e.oid(searchRange.Start, searchRange.Include)
+			e.oid(searchRange.End, false)
+		}
+	case PDU_RESPONSE:
+		e.uint32(pdu.SysUpTime)
+		e.uint16(pdu.Error)
+		e.uint16(pdu.Index)
+		fallthrough
+	case PDU_TEST_SET:
+		for _, varBind := range pdu.VarBinds {
+			if err := e.varBind(varBind); err != nil {
+				return nil, err
+			}
+		}
+	case PDU_COMMIT_SET, PDU_UNDO_SET, PDU_CLEANUP_SET, PDU_PING:
+	default:
+		return nil, fmt.Errorf("agentx: can't encode the PDU type %d", pdu.Type)
+	}
+
+	payload := e.Bytes()
+	header := &encoder{}
+	header.uint8(VERSION)
+	header.uint8(pdu.Type)
+	header.uint8(pdu.Flags | FLAG_NETWORK_BYTE_ORDER)
+	header.skip(1)
+	header.uint32(pdu.SessionID)
+	header.uint32(pdu.TransactionID)
+	header.uint32(pdu.PacketID)
+	header.uint32(uint32(len(payload)))
+	return append(header.Bytes(), payload...), nil
+}
+
+func byteOrder(flags uint8) binary.ByteOrder {
+	if flags&FLAG_NETWORK_BYTE_ORDER != 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+type decoder struct {
+	order binary.ByteOrder
+	buf   []byte
+	err   error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.err = errShortPDU
+		return make([]byte, n)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) skip(n int) {
+	d.next(n)
+}
+
+func (d *decoder) uint8() uint8 {
+	return d.next(1)[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	return d.order.Uint16(d.next(2))
+}
+
+func (d *decoder) uint32() uint32 {
+	return d.order.Uint32(d.next(4))
+}
+
+func (d *decoder) uint64() uint64 {
+	return d.order.Uint64(d.next(8))
+}
+
+// Object identifier: number of sub-identifiers, prefix (1.3.6.1.<prefix>), include and the sub-identifiers
+func (d *decoder) oid() (OID, bool) {
+	count, prefix, include := d.uint8(), d.uint8(), d.uint8()
+	d.skip(1)
+	oid := make(OID, 0, int(count)+5)
+	if prefix != 0 {
+		oid = append(oid, 1, 3, 6, 1, uint32(prefix))
+	}
+	for i := 0; i < int(count) && d.err == nil; i++ {
+		oid = append(oid, d.uint32())
+	}
+	return oid, include != 0
+}
+
+// Octet string: length and the octets padded to 4 bytes
+func (d *decoder) octetString() string {
+	length := d.uint32()
+	if length > uint32(len(d.buf)) {
+		d.err = errShortPDU
+		return ""
+	}
+	value := string(d.next(int(length)))
+	d.skip(padding(int(length)))
+	return value
+}
+
+func (d *decoder) varBind() VarBind {
+	varBind := VarBind{Type: d.uint16()}
+	d.skip(2)
+	varBind.Name, _ = d.oid()
+	switch varBind.Type {
+	case TYPE_INTEGER:
+		varBind.Value = int32(d.uint32())
+	case TYPE_COUNTER32, TYPE_GAUGE32, TYPE_TIME_TICKS:
+		varBind.Value = d.uint32()
+	case TYPE_COUNTER64:
+		varBind.Value = d.uint64()
+	case TYPE_OCTET_STRING, TYPE_IP_ADDRESS, TYPE_OPAQUE:
+		varBind.Value = d.octetString()
+	case TYPE_OBJECT_IDENTIFIER:
+		varBind.Value, _ = d.oid()
+	}
+	return varBind
+}
+
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) skip(n int) {
+	e.Write(make([]byte, n))
+}
+
+func (e *encoder) uint8(value uint8) {
+	e.WriteByte(value)
+}
+
+func (e *encoder) uint16(value uint16) {
+	binary.Write(e, binary.BigEndian, value)
+}
+
+func (e *encoder) uint32(value uint32) {
+	binary.Write(e, binary.BigEndian, value)
+}
+
+func (e *encoder) uint64(value uint64) {
+	binary.Write(e, binary.BigEndian, value)
+}
+
+func (e *encoder) oid(oid OID, include bool) {
+	prefix := uint8(0)
+	if len(oid) > 4 && oid[:4].Equal(OID{1, 3, 6, 1}) && oid[4] > 0 && oid[4] < 256 {
+		prefix = uint8(oid[4])
+		oid = oid[5:]
+	}
+	e.uint8(uint8(len(oid)))
+	e.uint8(prefix)
+	if include {
+		e.uint8(1)
+	} else {
+		e.uint8(0)
+	}
+	e.skip(1)
+	for _, subID := range oid {
+		e.uint32(subID)
+	}
+}
+
+func (e *encoder) octetString(value string) {
+	e.uint32(uint32(len(value)))
+	e.WriteString(value)
+	e.skip(padding(len(value)))
+}
+
+func (e *encoder) varBind(varBind VarBind) error {
+	e.uint16(varBind.Type)
+	e.skip(2)
+	e.oid(varBind.Name, false)
+	var ok bool
+	switch varBind.Type {
+	case TYPE_INTEGER:
+		var value int32
+		value, ok = varBind.Value.(int32)
+		e.uint32(uint32(value))
+	case TYPE_COUNTER32, TYPE_GAUGE32, TYPE_TIME_TICKS:
+		var value uint32
+		value, ok = varBind.Value.(uint32)
+		e.uint32(value)
+	case TYPE_COUNTER64:
+		var value uint64
+		value, ok = varBind.Value.(uint64)
+		e.uint64(value)
+	case TYPE_OCTET_STRING, TYPE_IP_ADDRESS, TYPE_OPAQUE:
+		var value string
+		value, ok = varBind.Value.(string)
+		e.octetString(value)
+	case TYPE_OBJECT_IDENTIFIER:
+		var value OID
+		value, ok = varBind.Value.(OID)
+		e.oid(value, false)
+	case TYPE_NULL, TYPE_NO_SUCH_OBJECT, TYPE_NO_SUCH_INSTANCE, TYPE_END_OF_MIB_VIEW:
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("agentx: invalid value %v of the type %d for %v", varBind.Value, varBind.Type, varBind.Name)
+	}
+	return nil
+}
+
+func padding(length int) int {
+	return (4 - length%4) % 4
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package agentx
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOID(t *testing.T) {
+	oid, err := ParseOID(".1.3.6.1.4.1.13315")
+	assert.NoError(t, err)
+	assert.Equal(t, OID{1, 3, 6, 1, 4, 1, 13315}, oid)
+	assert.Equal(t, "1.3.6.1.4.1.13315", oid.String())
+	_, err = ParseOID("1.3.x")
+	assert.Error(t, err)
+
+	assert.Equal(t, -1, oid.Compare(oid.Append(1)))
+	assert.Equal(t, 1, oid.Append(2).Compare(oid.Append(1, 5)))
+	assert.True(t, oid.Append(1).HasPrefix(oid))
+	assert.Equal(t, OID{1, 2, 3, 'a', 'b', 'c'}, OID{1, 2}.AppendString("abc"))
+}
+
+func TestPDURoundTrip(t *testing.T) {
+	pdus := []*PDU{
+		{Header: Header{Type: PDU_OPEN, PacketID: 1}, Timeout: 5, ID: OID{1, 3, 6, 1, 4, 1, 13315}, Description: "packetbeat"},
+		{Header: Header{Type: PDU_REGISTER, SessionID: 7, PacketID: 2}, Priority: DEFAULT_PRIORITY, Subtree: OID{1, 3, 6, 1, 4, 1, 13315, 100, 2, 2}},
+		{Header: Header{Type: PDU_GET_BULK, SessionID: 7, TransactionID: 3}, NonRepeaters: 1, MaxRepetitions: 10, SearchRanges: []SearchRange{
+			{Start: OID{1, 3, 6, 1, 2, 1}, Include: true, End: OID{}},
+			{Start: OID{1, 2}, End: OID{1, 3}},
+		}},
+		{Header: Header{Type: PDU_RESPONSE, SessionID: 7, PacketID: 4}, SysUpTime: 100, Context: "ctx", VarBinds: []VarBind{
+			Integer(OID{1, 3, 6, 1, 4, 1, 1}, -5),
+			Counter64(OID{1, 3, 6, 1, 4, 1, 2}, 1<<40),
+			OctetString(OID{1, 3, 6, 1, 4, 1, 3}, "10.0.0.1"),
+			{Type: TYPE_END_OF_MIB_VIEW, Name: OID{1, 3, 6, 1, 4, 1, 4}},
+		}},
+		{Header: Header{Type: PDU_CLOSE, SessionID: 7}, Reason: CLOSE_SHUTDOWN},
+	}
+	for _, pdu := range pdus {
+		b, err := pdu.MarshalBinary()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(b)%4)
+		decoded, err := ReadPDU(bytes.NewReader(b))
+		assert.NoError(t, err)
+		pdu.Version = VERSION
+		pdu.Flags |= FLAG_NETWORK_BYTE_ORDER
+		pdu.PayloadLength = uint32(len(b) - HEADER_SIZE)
+		assert.Equal(t, pdu, decoded)
+	}
+
+	_, err := (&PDU{Header: Header{Type: PDU_RESPONSE}, VarBinds: []VarBind{{Type: TYPE_COUNTER64, Value: "1"}}}).MarshalBinary()
+	assert.Error(t, err)
+}
+
+func TestReadPDULittleEndian(t *testing.T) {
+	// GetNext of 1.3.6.1.4.1.13315 (prefix 4) in the byte order of the sender
+	b := []byte{
+		1, PDU_GET_NEXT, 0, 0,
+		7, 0, 0, 0,
+		8, 0, 0, 0,
+		9, 0, 0, 0,
+		16, 0, 0, 0,
+		2, 4, 1, 0, 1, 0, 0, 0, 0x03, 0x34, 0, 0,
+		0, 0, 0, 0,
+	}
+	pdu, err := ReadPDU(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(7), pdu.SessionID)
+	assert.Equal(t, uint32(9), pdu.PacketID)
+	assert.Equal(t, []SearchRange{{Start: OID{1, 3, 6, 1, 4, 1, 13315}, Include: true, End: OID{}}}, pdu.SearchRanges)
+
+	_, err = ReadPDU(bytes.NewReader(b[:28]))
+	assert.Error(t, err)
+}
@@ -0,0 +1,325 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentx
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const (
+	DEFAULT_MASTER    = "/var/agentx/master"
+	DEFAULT_TIMEOUT   = 5
+	DEFAULT_RECONNECT = 10
+	DEFAULT_PRIORITY  = 127
+)
+
+type (
+	// AgentX sub-agent serving a read-only subtree.
+	// The values of the subtree are replaced as a whole by Update, the requests of the master agent
+	// are answered from the last values.
+	SubAgent struct {
+		network     string
+		address     string
+		subtree     OID
+		description string
+		timeout     time.Duration
+		reconnect   time.Duration
+		started     time.Time
+
+		// []VarBind sorted by name
+		varBinds atomic.Value
+
+		// Connection to the master agent, the writes are serialized
+		mutex     sync.Mutex
+		conn      net.Conn
+		sessionID uint32
+		packetID  uint32
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+
+	SubAgentConfig struct {
+		// Unix socket of the master agent, or tcp:<host>:<port>
+		Master string
+		// Registered subtree
+		Subtree     OID
+		Description string
+		// Timeout of the requests to the master agent and of the session
+		Timeout time.Duration
+		// Delay before reconnecting to the master agent
+		Reconnect time.Duration
+	}
+)
+
+func NewSubAgent(config SubAgentConfig) *SubAgent {
+	agent := &SubAgent{
+		network:     "unix",
+		address:     config.Master,
+		subtree:     config.Subtree,
+		description: config.Description,
+		timeout:     config.Timeout,
+		reconnect:   config.Reconnect,
+		started:     time.Now(),
+		done:        make(chan struct{}),
+	}
+	switch {
+	case agent.address == "":
+		agent.address = DEFAULT_MASTER
+	case strings.HasPrefix(agent.address, "tcp:"):
+		agent.network, agent.address = "tcp", strings.TrimPrefix(agent.address, "tcp:")
+	case strings.HasPrefix(agent.address, "unix:"):
+		agent.address = strings.TrimPrefix(agent.address, "unix:")
+	}
+	if agent.timeout <= 0 {
+		agent.timeout = DEFAULT_TIMEOUT * time.Second
+	}
+	if agent.reconnect <= 0 {
+		agent.reconnect = DEFAULT_RECONNECT * time.Second
+	}
+	agent.varBinds.Store([]VarBind{})
+	return agent
+}
+
+// Replace the values of the subtree
+func (agent *SubAgent) Update(varBinds []VarBind) {
+	sorted := make([]VarBind, len(varBinds))
+	copy(sorted, varBinds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name.Compare(sorted[j].Name) < 0 })
+	agent.varBinds.Store(sorted)
+}
+
+// Serve the subtree until Close, the session is opened again when the master agent is restarted
+func (agent *SubAgent) Run() {
+	for {
+		err := agent.session()
+		select {
+		case <-agent.done:
+			return
+		default:
+		}
+		logp.Err("AgentX session with %v: %v, reconnect in %v", agent.address, err, agent.reconnect)
+		select {
+		case <-agent.done:
+			return
+		case <-time.After(agent.reconnect):
+		}
+	}
+}
+
+// Close the session with the master agent
+func (agent *SubAgent) Close() {
+	if agent == nil {
+		return
+	}
+	agent.closeOnce.Do(func() {
+		close(agent.done)
+		agent.mutex.Lock()
+		defer agent.mutex.Unlock()
+		if agent.conn != nil {
+			agent.writePDU(&PDU{Header: Header{Type: PDU_CLOSE, SessionID: agent.sessionID}, Reason: CLOSE_SHUTDOWN})
+			agent.conn.Close()
+		}
+	})
+}
+
+// Open a session, register the subtree and answer the requests of the master agent
+func (agent *SubAgent) session() error {
+	conn, err := net.DialTimeout(agent.network, agent.address, agent.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	agent.mutex.Lock()
+	select {
+	case <-agent.done:
+		agent.mutex.Unlock()
+		return nil
+	default:
+	}
+	agent.conn, agent.sessionID = conn, 0
+	agent.mutex.Unlock()
+	defer func() {
+		agent.mutex.Lock()
+		agent.conn = nil
+		agent.mutex.Unlock()
+	}()
+
+	response, err := agent.request(&PDU{
+		Header:      Header{Type: PDU_OPEN},
+		Timeout:     uint8(agent.timeout / time.Second),
+		ID:          agent.subtree,
+		Description: agent.description,
+	})
+	if err != nil {
+		return fmt.Errorf("open: %v", err)
+	}
+	agent.mutex.Lock()
+	agent.sessionID = response.SessionID
+	agent.mutex.Unlock()
+	if _, err := agent.request(&PDU{Header: Header{Type: PDU_REGISTER}, Priority: DEFAULT_PRIORITY, Subtree: agent.subtree}); err != nil {
+		return fmt.Errorf("register %v: %v", agent.subtree, err)
+	}
+	logp.Info("AgentX sub-agent registered %v on %v", agent.subtree, agent.address)
+
+	for {
+		pdu, err := ReadPDU(conn)
+		if err != nil {
+			return err
+		}
+		if pdu.Type == PDU_CLOSE {
+			return fmt.Errorf("closed by the master agent, reason %d", pdu.Reason)
+		}
+		if response := agent.handle(pdu); response != nil {
+			agent.mutex.Lock()
+			err = agent.writePDU(response)
+			agent.mutex.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Send a PDU of the sub-agent and wait for its response
+func (agent *SubAgent) request(pdu *PDU) (*PDU, error) {
+	agent.mutex.Lock()
+	agent.packetID++
+	pdu.SessionID, pdu.PacketID = agent.sessionID, agent.packetID
+	err := agent.writePDU(pdu)
+	conn := agent.conn
+	agent.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(agent.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	response, err := ReadPDU(conn)
+	if err != nil {
+		return nil, err
+	}
+	if response.Type != PDU_RESPONSE || response.PacketID != pdu.PacketID {
+		return nil, fmt.Errorf("unexpected PDU type %d, packet %d", response.Type, response.PacketID)
+	}
+	if response.Error != ERROR_NONE {
+		return nil, fmt.Errorf("error %d", response.Error)
+	}
+	return response, nil
+}
+
+// Called with the mutex locked
+func (agent *SubAgent) writePDU(pdu *PDU) error {
+	b, err := pdu.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	agent.conn.SetWriteDeadline(time.Now().Add(agent.timeout))
+	_, err = agent.conn.Write(b)
+	return err
+}
+
+// Answer a request of the master agent, nil if it has no response
+func (agent *SubAgent) handle(pdu *PDU) *PDU {
+	response := &PDU{
+		Header: Header{
+			Type:          PDU_RESPONSE,
+			SessionID:     pdu.SessionID,
+			TransactionID: pdu.TransactionID,
+			PacketID:      pdu.PacketID,
+		},
+		Context:   pdu.Context,
+		SysUpTime: uint32(time.Since(agent.started) / (10 * time.Millisecond)),
+	}
+	varBinds := agent.varBinds.Load().([]VarBind)
+	switch pdu.Type {
+	case PDU_GET:
+		for _, searchRange := range pdu.SearchRanges {
+			response.VarBinds = append(response.VarBinds, agent.get(varBinds, searchRange.Start))
+		}
+	case PDU_GET_NEXT:
+		for _, searchRange := range pdu.SearchRanges {
+			response.VarBinds = append(response.VarBinds, getNext(varBinds, searchRange))
+		}
+	case PDU_GET_BULK:
+		response.VarBinds = getBulk(varBinds, pdu)
+	case PDU_TEST_SET:
+		// The subtree is read-only
+		response.Error, response.Index = ERROR_NOT_WRITABLE, 1
+	case PDU_COMMIT_SET, PDU_UNDO_SET:
+	case PDU_CLEANUP_SET, PDU_RESPONSE:
+		return nil
+	default:
+		response.Error = ERROR_PROCESSING
+	}
+	return response
+}
+
+func (agent *SubAgent) get(varBinds []VarBind, name OID) VarBind {
+	i := sort.Search(len(varBinds), func(i int) bool { return varBinds[i].Name.Compare(name) >= 0 })
+	if i < len(varBinds) && varBinds[i].Name.Equal(name) {
+		return varBinds[i]
+	}
+	if name.HasPrefix(agent.subtree) {
+		return VarBind{Type: TYPE_NO_SUCH_INSTANCE, Name: name}
+	}
+	return VarBind{Type: TYPE_NO_SUCH_OBJECT, Name: name}
+}
+
+// Return the first value of the range, endOfMibView if there isn't any
+func getNext(varBinds []VarBind, searchRange SearchRange) VarBind {
+	i := sort.Search(len(varBinds), func(i int) bool {
+		order := varBinds[i].Name.Compare(searchRange.Start)
+		return order > 0 || (order == 0 && searchRange.Include)
+	})
+	if i < len(varBinds) && (len(searchRange.End) == 0 || varBinds[i].Name.Compare(searchRange.End) < 0) {
+		return varBinds[i]
+	}
+	return VarBind{Type: TYPE_END_OF_MIB_VIEW, Name: searchRange.Start}
+}
+
+// The non-repeaters ranges are answered once, the next ones up to max-repetitions times, one repetition after the other
+func getBulk(varBinds []VarBind, pdu *PDU) []VarBind {
+	nonRepeaters := int(pdu.NonRepeaters)
+	if nonRepeaters > len(pdu.SearchRanges) {
+		nonRepeaters = len(pdu.SearchRanges)
+	}
+	result := make([]VarBind, 0, len(pdu.SearchRanges))
+	for _, searchRange := range pdu.SearchRanges[:nonRepeaters] {
+		result = append(result, getNext(varBinds, searchRange))
+	}
+	repeaters := append([]SearchRange{}, pdu.SearchRanges[nonRepeaters:]...)
+	for repetition := 0; repetition < int(pdu.MaxRepetitions) && len(repeaters) > 0; repetition++ {
+		endOfMibView := true
+		for i, searchRange := range repeaters {
+			varBind := getNext(varBinds, searchRange)
+			result = append(result, varBind)
+			if varBind.Type != TYPE_END_OF_MIB_VIEW {
+				endOfMibView = false
+			}
+			repeaters[i].Start, repeaters[i].Include = varBind.Name, false
+		}
+		if endOfMibView {
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package agentx
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Stand-in master agent accepting one sub-agent session
+type testMaster struct {
+	t        *testing.T
+	listener net.Listener
+	conn     net.Conn
+	packetID uint32
+}
+
+func newTestMaster(t *testing.T, path string) *testMaster {
+	listener, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	return &testMaster{t: t, listener: listener}
+}
+
+func (master *testMaster) write(pdu *PDU) {
+	b, err := pdu.MarshalBinary()
+	assert.NoError(master.t, err)
+	_, err = master.conn.Write(b)
+	assert.NoError(master.t, err)
+}
+
+func (master *testMaster) read() *PDU {
+	master.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pdu, err := ReadPDU(master.conn)
+	assert.NoError(master.t, err)
+	return pdu
+}
+
+// Accept the sub-agent and answer its Open and Register PDUs
+func (master *testMaster) accept() (open *PDU, register *PDU) {
+	conn, err := master.listener.Accept()
+	assert.NoError(master.t, err)
+	master.conn = conn
+	open = master.read()
+	master.write(&PDU{Header: Header{Type: PDU_RESPONSE, SessionID: 42, PacketID: open.PacketID}})
+	register = master.read()
+	master.write(&PDU{Header: Header{Type: PDU_RESPONSE, SessionID: 42, PacketID: register.PacketID}})
+	return open, register
+}
+
+func (master *testMaster) request(pdu *PDU) *PDU {
+	master.packetID++
+	pdu.SessionID, pdu.PacketID = 42, master.packetID
+	master.write(pdu)
+	response := master.read()
+	assert.Equal(master.t, uint8(PDU_RESPONSE), response.Type)
+	assert.Equal(master.t, pdu.PacketID, response.PacketID)
+	return response
+}
+
+func varBindNames(varBinds []VarBind) []string {
+	names := []string{}
+	for _, varBind := range varBinds {
+		names = append(names, varBind.Name.String())
+	}
+	return names
+}
+
+func TestSubAgentSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agentx")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "master")
+	master := newTestMaster(t, path)
+	defer master.listener.Close()
+
+	subtree := OID{1, 3, 6, 1, 4, 1, 99, 2}
+	agent := NewSubAgent(SubAgentConfig{Master: "unix:" + path, Subtree: subtree, Description: "test", Reconnect: 10 * time.Millisecond})
+	agent.Update([]VarBind{
+		Counter64(subtree.Append(1, 1, 1, 3, 2), 12),
+		OctetString(subtree.Append(1, 1, 1, 1, 2), "10.0.0.2"),
+		OctetString(subtree.Append(1, 1, 1, 1, 1), "10.0.0.1"),
+		Counter64(subtree.Append(1, 1, 1, 3, 1), 11),
+	})
+	go agent.Run()
+
+	open, register := master.accept()
+	assert.Equal(t, "test", open.Description)
+	assert.Equal(t, subtree, register.Subtree)
+	assert.Equal(t, uint32(42), register.SessionID)
+
+	response := master.request(&PDU{Header: Header{Type: PDU_GET}, SearchRanges: []SearchRange{
+		{Start: subtree.Append(1, 1, 1, 3, 2)},
+		{Start: subtree.Append(1, 1, 1, 3, 9)},
+		{Start: OID{1, 3, 6, 1, 2, 1}},
+	}})
+	assert.Equal(t, []VarBind{
+		Counter64(subtree.Append(1, 1, 1, 3, 2), 12),
+		{Type: TYPE_NO_SUCH_INSTANCE, Name: subtree.Append(1, 1, 1, 3, 9)},
+		{Type: TYPE_NO_SUCH_OBJECT, Name: OID{1, 3, 6, 1, 2, 1}},
+	}, response.VarBinds)
+
+	// Walk in lexicographic order
+	response = master.request(&PDU{Header: Header{Type: PDU_GET_NEXT}, SearchRanges: []SearchRange{
+		{Start: subtree},
+		{Start: subtree.Append(1, 1, 1, 1, 2), Include: true},
+		{Start: subtree.Append(1, 1, 1, 1, 2), End: subtree.Append(1, 1, 1, 3)},
+		{Start: subtree.Append(1, 1, 1, 3, 2)},
+	}})
+	assert.Equal(t, []string{
+		subtree.Append(1, 1, 1, 1, 1).String(),
+		subtree.Append(1, 1, 1, 1, 2).String(),
+		subtree.Append(1, 1, 1, 1, 2).String(),
+		subtree.Append(1, 1, 1, 3, 2).String(),
+	}, varBindNames(response.VarBinds))
+	assert.Equal(t, uint16(TYPE_END_OF_MIB_VIEW), response.VarBinds[2].Type)
+	assert.Equal(t, uint16(TYPE_END_OF_MIB_VIEW), response.VarBinds[3].Type)
+
+	response = master.request(&PDU{Header: Header{Type: PDU_GET_BULK}, NonRepeaters: 1, MaxRepetitions: 3, SearchRanges: []SearchRange{
+		{Start: subtree},
+		{Start: subtree.Append(1, 1, 1, 1, 2)},
+	}})
+	assert.Equal(t, []string{
+		subtree.Append(1, 1, 1, 1, 1).String(),
+		subtree.Append(1, 1, 1, 3, 1).String(),
+		subtree.Append(1, 1, 1, 3, 2).String(),
+		subtree.Append(1, 1, 1, 3, 2).String(),
+	}, varBindNames(response.VarBinds))
+
+	response = master.request(&PDU{Header: Header{Type: PDU_TEST_SET}})
+	assert.Equal(t, uint16(ERROR_NOT_WRITABLE), response.Error)
+
+	// The sub-agent opens a new session when the master agent closes it
+	master.write(&PDU{Header: Header{Type: PDU_CLOSE, SessionID: 42}, Reason: CLOSE_SHUTDOWN})
+	master.conn.Close()
+	master.accept()
+	agent.Update([]VarBind{Integer(subtree.Append(1), 1)})
+	response = master.request(&PDU{Header: Header{Type: PDU_GET}, SearchRanges: []SearchRange{{Start: subtree.Append(1)}}})
+	assert.Equal(t, []VarBind{Integer(subtree.Append(1), 1)}, response.VarBinds)
+
+	agent.Close()
+	closePDU := master.read()
+	assert.Equal(t, uint8(PDU_CLOSE), closePDU.Type)
+	assert.Equal(t, uint8(CLOSE_SHUTDOWN), closePDU.Reason)
+}
@@ -34,6 +34,7 @@ import (
 
 	"github.com/elastic/beats/packetbeat/config"
 	"github.com/elastic/beats/packetbeat/decoder"
+	"github.com/elastic/beats/packetbeat/dnstap"
 	"github.com/elastic/beats/packetbeat/flows"
 	"github.com/elastic/beats/packetbeat/procs"
 	"github.com/elastic/beats/packetbeat/protos"
@@ -58,6 +59,13 @@ type packetbeat struct {
 	pipeline beat.Pipeline
 	transPub *publish.TransactionPublisher
 	flows    *flows.Flows
+
+	//[Bluecat] DNS statistics engine, shared by the UDP and TCP DNS analyzers
+	statistics *statsdns.StatisticsEngine
+	// dnstap input, nil if it isn't enabled. Without sniffer the beat runs until done is closed.
+	dnstap   *dnstap.Server
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
 type flags struct {
@@ -99,6 +107,7 @@ func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
 	pb := &packetbeat{
 		config:      config,
 		cmdLineArgs: cmdLineArgs,
+		done:        make(chan struct{}),
 	}
 	logp.Info("packetbeat config: %+v", config)
 
@@ -142,13 +151,44 @@ func (pb *packetbeat) init(b *beat.Beat) error {
 		return fmt.Errorf("Initializing protocol analyzers failed: %v", err)
 	}
 
+	//[Bluecat] Create DNS Statistic Module
+	pb.statistics = statsdns.InitStatisticsDNS()
+	pb.setStatisticsEngine()
+	if err := pb.setupDnstap(); err != nil {
+		return err
+	}
+
 	if err := pb.setupFlows(); err != nil {
 		return err
 	}
 
+	if cfg.Dnstap.Enabled {
+		logp.Info("Sniffer disabled, the DNS messages are read from dnstap")
+		return nil
+	}
 	return pb.setupSniffer()
 }
 
+// [Bluecat] Listen to the dnstap messages of the name server and hand them to the DNS analyzer
+func (pb *packetbeat) setupDnstap() error {
+	if !pb.config.Dnstap.Enabled {
+		return nil
+	}
+	type dnstapPlugin interface {
+		ProcessDnstap(message *dnstap.Message)
+	}
+	plugin, ok := protos.Protos.GetUDP(protos.Lookup("dns")).(dnstapPlugin)
+	if !ok {
+		return errors.New("the dnstap input requires the dns protocol")
+	}
+	server, err := dnstap.NewServer(pb.config.Dnstap.Path, plugin.ProcessDnstap)
+	if err != nil {
+		return fmt.Errorf("dnstap input failed: %v", err)
+	}
+	pb.dnstap = server
+	return nil
+}
+
 func (pb *packetbeat) setupSniffer() error {
 	config := &pb.config
 
@@ -200,7 +240,7 @@ func (pb *packetbeat) Run(b *beat.Beat) error {
 	// Show version
 	logp.Info("Start Packetbeat version: %v", Version)
 	//[Bluecat] Start DNS Statistic Module
-	statsdns.InitStatisticsDNS()
+	pb.statistics.Start()
 
 	defer func() {
 		if service.ProfileEnabled() {
@@ -225,19 +265,28 @@ func (pb *packetbeat) Run(b *beat.Beat) error {
 	var wg sync.WaitGroup
 	errC := make(chan error, 1)
 
-	// Run the sniffer in background
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		err := pb.sniff.Run()
-		if err != nil {
-			errC <- fmt.Errorf("Sniffer main loop failed: %v", err)
-		}
-	}()
+	// [Bluecat] Read the dnstap messages in background
+	if pb.dnstap != nil {
+		go pb.dnstap.Run()
+	}
 
-	logp.Debug("main", "Waiting for the sniffer to finish")
-	wg.Wait()
+	// Run the sniffer in background
+	if pb.sniff != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := pb.sniff.Run()
+			if err != nil {
+				errC <- fmt.Errorf("Sniffer main loop failed: %v", err)
+			}
+		}()
+
+		logp.Debug("main", "Waiting for the sniffer to finish")
+		wg.Wait()
+	} else {
+		<-pb.done
+	}
 
 	select {
 	default:
@@ -251,8 +300,26 @@ func (pb *packetbeat) Run(b *beat.Beat) error {
 // Called by the Beat stop function
 func (pb *packetbeat) Stop() {
 	logp.Info("Packetbeat send stop signal")
-	pb.sniff.Stop()
-	statsdns.Stop()
+	if pb.sniff != nil {
+		pb.sniff.Stop()
+	}
+	pb.dnstap.Close()
+	pb.stopOnce.Do(func() { close(pb.done) })
+	pb.statistics.Stop()
+}
+
+// [Bluecat] Inject the statistics engine into the DNS analyzers
+func (pb *packetbeat) setStatisticsEngine() {
+	type statisticsPlugin interface {
+		SetStatisticsEngine(statistics *statsdns.StatisticsEngine)
+	}
+	dns := protos.Lookup("dns")
+	if plugin, ok := protos.Protos.GetUDP(dns).(statisticsPlugin); ok {
+		plugin.SetStatisticsEngine(pb.statistics)
+	}
+	if plugin, ok := protos.Protos.GetTCP(dns).(statisticsPlugin); ok {
+		plugin.SetStatisticsEngine(pb.statistics)
+	}
 }
 
 func (pb *packetbeat) createWorker(dl layers.LinkType) (sniffer.Worker, error) {
@@ -35,7 +35,16 @@ type Config struct {
 	ShutdownTimeout time.Duration             `config:"shutdown_timeout"`
 
 	// [Bluecat]
-	DropSniffedPacket bool `config:"drop_sniffed_packet"`
+	DropSniffedPacket bool   `config:"drop_sniffed_packet"`
+	Dnstap            Dnstap `config:"dnstap"`
+}
+
+// [Bluecat] dnstap input: the DNS messages logged by the name server on a Unix socket, in place of
+// the captured packets
+type Dnstap struct {
+	Enabled bool `config:"enabled"`
+	// Socket created for the name server, /var/run/named/dnstap.sock if empty
+	Path string `config:"path"`
 }
 
 type InterfacesConfig struct {
@@ -15,16 +15,15 @@
 package config_statistics
 
 import (
-	"bufio"
 	"encoding/json"
 	"net"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/namedconf"
 )
 
 type ConfigStatistics struct {
@@ -33,44 +32,152 @@ type ConfigStatistics struct {
 	MaximumClients               int           `json:"maximum_clients"`
 	UrlAnnouncementDeployFromBam string        `json:"url_announcement_bam_deploy"`
 	StatHTTPServerAddr			 string        `json:"http_server_address"`
-	IntervalClearOutStatisCache  int           `json:"interval_clear_outstatis_cache"`
+	QueryTypes                   []string      `json:"query_types"`
+	TopNames                     int           `json:"top_names"`
+	Zones                        []string      `json:"zones"`
+	MaximumZones                 int           `json:"maximum_zones"`
+	Shards                       int           `json:"shards"`
+	HistorySize                  int           `json:"history_size"`
+
+	// Destinations of the interval statistics, only the SNMP sub-agent at statistics_destination if empty
+	Exporters []ExporterConfig `json:"exporters"`
+	// AgentX sub-agent serving BCN-DNS-AGENT-MIB
+	AgentX AgentXConfig `json:"agentx"`
+	// Log of the DNS transactions
+	QueryLog QueryLogConfig `json:"query_log"`
+}
+
+// Destination of the interval statistics
+type ExporterConfig struct {
+	Type        string      `json:"type"`
+	Enabled     bool        `json:"enabled"`
+	Format      string      `json:"format"`
+	Destination string      `json:"destination"`
+	Retry       RetryConfig `json:"retry"`
+	Spool       SpoolConfig `json:"spool"`
+
+	// Options of the statsd exporter
+	StatsD StatsDConfig `json:"statsd"`
+	// Options of the influxdb exporter
+	InfluxDB InfluxDBConfig `json:"influxdb"`
+}
+
+// Retry policy of an exporter when an interval couldn't be exported
+type RetryConfig struct {
+	// Number of retries of an interval, 0 for no retry
+	MaxRetries int `json:"max_retries"`
+	// Delay in seconds before the first retry, doubled at each retry
+	Backoff int `json:"backoff"`
+	// Maximum delay in seconds between two retries, 60 if 0
+	MaxBackoff int `json:"max_backoff"`
+}
+
+// Options of the StatsD / DogStatsD exporter
+type StatsDConfig struct {
+	// Prefix of the metric names
+	Prefix string `json:"prefix"`
+	// Instance tag, the host name if empty
+	Instance string `json:"instance"`
+	// Part of the counters sent, with their sample rate so that the daemon scales them; 1 if 0
+	SampleRate float64 `json:"sample_rate"`
+	// Maximum size of a datagram, the lines are packed up to this size; 1432 if 0
+	MaxPacketSize int `json:"max_packet_size"`
+}
+
+// Options of the InfluxDB line protocol exporter
+type InfluxDBConfig struct {
+	// Prefix of the measurements, the measurement of an entry is the prefix followed by its type
+	Prefix string `json:"prefix"`
+	// Host tag, the host name if empty
+	Host string `json:"host"`
+	// Precision of the timestamps: ns, us, ms or s; ns if empty
+	Precision string `json:"precision"`
+	// Credentials of the HTTP write endpoint: basic authentication (InfluxDB 1.x, VictoriaMetrics) or token (InfluxDB 2.x)
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+	// Rotation of the local file: size in MB, number of rotated files kept and rotation interval in seconds (0: by size only)
+	MaxSize        int `json:"max_size"`
+	MaxBackups     int `json:"max_backups"`
+	RotateInterval int `json:"rotate_interval"`
+}
+
+// AgentX sub-agent registered with the master agent (snmpd), in place of the SNMP sub-agent container
+type AgentXConfig struct {
+	Enabled bool `json:"enabled"`
+	// Unix socket of the master agent or tcp:<host>:<port>, /var/agentx/master if empty
+	Master string `json:"master"`
+	// OID of bcnDnsStatAgent
+	RootOID string `json:"root_oid"`
+	// Timeout in seconds of the session with the master agent
+	Timeout int `json:"timeout"`
+	// Delay in seconds before reconnecting to the master agent
+	Reconnect int `json:"reconnect"`
+}
+
+// Log of the DNS transactions, one entry per transaction written in the background
+type QueryLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// File of the log, relative to the packetbeat directory; querylog/queries.log (queries.cbor in CBOR) if empty
+	Path string `json:"path"`
+	// ndjson or cbor (a CBOR sequence), ndjson if empty
+	Format string `json:"format"`
+	// Rotation: size in MB (100 if 0), number of rotated files kept (10 if 0) and rotation interval in seconds (0: by size only)
+	MaxSize        int `json:"max_size"`
+	MaxBackups     int `json:"max_backups"`
+	RotateInterval int `json:"rotate_interval"`
+	// Gzip the rotated files
+	Compress bool `json:"compress"`
+	// Only the transactions of these views and of these clients (IPs or CIDRs) are logged, all if empty
+	Views   []string `json:"views"`
+	Clients []string `json:"clients"`
+	// Number of transactions waiting to be written, the transactions are dropped beyond; 10000 if 0
+	QueueSize int `json:"queue_size"`
+}
+
+// On-disk spool of the intervals an exporter couldn't deliver, replayed in order once the destination recovers
+type SpoolConfig struct {
+	// Directory of the spool, relative to the packetbeat directory; spool/<type> if empty
+	Path string `json:"path"`
+	// Maximum size in MB, the oldest intervals are dropped beyond
+	MaxSize int `json:"max_size"`
+	// Maximum age in seconds of a spooled interval
+	MaxAge int `json:"max_age"`
 }
 
 var (
-	ConfigStat              = ConfigStatistics{IntervalClearOutStatisCache: 180, StatisticsInterval: 60}
-	NAMED_CONFIG_PATH       = `/replicated/jail/named/etc/named.conf`
-	REGEX_PURE_IPV4         = `((\d){1,3}\.){3}(\d){1,3}$`
-	REGEX_PURE_IPV4_RANGE   = `((\d){1,3}\.){3}(\d){1,3}\/(\d){1,3}$`
-	REGEX_PURE_IP_V6        = `(?:(?:(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):){6})(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):(?:(?:[0-9a-fA-F]{1,4})))|(?:(?:(?:(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9]))\.){3}(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9])))))))|(?:(?:::(?:(?:(?:[0-9a-fA-F]{1,4})):){5})(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):(?:(?:[0-9a-fA-F]{1,4})))|(?:(?:(?:(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9]))\.){3}(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9])))))))|(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})))?::(?:(?:(?:[0-9a-fA-F]{1,4})):){4})(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):(?:(?:[0-9a-fA-F]{1,4})))|(?:(?:(?:(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9]))\.){3}(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9])))))))|(?:(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):){0,1}(?:(?:[0-9a-fA-F]{1,4})))?::(?:(?:(?:[0-9a-fA-F]{1,4})):){3})(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):(?:(?:[0-9a-fA-F]{1,4})))|(?:(?:(?:(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9]))\.){3}(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9])))))))|(?:(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):){0,2}(?:(?:[0-9a-fA-F]{1,4})))?::(?:(?:(?:[0-9a-fA-F]{1,4})):){2})(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):(?:(?:[0-9a-fA-F]{1,4})))|(?:(?:(?:(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9]))\.){3}(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9])))))))|(?:(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):){0,3}(?:(?:[0-9a-fA-F]{1,4})))?::(?:(?:[0-9a-fA-F]{1,4})):)(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):(?:(?:[0-9a-fA-F]{1,4})))|(?:(?:(?:(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9]))\.){3}(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9])))))))|(?:(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):){0,4}(?:(?:[0-9a-fA-F]{1,4})))?::)(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):(?:(?:[0-9a-fA-F]{1,4})))|(?:(?:(?:(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9]))\.){3}(?:(?:25[0-5]|(?:[1-9]|1[0-9]|2[0-4])?[0-9])))))))|(?:(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):){0,5}(?:(?:[0-9a-fA-F]{1,4})))?::)(?:(?:[0-9a-fA-F]{1,4})))|(?:(?:(?:(?:(?:(?:[0-9a-fA-F]{1,4})):){0,6}(?:(?:[0-9a-fA-F]{1,4})))?::))))$`
-	REGEX_PURE_IP_V6_RANGE  = `\s*((([0-9A-Fa-f]{1,4}:){7}([0-9A-Fa-f]{1,4}|:))|(([0-9A-Fa-f]{1,4}:){6}(:[0-9A-Fa-f]{1,4}|((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])(\.(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])){3})|:))|(([0-9A-Fa-f]{1,4}:){5}(((:[0-9A-Fa-f]{1,4}){1,2})|:((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])(\.(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])){3})|:))|(([0-9A-Fa-f]{1,4}:){4}(((:[0-9A-Fa-f]{1,4}){1,3})|((:[0-9A-Fa-f]{1,4})?:((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])(\.(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])){3}))|:))|(([0-9A-Fa-f]{1,4}:){3}(((:[0-9A-Fa-f]{1,4}){1,4})|((:[0-9A-Fa-f]{1,4}){0,2}:((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])(\.(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])){3}))|:))|(([0-9A-Fa-f]{1,4}:){2}(((:[0-9A-Fa-f]{1,4}){1,5})|((:[0-9A-Fa-f]{1,4}){0,3}:((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])(\.(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])){3}))|:))|(([0-9A-Fa-f]{1,4}:){1}(((:[0-9A-Fa-f]{1,4}){1,6})|((:[0-9A-Fa-f]{1,4}){0,4}:((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])(\.(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])){3}))|:))|(:(((:[0-9A-Fa-f]{1,4}){1,7})|((:[0-9A-Fa-f]{1,4}){0,5}:((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])(\.(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])){3}))|:)))(%.+)?\s*/[0-9]{1,3}$`
-	REGEX_VIEW              = `^view.+\".+\"`
-	FORMAT_ACL_CLIENTS      = "acl _TrafficStatisticsAgent_Clients"
-	FORMAT_ACL_SERVERS      = "acl _TrafficStatisticsAgent_Servers"
-	FORMAT_PURE_ACL_CLIENTS = "_TrafficStatisticsAgent_Clients"
-	FORMAT_MATCH_CLIENTS    = "match-clients"
-	ANY                     = "any"
-	PREFIX_ACL              = "acl"
-	REGEX_ACL_NAME          = `^acl .+ {`
-	regView, _              = regexp.Compile(REGEX_VIEW)
-	RegPureIpv4, _          = regexp.Compile(REGEX_PURE_IPV4)
-	RegPureIpv4Range, _     = regexp.Compile(REGEX_PURE_IPV4_RANGE)
-	RegPureIpv6, _          = regexp.Compile(REGEX_PURE_IP_V6)
-	RegPureIpv6Range, _     = regexp.Compile(REGEX_PURE_IP_V6_RANGE)
-	RegAclName, _           = regexp.Compile(REGEX_ACL_NAME)
-	ACLMap                  = make(map[string][]string, 0)
+	DefaultConfigStat = ConfigStatistics{StatisticsInterval: 60, MaximumClients: 200, TopNames: 10, MaximumZones: 200, HistorySize: 60}
+	ConfigStat        = DefaultConfigStat
+	NAMED_CONFIG_PATH = `/replicated/jail/named/etc/named.conf`
+	// Directory named is chrooted to, the included files of named.conf are relative to it
+	NAMED_ROOT_PATH = `/replicated/jail/named`
+
+	// Prefixes of the ACLs of named.conf listing the clients and the servers of the statistics
+	ACL_CLIENTS = "_TrafficStatisticsAgent_Clients"
+	ACL_SERVERS = "_TrafficStatisticsAgent_Servers"
+	ANY         = "any"
 )
 
 func Init() {
+	statisticsConfigPath := ResolvePath("statistics_config.json")
+	ConfigStat = LoadConfiguration(statisticsConfigPath)
+}
+
+// Return the absolute path of a file, relative paths are relative to the packetbeat directory
+func ResolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
 	baseDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 	if err != nil {
 		panic(err)
 	}
-	statisticsConfigPath := filepath.Join(baseDir, "statistics_config.json")
-	ConfigStat = LoadConfiguration(statisticsConfigPath)
+	return filepath.Join(baseDir, path)
 }
 
 func LoadConfiguration(file string) ConfigStatistics {
-	var config ConfigStatistics
+	// Keys missing in the file keep their default value
+	config := DefaultConfigStat
 	configFile, err := os.Open(file)
 	defer configFile.Close()
 	if err != nil {
@@ -81,173 +188,124 @@ func LoadConfiguration(file string) ConfigStatistics {
 	return config
 }
 
-func ReadACLInNamedConfig() ([]*net.IPNet, []*net.IPNet, []string, []string, map[int]map[string][]string) {
+// Read the IPs and networks of the statistics ACLs and the match-clients of the views in named.conf and
+// the files it includes. The match-clients of the view at index i of named.conf are at index i of the map,
+// keyed by the view name, the ACLs they reference are expanded and the excluded addresses are prefixed with !.
+// The built-in ACLs localhost and localnets are the addresses and networks of the interfaces of the host.
+// The views are also compiled to choose the view of a query like named, nil if they couldn't be.
+func ReadACLInNamedConfig() ([]*net.IPNet, []*net.IPNet, []string, []string, map[int]map[string][]string, *namedconf.ViewMatcher) {
 	logp.Info("Reading named.config at path %s", NAMED_CONFIG_PATH)
-	CollectMapACL()
 	IPServerRangesInACL := make([]*net.IPNet, 0)
 	IPClientRangesInACL := make([]*net.IPNet, 0)
 	IPsClientInACL := make([]string, 0)
 	IPsServerInACL := make([]string, 0)
 	MapViewIPs := make(map[int]map[string][]string, 0)
-	IndexLastViewMap := make(map[string][]string, 0)
-	lastView := ""
-	viewIndex := -1
-	file, err := os.Open(NAMED_CONFIG_PATH)
-	if err == nil {
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := scanner.Text()
-			//Find view field a line of named.conf file
-			if viewName := readViewName(line); viewName != "" {
-				lastView = viewName
-				viewIndex += 1
-				IndexLastViewMap = make(map[string][]string, 0)
-			}
-
-			switch {
-			case strings.Contains(line, FORMAT_MATCH_CLIENTS):
-				arrayIPsString := getArrayStringFromLineRecursive(line)
-				logp.Debug("ReadACLInNamedConfig", "Ip Array %v", arrayIPsString)
-				for _, value := range arrayIPsString {
-					trimedValue := strings.TrimSpace(value)
-					//Normal case
-					if trimedValue == ANY ||
-						RegPureIpv4.MatchString(trimedValue) ||
-						RegPureIpv4Range.MatchString(trimedValue) ||
-						RegPureIpv6.MatchString(trimedValue) ||
-						RegPureIpv6Range.MatchString(trimedValue) {
-						IndexLastViewMap[lastView] = append(IndexLastViewMap[lastView], strings.ToLower(trimedValue))
-					}
-				}
-				MapViewIPs[viewIndex] = IndexLastViewMap
-			case strings.Contains(line, FORMAT_ACL_CLIENTS):
-				tmp_IPClientRangesInACL, tmp_IPsClientInACL := getIPsInLine(line)
-				IPClientRangesInACL = append(IPClientRangesInACL, tmp_IPClientRangesInACL...)
-				IPsClientInACL = append(IPsClientInACL, tmp_IPsClientInACL...)
-
-			case strings.Contains(line, FORMAT_ACL_SERVERS):
-				tmp_IPServerRangesInACL, tmp_IPsServerInACL := getIPsInLine(line)
-				IPServerRangesInACL = append(IPServerRangesInACL, tmp_IPServerRangesInACL...)
-				IPsServerInACL = append(IPsServerInACL, tmp_IPsServerInACL...)
-			}
-
-		}
-		if err := scanner.Err(); err != nil {
+	namedConfig, err := namedconf.ParseFile(NAMED_CONFIG_PATH, NAMED_ROOT_PATH)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logp.Err("named.conf file doesn't exist: %v", err.Error())
+		} else {
 			logp.Err("Reading named.conf has an error: %v", err.Error())
-			IPServerRangesInACL = make([]*net.IPNet, 0)
-			IPClientRangesInACL = make([]*net.IPNet, 0)
-			IPsClientInACL = make([]string, 0)
-			IPsServerInACL = make([]string, 0)
 		}
-	} else {
-		logp.Err("named.conf file doesn't exist: %v", err.Error())
+		return IPServerRangesInACL, IPClientRangesInACL, IPsServerInACL, IPsClientInACL, MapViewIPs, nil
 	}
-	return IPServerRangesInACL, IPClientRangesInACL, IPsServerInACL, IPsClientInACL, MapViewIPs
-}
-
-func CollectMapACL() {
-	logp.Debug("CollectMapACL", "Starting collect ACL")
-	ACLMap = make(map[string][]string, 0)
-	file, err := os.Open(NAMED_CONFIG_PATH)
-	if err == nil {
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := scanner.Text()
-			//========================Collect acl======================================
-			if aclStringMatched := RegAclName.FindString(line); aclStringMatched != "" {
-				aclName := strings.Split(aclStringMatched, " ")[1]
-				arrayIPsString := getArrayStringFromLine(line)
-				ACLMap[aclName] = arrayIPsString
-			}
-
-		}
-	} else {
-		logp.Err("named.conf file doesn't exist: %v", err.Error())
+	host, err := namedconf.ReadHostAddresses()
+	if err != nil {
+		logp.Err("Reading the addresses of localhost and localnets has an error: %v", err.Error())
+		host = namedconf.NewHostAddresses(nil)
 	}
-	logp.Debug("CollectMapACL", "Done collect ACL")
-}
+	acls := &namedACLs{config: namedConfig, host: host}
 
-func getIPsInLine(line string) (IPRangesInACL []*net.IPNet, IPsInACL []string) {
-	arrayStrIpRange, arrayStrIp, arrayStrIpV6Range, arrayStrIpV6 := matchRegexIps(line)
-	for _, s := range arrayStrIpRange {
-		_, ipNet, _ := net.ParseCIDR(strings.Replace(s, ";", "", -1))
-		IPRangesInACL = append(IPRangesInACL, ipNet)
-	}
-	for _, s := range arrayStrIpV6Range {
-		_, ipNet, _ := net.ParseCIDR(strings.Replace(s, ";", "", -1))
-		IPRangesInACL = append(IPRangesInACL, ipNet)
+	for index, view := range namedConfig.Views {
+		matchClients := make([]string, 0)
+		acls.appendMatchClients(view.MatchClients, &matchClients, map[string]bool{})
+		logp.Debug("ReadACLInNamedConfig", "View %s match-clients %v", view.Name, matchClients)
+		MapViewIPs[index] = map[string][]string{view.Name: matchClients}
 	}
-	for _, s := range arrayStrIp {
-		IPsInACL = append(IPsInACL, strings.Replace(s, ";", "", -1))
+	for _, acl := range namedConfig.ACLs {
+		switch {
+		case strings.HasPrefix(acl.Name, ACL_CLIENTS):
+			IPClientRangesInACL, IPsClientInACL = acls.addresses(acl.Elements, IPClientRangesInACL, IPsClientInACL, map[string]bool{acl.Name: true})
+		case strings.HasPrefix(acl.Name, ACL_SERVERS):
+			IPServerRangesInACL, IPsServerInACL = acls.addresses(acl.Elements, IPServerRangesInACL, IPsServerInACL, map[string]bool{acl.Name: true})
+		}
 	}
-	for _, s := range arrayStrIpV6 {
-		// Append IPv6 Lowcase
-		IPsInACL = append(IPsInACL, strings.ToLower(strings.Replace(s, ";", "", -1)))
+	views, err := namedconf.CompileViews(namedConfig, host)
+	if err != nil {
+		logp.Err("Compiling the views of named.conf has an error: %v", err.Error())
 	}
-	return
-}
-
-func getArrayStringFromLine(line string) (arrayIPsString []string) {
-	ipsString := line[strings.Index(line, "{")+1 : strings.Index(line, "}")]
-	arrayIPsString = strings.Split(ipsString, ";")
-	return
+	return IPServerRangesInACL, IPClientRangesInACL, IPsServerInACL, IPsClientInACL, MapViewIPs, views
 }
 
-func getArrayStringFromLineRecursive(line string) (arrayIpsRecursive []string) {
-	ipsString := line[strings.Index(line, "{")+1 : strings.Index(line, "}")]
-	arrayIPsString := strings.Split(ipsString, ";")
-	arrayIpsRecursive = getIPArrayFromACLRecursive(arrayIPsString)
-	return
+// ACLs of named.conf and built-in ACLs
+type namedACLs struct {
+	config *namedconf.Config
+	host   *namedconf.HostAddresses
 }
 
-func getIPArrayFromACLRecursive(arrayIPsString []string) (ipRange []string) {
-	for _, ipString := range arrayIPsString {
-		trimedValue := strings.TrimSpace(ipString)
-		if _, exist := ACLMap[trimedValue]; exist {
-			ipArrayRecur := getIPArrayFromACLRecursive(ACLMap[trimedValue])
-			ipRange = append(ipRange, ipArrayRecur...)
-		} else {
-			ipRange = append(ipRange, trimedValue)
+// Append the addresses of a match-clients list, any and the addresses of the referenced ACLs.
+// The keys and the excluded ACLs other than localhost and localnets can't be represented and are skipped.
+func (a *namedACLs) appendMatchClients(elements []*namedconf.AddressMatchElement, matchClients *[]string, visited map[string]bool) {
+	for _, element := range elements {
+		prefix := ""
+		if element.Negated {
+			prefix = "!"
 		}
-	}
-	return
-}
-
-func stringInSlice(checkString string, list []string) bool {
-	for _, b := range list {
-		if b == checkString {
-			return true
+		switch {
+		case element.Address != "":
+			*matchClients = append(*matchClients, prefix+element.Address)
+		case element.ACL != "" && namedconf.IsBuiltinACL(element.ACL):
+			switch strings.ToLower(element.ACL) {
+			case namedconf.ACL_ANY:
+				if !element.Negated {
+					*matchClients = append(*matchClients, ANY)
+				}
+			case namedconf.ACL_LOCALHOST:
+				for _, ipNet := range a.host.Localhost {
+					*matchClients = append(*matchClients, prefix+ipNet.IP.String())
+				}
+			case namedconf.ACL_LOCALNETS:
+				for _, ipNet := range a.host.Localnets {
+					*matchClients = append(*matchClients, prefix+ipNet.String())
+				}
+			}
+		case element.Negated:
+		case element.List != nil:
+			a.appendMatchClients(element.List, matchClients, visited)
+		case element.ACL != "":
+			if acl := a.config.ACL(element.ACL); acl != nil && !visited[acl.Name] {
+				visited[acl.Name] = true
+				a.appendMatchClients(acl.Elements, matchClients, visited)
+			}
 		}
 	}
-	return false
-}
-
-func readViewName(line string) string {
-	stringView := regView.FindAllString(line, -1)
-	if len(stringView) > 0 {
-		arrayString := strings.Split(stringView[0], " ")
-		return strings.Replace(arrayString[len(arrayString)-1], "\"", "", -1)
-	}
-	return ""
 }
 
-func matchRegexIps(line string) (arrayStrIpRange []string, arrayStrIp []string, arrayStrIpV6Range []string, arrayStrIpV6 []string) {
-	arrayIpsRecursive := getArrayStringFromLineRecursive(line)
-	for _, ipString := range arrayIpsRecursive {
-		trimedValue := strings.TrimSpace(ipString)
-		if RegPureIpv4.MatchString(trimedValue) {
-			arrayStrIp = append(arrayStrIp, trimedValue)
-		} else if RegPureIpv6.MatchString(trimedValue) {
-			arrayStrIpV6 = append(arrayStrIpV6, trimedValue)
-		} else if RegPureIpv4Range.MatchString(trimedValue) {
-			arrayStrIpRange = append(arrayStrIpRange, trimedValue)
-		} else if RegPureIpv6Range.MatchString(trimedValue) {
-			arrayStrIpV6Range = append(arrayStrIpV6Range, trimedValue)
+// Networks and IPs of a statistics ACL, including the ones of the ACLs it references
+func (a *namedACLs) addresses(elements []*namedconf.AddressMatchElement, ipNets []*net.IPNet, ips []string, visited map[string]bool) ([]*net.IPNet, []string) {
+	for _, element := range elements {
+		switch {
+		case element.Negated:
+		case element.Address != "":
+			if strings.Contains(element.Address, "/") {
+				ipNets = append(ipNets, element.Prefix)
+			} else {
+				ips = append(ips, element.Address)
+			}
+		case element.List != nil:
+			ipNets, ips = a.addresses(element.List, ipNets, ips, visited)
+		case strings.ToLower(element.ACL) == namedconf.ACL_LOCALHOST:
+			for _, ipNet := range a.host.Localhost {
+				ips = append(ips, ipNet.IP.String())
+			}
+		case strings.ToLower(element.ACL) == namedconf.ACL_LOCALNETS:
+			ipNets = append(ipNets, a.host.Localnets...)
+		case element.ACL != "" && !namedconf.IsBuiltinACL(element.ACL):
+			if acl := a.config.ACL(element.ACL); acl != nil && !visited[acl.Name] {
+				visited[acl.Name] = true
+				ipNets, ips = a.addresses(acl.Elements, ipNets, ips, visited)
+			}
 		}
 	}
-	return
+	return ipNets, ips
 }
-
-
@@ -0,0 +1,148 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package config_statistics
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/namedconf"
+)
+
+// Use a named.conf of the given content while running the test
+func withNamedConf(t *testing.T, content string, test func()) {
+	dir, err := ioutil.TempDir("", "namedconf")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "named.conf")
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644)) {
+		return
+	}
+	defer func(path, root string) { NAMED_CONFIG_PATH, NAMED_ROOT_PATH = path, root }(NAMED_CONFIG_PATH, NAMED_ROOT_PATH)
+	NAMED_CONFIG_PATH, NAMED_ROOT_PATH = path, dir
+	test()
+}
+
+func TestReadACLInNamedConfig(t *testing.T) {
+	withNamedConf(t, `
+acl "_TrafficStatisticsAgent_Clients" {
+	10.0.0.0/8;
+	192.0.2.1;   # resolver
+	2001:DB8::1;
+};
+acl _TrafficStatisticsAgent_Servers { 192.0.2.53; 198.51.100.0/24; !192.0.2.54; };
+acl _TrafficStatisticsAgent_Servers_Region_1 { 203.0.113.53; };
+acl "lab" { 172.16.0.0/12; key "k"; };
+view "internal" {
+	match-clients {
+		!172.16.1.1;
+		"lab";
+		{ 192.0.2.10; };
+		!lab;
+	};
+};
+view "tsig" { match-recursive-only yes; };
+view "external" { match-clients { ANY; }; };
+`, func() {
+		ipNetsServer, ipNetsClient, ipsServer, ipsClient, views, matcher := ReadACLInNamedConfig()
+		if assert.Len(t, ipNetsClient, 1) {
+			assert.Equal(t, "10.0.0.0/8", ipNetsClient[0].String())
+		}
+		assert.Equal(t, []string{"192.0.2.1", "2001:db8::1"}, ipsClient)
+		if assert.Len(t, ipNetsServer, 1) {
+			assert.Equal(t, "198.51.100.0/24", ipNetsServer[0].String())
+		}
+		assert.Equal(t, []string{"192.0.2.53", "203.0.113.53"}, ipsServer)
+		assert.Equal(t, map[int]map[string][]string{
+			0: {"internal": {"!172.16.1.1", "172.16.0.0/12", "192.0.2.10"}},
+			1: {"tsig": {}},
+			2: {"external": {"any"}},
+		}, views)
+		assert.Equal(t, "internal", matcher.Match(&namedconf.ViewQuery{Client: net.ParseIP("192.0.2.10")}))
+		assert.Equal(t, "tsig", matcher.Match(&namedconf.ViewQuery{Client: net.ParseIP("172.16.1.1"), Recursive: true}))
+		assert.Equal(t, "external", matcher.Match(&namedconf.ViewQuery{Client: net.ParseIP("172.16.1.1")}))
+	})
+}
+
+func TestReadACLInNamedConfigIncludesAndBuiltins(t *testing.T) {
+	withNamedConf(t, `
+include "/named.acls";
+view "local" { match-clients { !localhost; localnets; none; }; };
+view "rest" { match-clients { !any; any; }; };
+`, func() {
+		content := "acl _TrafficStatisticsAgent_Servers { localhost; localnets; any; none; };\n"
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(NAMED_ROOT_PATH, "named.acls"), []byte(content), 0644)) {
+			return
+		}
+		host, err := namedconf.ReadHostAddresses()
+		if !assert.NoError(t, err) {
+			return
+		}
+		localIPs, localNets := []string{}, []string{}
+		for _, ipNet := range host.Localhost {
+			localIPs = append(localIPs, ipNet.IP.String())
+		}
+		for _, ipNet := range host.Localnets {
+			localNets = append(localNets, ipNet.String())
+		}
+		matchLocal := []string{}
+		for _, ip := range localIPs {
+			matchLocal = append(matchLocal, "!"+ip)
+		}
+		matchLocal = append(matchLocal, localNets...)
+
+		ipNetsServer, _, ipsServer, _, views, _ := ReadACLInNamedConfig()
+		assert.Equal(t, localIPs, ipsServer)
+		assert.Equal(t, host.Localnets, ipNetsServer)
+		assert.Equal(t, map[int]map[string][]string{
+			0: {"local": matchLocal},
+			1: {"rest": {"any"}},
+		}, views)
+	})
+}
+
+func TestReadACLInNamedConfigErrors(t *testing.T) {
+	withNamedConf(t, "acl _TrafficStatisticsAgent_Clients {\n\t10.0.0.0/8\n};\n", func() {
+		ipNetsServer, ipNetsClient, ipsServer, ipsClient, views, matcher := ReadACLInNamedConfig()
+		assert.Empty(t, ipNetsServer)
+		assert.Empty(t, ipNetsClient)
+		assert.Empty(t, ipsServer)
+		assert.Empty(t, ipsClient)
+		assert.Empty(t, views)
+		assert.Nil(t, matcher)
+	})
+
+	// The views referencing an ACL cycle can't be compiled
+	withNamedConf(t, "acl a { 10.0.0.0/8; b; };\nacl b { a; };\nview v { match-clients { a; }; };\n", func() {
+		_, _, _, _, views, matcher := ReadACLInNamedConfig()
+		assert.Equal(t, map[int]map[string][]string{0: {"v": {"10.0.0.0/8"}}}, views)
+		assert.Nil(t, matcher)
+	})
+
+	defer func(old string) { NAMED_CONFIG_PATH = old }(NAMED_CONFIG_PATH)
+	NAMED_CONFIG_PATH = "/nonexistent/named.conf"
+	_, _, _, _, views, matcher := ReadACLInNamedConfig()
+	assert.Empty(t, views)
+	assert.Nil(t, matcher)
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Frame Streams (https://farsightsec.github.io/fstrm/) control frames and content type of dnstap
+const (
+	CONTROL_ACCEPT = 0x01
+	CONTROL_START  = 0x02
+	CONTROL_STOP   = 0x03
+	CONTROL_READY  = 0x04
+	CONTROL_FINISH = 0x05
+
+	CONTROL_FIELD_CONTENT_TYPE = 0x01
+
+	CONTENT_TYPE           = "protobuf:dnstap.Dnstap"
+	MAX_CONTROL_FRAME_SIZE = 512
+	MAX_DATA_FRAME_SIZE    = 256 * 1024
+)
+
+type (
+	controlFrame struct {
+		controlType  uint32
+		contentTypes []string
+	}
+
+	// Reader of the data frames of a Frame Stream. A bidirectional stream (the Unix socket of
+	// the name server) starts with READY/ACCEPT/START and ends with STOP/FINISH,
+	// a unidirectional one (a file) starts with START and ends with STOP.
+	frameReader struct {
+		reader *bufio.Reader
+		writer io.Writer
+		frame  []byte
+	}
+)
+
+// Read the control frames starting the stream, writer is nil for a unidirectional stream
+func newFrameReader(r io.Reader, writer io.Writer) (*frameReader, error) {
+	fr := &frameReader{reader: bufio.NewReader(r), writer: writer}
+	control, err := fr.readControlFrame()
+	if err != nil {
+		return nil, err
+	}
+	if control.controlType == CONTROL_READY {
+		if writer == nil {
+			return nil, fmt.Errorf("READY frame in a unidirectional stream")
+		}
+		if !control.accepts(CONTENT_TYPE) {
+			return nil, fmt.Errorf("content types %v aren't %v", control.contentTypes, CONTENT_TYPE)
+		}
+		if err := writeControlFrame(writer, CONTROL_ACCEPT, CONTENT_TYPE); err != nil {
+			return nil, err
+		}
+		if control, err = fr.readControlFrame(); err != nil {
+			return nil, err
+		}
+	}
+	if control.controlType != CONTROL_START {
+		return nil, fmt.Errorf("unexpected control frame %d instead of START", control.controlType)
+	}
+	if !control.accepts(CONTENT_TYPE) {
+		return nil, fmt.Errorf("content types %v aren't %v", control.contentTypes, CONTENT_TYPE)
+	}
+	return fr, nil
+}
+
+// True if the frame has no content type or has the content type
+func (control *controlFrame) accepts(contentType string) bool {
+	if len(control.contentTypes) == 0 {
+		return true
+	}
+	for _, t := range control.contentTypes {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the next data frame, io.EOF once the writer has stopped the stream.
+// The frame is valid until the next read.
+func (fr *frameReader) ReadFrame() ([]byte, error) {
+	length, err := fr.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		control, err := fr.readControlBody()
+		if err != nil {
+			return nil, err
+		}
+		if control.controlType != CONTROL_STOP {
+			return nil, fmt.Errorf("unexpected control frame %d", control.controlType)
+		}
+		if fr.writer != nil {
+			if err := writeControlFrame(fr.writer, CONTROL_FINISH, ""); err != nil {
+				return nil, err
+			}
+		}
+		return nil, io.EOF
+	}
+	if length > MAX_DATA_FRAME_SIZE {
+		return nil, fmt.Errorf("data frame of %d bytes", length)
+	}
+	if cap(fr.frame) < int(length) {
+		fr.frame = make([]byte, length)
+	}
+	fr.frame = fr.frame[:length]
+	if _, err := io.ReadFull(fr.reader, fr.frame); err != nil {
+		return nil, err
+	}
+	return fr.frame, nil
+}
+
+func (fr *frameReader) readUint32() (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(fr.reader, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// Read a control frame: the escape sequence, its length, its type and its fields
+func (fr *frameReader) readControlFrame() (*controlFrame, error) {
+	escape, err := fr.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if escape != 0 {
+		return nil, fmt.Errorf("data frame instead of a control frame")
+	}
+	return fr.readControlBody()
+}
+
+func (fr *frameReader) readControlBody() (*controlFrame, error) {
+	length, err := fr.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if length < 4 || length > MAX_CONTROL_FRAME_SIZE {
+		return nil, fmt.Errorf("control frame of %d bytes", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(fr.reader, body); err != nil {
+		return nil, err
+	}
+	control := &controlFrame{controlType: binary.BigEndian.Uint32(body)}
+	for fields := body[4:]; len(fields) > 0; {
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("truncated control frame field")
+		}
+		fieldType, fieldLength := binary.BigEndian.Uint32(fields), binary.BigEndian.Uint32(fields[4:])
+		fields = fields[8:]
+		if uint32(len(fields)) < fieldLength {
+			return nil, fmt.Errorf("truncated control frame field")
+		}
+		if fieldType == CONTROL_FIELD_CONTENT_TYPE {
+			control.contentTypes = append(control.contentTypes, string(fields[:fieldLength]))
+		}
+		fields = fields[fieldLength:]
+	}
+	return control, nil
+}
+
+// Write a control frame, with a content type if it isn't empty
+func writeControlFrame(w io.Writer, controlType uint32, contentType string) error {
+	length := 4
+	if contentType != "" {
+		length += 8 + len(contentType)
+	}
+	frame := make([]byte, 8, 8+length)
+	binary.BigEndian.PutUint32(frame[4:], uint32(length))
+	frame = appendUint32(frame, controlType)
+	if contentType != "" {
+		frame = appendUint32(frame, CONTROL_FIELD_CONTENT_TYPE)
+		frame = appendUint32(frame, uint32(len(contentType)))
+		frame = append(frame, contentType...)
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,286 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnstap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Types of the dnstap messages (dnstap.proto), the queries are odd and their responses follow them
+const (
+	AUTH_QUERY         MessageType = 1
+	AUTH_RESPONSE      MessageType = 2
+	RESOLVER_QUERY     MessageType = 3
+	RESOLVER_RESPONSE  MessageType = 4
+	CLIENT_QUERY       MessageType = 5
+	CLIENT_RESPONSE    MessageType = 6
+	FORWARDER_QUERY    MessageType = 7
+	FORWARDER_RESPONSE MessageType = 8
+	STUB_QUERY         MessageType = 9
+	STUB_RESPONSE      MessageType = 10
+	TOOL_QUERY         MessageType = 11
+	TOOL_RESPONSE      MessageType = 12
+	UPDATE_QUERY       MessageType = 13
+	UPDATE_RESPONSE    MessageType = 14
+)
+
+// Transports of the messages
+const (
+	PROTOCOL_UDP          SocketProtocol = 1
+	PROTOCOL_TCP          SocketProtocol = 2
+	PROTOCOL_DOT          SocketProtocol = 3
+	PROTOCOL_DOH          SocketProtocol = 4
+	PROTOCOL_DNSCRYPT_UDP SocketProtocol = 5
+	PROTOCOL_DNSCRYPT_TCP SocketProtocol = 6
+	PROTOCOL_DOQ          SocketProtocol = 7
+)
+
+// Protobuf wire types
+const (
+	WIRE_VARINT  = 0
+	WIRE_FIXED64 = 1
+	WIRE_BYTES   = 2
+	WIRE_FIXED32 = 5
+)
+
+// Only the Dnstap frames of type MESSAGE carry a message
+const DNSTAP_TYPE_MESSAGE = 1
+
+var errTruncated = errors.New("truncated protobuf")
+
+var messageTypeNames = map[MessageType]string{
+	AUTH_QUERY: "AUTH_QUERY", AUTH_RESPONSE: "AUTH_RESPONSE",
+	RESOLVER_QUERY: "RESOLVER_QUERY", RESOLVER_RESPONSE: "RESOLVER_RESPONSE",
+	CLIENT_QUERY: "CLIENT_QUERY", CLIENT_RESPONSE: "CLIENT_RESPONSE",
+	FORWARDER_QUERY: "FORWARDER_QUERY", FORWARDER_RESPONSE: "FORWARDER_RESPONSE",
+	STUB_QUERY: "STUB_QUERY", STUB_RESPONSE: "STUB_RESPONSE",
+	TOOL_QUERY: "TOOL_QUERY", TOOL_RESPONSE: "TOOL_RESPONSE",
+	UPDATE_QUERY: "UPDATE_QUERY", UPDATE_RESPONSE: "UPDATE_RESPONSE",
+}
+
+type (
+	MessageType    uint32
+	SocketProtocol uint32
+
+	// DNS message logged by the name server. The query address and port are the ones of the
+	// sender of the query: the client for CLIENT_*, the name server itself for RESOLVER_*.
+	Message struct {
+		Identity        string
+		Type            MessageType
+		SocketProtocol  SocketProtocol
+		QueryAddress    net.IP
+		ResponseAddress net.IP
+		QueryPort       uint16
+		ResponsePort    uint16
+		QueryTime       time.Time
+		ResponseTime    time.Time
+		QueryMessage    []byte
+		ResponseMessage []byte
+	}
+
+	// Protobuf fields, read one after the other
+	protoReader struct {
+		data []byte
+	}
+)
+
+func (t MessageType) String() string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE_%d", uint32(t))
+}
+
+func (t MessageType) IsQuery() bool {
+	return t%2 == 1
+}
+
+// TCP and the transports over TCP or QUIC carry messages of any size, like TCP
+func (p SocketProtocol) IsUDP() bool {
+	return p == PROTOCOL_UDP || p == PROTOCOL_DNSCRYPT_UDP
+}
+
+// Decode a Dnstap frame, the message is nil if the frame isn't of type MESSAGE
+func DecodeMessage(frame []byte) (*Message, error) {
+	var identity string
+	var message []byte
+	dnstapType := uint64(0)
+	r := &protoReader{data: frame}
+	for !r.done() {
+		field, wireType, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case field == 1 && wireType == WIRE_BYTES:
+			value, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			identity = string(value)
+		case field == 14 && wireType == WIRE_BYTES:
+			if message, err = r.bytes(); err != nil {
+				return nil, err
+			}
+		case field == 15 && wireType == WIRE_VARINT:
+			if dnstapType, err = r.varint(); err != nil {
+				return nil, err
+			}
+		default:
+			if err := r.skip(wireType); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if dnstapType != DNSTAP_TYPE_MESSAGE || message == nil {
+		return nil, nil
+	}
+	m, err := decodeMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	m.Identity = identity
+	return m, nil
+}
+
+func decodeMessage(data []byte) (*Message, error) {
+	m := &Message{}
+	var queryTimeSec, queryTimeNsec, responseTimeSec, responseTimeNsec uint64
+	r := &protoReader{data: data}
+	for !r.done() {
+		field, wireType, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+		var value uint64
+		var bytes []byte
+		switch wireType {
+		case WIRE_VARINT:
+			value, err = r.varint()
+		case WIRE_FIXED32:
+			value, err = r.fixed32()
+		case WIRE_BYTES:
+			bytes, err = r.bytes()
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch field {
+		case 1:
+			m.Type = MessageType(value)
+		case 3:
+			m.SocketProtocol = SocketProtocol(value)
+		case 4:
+			m.QueryAddress = net.IP(bytes)
+		case 5:
+			m.ResponseAddress = net.IP(bytes)
+		case 6:
+			m.QueryPort = uint16(value)
+		case 7:
+			m.ResponsePort = uint16(value)
+		case 8:
+			queryTimeSec = value
+		case 9:
+			queryTimeNsec = value
+		case 10:
+			m.QueryMessage = bytes
+		case 12:
+			responseTimeSec = value
+		case 13:
+			responseTimeNsec = value
+		case 14:
+			m.ResponseMessage = bytes
+		}
+	}
+	if m.Type == 0 {
+		return nil, fmt.Errorf("message without type")
+	}
+	if queryTimeSec > 0 {
+		m.QueryTime = time.Unix(int64(queryTimeSec), int64(queryTimeNsec))
+	}
+	if responseTimeSec > 0 {
+		m.ResponseTime = time.Unix(int64(responseTimeSec), int64(responseTimeNsec))
+	}
+	return m, nil
+}
+
+func (r *protoReader) done() bool {
+	return len(r.data) == 0
+}
+
+func (r *protoReader) key() (int, int, error) {
+	key, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(key >> 3), int(key & 7), nil
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.data = r.data[n:]
+	return value, nil
+}
+
+func (r *protoReader) fixed32() (uint64, error) {
+	if len(r.data) < 4 {
+		return 0, errTruncated
+	}
+	value := binary.LittleEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return uint64(value), nil
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	length, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.data)) < length {
+		return nil, errTruncated
+	}
+	value := r.data[:length]
+	r.data = r.data[length:]
+	return value, nil
+}
+
+func (r *protoReader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case WIRE_VARINT:
+		_, err = r.varint()
+	case WIRE_FIXED64:
+		if len(r.data) < 8 {
+			return errTruncated
+		}
+		r.data = r.data[8:]
+	case WIRE_BYTES:
+		_, err = r.bytes()
+	case WIRE_FIXED32:
+		_, err = r.fixed32()
+	default:
+		err = fmt.Errorf("unsupported protobuf wire type %d", wireType)
+	}
+	return err
+}
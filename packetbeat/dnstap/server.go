@@ -0,0 +1,156 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnstap
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+const DEFAULT_SOCKET = "/var/run/named/dnstap.sock"
+
+var (
+	receivedFrames = monitoring.NewInt(nil, "dnstap.frames")
+	decodeErrors   = monitoring.NewInt(nil, "dnstap.decode_errors")
+	streamErrors   = monitoring.NewInt(nil, "dnstap.stream_errors")
+)
+
+// Server of the dnstap Frame Streams written by the name server on a Unix socket.
+// The messages of a connection are handed to the handler in order, from the goroutine of the connection;
+// a message is only valid until the handler returns.
+type Server struct {
+	path     string
+	handler  func(*Message)
+	listener net.Listener
+
+	mutex sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// Listen on the Unix socket, a socket left by a previous run is replaced
+func NewServer(path string, handler func(*Message)) (*Server, error) {
+	if path == "" {
+		path = DEFAULT_SOCKET
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// The name server runs as its own user
+	if err := os.Chmod(path, 0666); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &Server{
+		path:     path,
+		handler:  handler,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Accept the connections of the name server until the server is closed
+func (s *Server) Run() {
+	logp.Info("dnstap input listening on %v", s.path)
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		if s.conns == nil {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mutex.Unlock()
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+	reader, err := newFrameReader(conn, conn)
+	if err != nil {
+		streamErrors.Inc()
+		logp.Err("dnstap stream refused: %v", err)
+		return
+	}
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if !s.closed() {
+				streamErrors.Inc()
+				logp.Err("dnstap stream closed: %v", err)
+			}
+			return
+		}
+		receivedFrames.Inc()
+		message, err := DecodeMessage(frame)
+		if err != nil {
+			decodeErrors.Inc()
+			logp.Debug("dnstap", "Invalid dnstap frame: %v", err)
+			continue
+		}
+		if message != nil {
+			s.handler(message)
+		}
+	}
+}
+
+func (s *Server) closed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conns == nil
+}
+
+// Stop listening, close the connections and wait for their goroutines
+func (s *Server) Close() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.conns == nil {
+		s.mutex.Unlock()
+		return
+	}
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+	s.mutex.Unlock()
+	s.wg.Wait()
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package dnstap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testMessage = Message{
+	Identity:        "ns1",
+	Type:            CLIENT_RESPONSE,
+	SocketProtocol:  PROTOCOL_UDP,
+	QueryAddress:    net.IP{10, 0, 0, 1},
+	ResponseAddress: net.IP{192, 0, 2, 53},
+	QueryPort:       53000,
+	ResponsePort:    53,
+	QueryTime:       time.Unix(1577836800, 1000),
+	ResponseTime:    time.Unix(1577836800, 2500000),
+	QueryMessage:    []byte{1, 2, 3},
+	ResponseMessage: []byte{4, 5, 6, 7},
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarintField(b []byte, field int, value uint64) []byte {
+	b = appendUvarint(b, uint64(field<<3|WIRE_VARINT))
+	return appendUvarint(b, value)
+}
+
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	b = appendUvarint(b, uint64(field<<3|WIRE_BYTES))
+	b = appendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendFixed32Field(b []byte, field int, value uint32) []byte {
+	b = appendUvarint(b, uint64(field<<3|WIRE_FIXED32))
+	return append(b, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+// Dnstap frame of a message, as written by the name server
+func encodeFrame(m *Message) []byte {
+	var message []byte
+	message = appendVarintField(message, 1, uint64(m.Type))
+	message = appendVarintField(message, 2, 1)
+	message = appendVarintField(message, 3, uint64(m.SocketProtocol))
+	message = appendBytesField(message, 4, m.QueryAddress)
+	message = appendBytesField(message, 5, m.ResponseAddress)
+	message = appendVarintField(message, 6, uint64(m.QueryPort))
+	message = appendVarintField(message, 7, uint64(m.ResponsePort))
+	message = appendVarintField(message, 8, uint64(m.QueryTime.Unix()))
+	message = appendFixed32Field(message, 9, uint32(m.QueryTime.Nanosecond()))
+	message = appendBytesField(message, 10, m.QueryMessage)
+	message = appendVarintField(message, 12, uint64(m.ResponseTime.Unix()))
+	message = appendFixed32Field(message, 13, uint32(m.ResponseTime.Nanosecond()))
+	message = appendBytesField(message, 14, m.ResponseMessage)
+
+	var frame []byte
+	frame = appendBytesField(frame, 1, []byte(m.Identity))
+	frame = appendBytesField(frame, 2, []byte("BIND 9.16"))
+	frame = appendBytesField(frame, 14, message)
+	return appendVarintField(frame, 15, DNSTAP_TYPE_MESSAGE)
+}
+
+func appendDataFrame(b []byte, frame []byte) []byte {
+	b = appendUint32(b, uint32(len(frame)))
+	return append(b, frame...)
+}
+
+func TestDecodeMessage(t *testing.T) {
+	m, err := DecodeMessage(encodeFrame(&testMessage))
+	if assert.NoError(t, err) {
+		assert.Equal(t, testMessage.QueryTime.UnixNano(), m.QueryTime.UnixNano())
+		assert.Equal(t, testMessage.ResponseTime.UnixNano(), m.ResponseTime.UnixNano())
+		m.QueryTime, m.ResponseTime = testMessage.QueryTime, testMessage.ResponseTime
+		assert.Equal(t, testMessage, *m)
+	}
+	assert.True(t, CLIENT_QUERY.IsQuery())
+	assert.False(t, RESOLVER_RESPONSE.IsQuery())
+	assert.Equal(t, "RESOLVER_QUERY", RESOLVER_QUERY.String())
+	assert.False(t, PROTOCOL_DOT.IsUDP())
+
+	// Frames without message are skipped, truncated ones are errors
+	m, err = DecodeMessage(appendVarintField(nil, 15, 2))
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+	frame := encodeFrame(&testMessage)
+	_, err = DecodeMessage(frame[:len(frame)-20])
+	assert.Error(t, err)
+}
+
+func TestFrameReaderUnidirectional(t *testing.T) {
+	var stream bytes.Buffer
+	writeControlFrame(&stream, CONTROL_START, CONTENT_TYPE)
+	stream.Write(appendDataFrame(nil, []byte("first")))
+	stream.Write(appendDataFrame(nil, []byte("second")))
+	writeControlFrame(&stream, CONTROL_STOP, "")
+
+	reader, err := newFrameReader(&stream, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, expected := range []string{"first", "second"} {
+		frame, err := reader.ReadFrame()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(frame))
+	}
+	_, err = reader.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+
+	stream.Reset()
+	writeControlFrame(&stream, CONTROL_START, "protobuf:other")
+	_, err = newFrameReader(&stream, nil)
+	assert.Error(t, err)
+}
+
+func TestServerBidirectional(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnstap")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dnstap.sock")
+
+	messages := make(chan Message, 10)
+	server, err := NewServer(path, func(m *Message) {
+		copied := *m
+		copied.QueryMessage = append([]byte{}, m.QueryMessage...)
+		copied.ResponseMessage = append([]byte{}, m.ResponseMessage...)
+		messages <- copied
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer server.Close()
+	go server.Run()
+
+	// Local Frame Streams writer, like the one of the name server
+	conn, err := net.Dial("unix", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.NoError(t, writeControlFrame(conn, CONTROL_READY, CONTENT_TYPE))
+	reader := &frameReader{reader: bufio.NewReader(conn)}
+	control, err := reader.readControlFrame()
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint32(CONTROL_ACCEPT), control.controlType)
+		assert.Equal(t, []string{CONTENT_TYPE}, control.contentTypes)
+	}
+	assert.NoError(t, writeControlFrame(conn, CONTROL_START, CONTENT_TYPE))
+	query := testMessage
+	query.Type = CLIENT_QUERY
+	var frames []byte
+	frames = appendDataFrame(frames, encodeFrame(&query))
+	frames = appendDataFrame(frames, []byte{0xff})
+	frames = appendDataFrame(frames, encodeFrame(&testMessage))
+	_, err = conn.Write(frames)
+	assert.NoError(t, err)
+	assert.NoError(t, writeControlFrame(conn, CONTROL_STOP, ""))
+	control, err = reader.readControlFrame()
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint32(CONTROL_FINISH), control.controlType)
+	}
+
+	for _, expected := range []MessageType{CLIENT_QUERY, CLIENT_RESPONSE} {
+		select {
+		case m := <-messages:
+			assert.Equal(t, expected, m.Type)
+			assert.Equal(t, "10.0.0.1", m.QueryAddress.String())
+			assert.Equal(t, testMessage.ResponseMessage, m.ResponseMessage)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no dnstap message")
+		}
+	}
+	server.Close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -77,6 +77,7 @@ type (
 		ResponseCode     string    `json:"response_code, omitempty"`
 		Question         *Question `json:"question, omitempty"`
 		Opt              *Opt      `json:"opt, omitempty"`
+		TSIGKey          string    `json:"tsig_key, omitempty"`
 		AnswersCount     int       `json:"answers_count, omitempty"`
 		AuthoritiesCount int       `json:"authorities_count, omitempty"`
 		AdditionalsCount int       `json:"additionals_count, omitempty"`
@@ -158,6 +159,11 @@ type (
 	}
 )
 
+// Encoder of the JSON of a record, it is encoded once
+func NewRecordEncoder(r *Record) *RecordEncoder {
+	return &RecordEncoder{r: r}
+}
+
 func (re *RecordEncoder) Encode() ([]byte, error) {
 	re.ensureEncode()
 	return re.encoded, re.err
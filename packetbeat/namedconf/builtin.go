@@ -0,0 +1,93 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namedconf
+
+import (
+	"net"
+	"strings"
+)
+
+// Built-in ACLs of the name server
+const (
+	ACL_ANY       = "any"
+	ACL_NONE      = "none"
+	ACL_LOCALHOST = "localhost"
+	ACL_LOCALNETS = "localnets"
+)
+
+// Addresses of the host, resolving the built-in ACLs localhost and localnets like the name server
+type HostAddresses struct {
+	// Addresses of the interfaces, as /32 or /128 networks
+	Localhost []*net.IPNet
+	// Networks the interfaces are connected to
+	Localnets []*net.IPNet
+}
+
+func IsBuiltinACL(name string) bool {
+	switch strings.ToLower(name) {
+	case ACL_ANY, ACL_NONE, ACL_LOCALHOST, ACL_LOCALNETS:
+		return true
+	}
+	return false
+}
+
+// Addresses of the interfaces of the host
+func ReadHostAddresses() (*HostAddresses, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	return NewHostAddresses(addrs), nil
+}
+
+func NewHostAddresses(addrs []net.Addr) *HostAddresses {
+	h := &HostAddresses{Localhost: []*net.IPNet{}, Localnets: []*net.IPNet{}}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		bits := net.IPv6len * 8
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, net.IPv4len*8
+		}
+		ones, maskBits := ipNet.Mask.Size()
+		if maskBits != bits {
+			continue
+		}
+		h.Localhost = append(h.Localhost, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		h.Localnets = append(h.Localnets, &net.IPNet{IP: ip.Mask(ipNet.Mask), Mask: net.CIDRMask(ones, bits)})
+	}
+	return h
+}
+
+// Networks of a built-in ACL, false if the name isn't a built-in ACL. any is 0.0.0.0/0 and ::/0, none is empty.
+func (h *HostAddresses) BuiltinACL(name string) ([]*net.IPNet, bool) {
+	switch strings.ToLower(name) {
+	case ACL_ANY:
+		return []*net.IPNet{
+			{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+		}, true
+	case ACL_NONE:
+		return []*net.IPNet{}, true
+	case ACL_LOCALHOST:
+		return h.Localhost, true
+	case ACL_LOCALNETS:
+		return h.Localnets, true
+	}
+	return nil, false
+}
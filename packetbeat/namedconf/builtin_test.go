@@ -0,0 +1,58 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package namedconf
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinACLs(t *testing.T) {
+	host := NewHostAddresses([]net.Addr{
+		&net.IPNet{IP: net.ParseIP("192.0.2.10"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("2001:DB8::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPAddr{IP: net.ParseIP("198.51.100.1")},
+	})
+	localhost, ok := host.BuiltinACL("localhost")
+	assert.True(t, ok)
+	if assert.Len(t, localhost, 2) {
+		assert.Equal(t, "192.0.2.10/32", localhost[0].String())
+		assert.Equal(t, "2001:db8::1/128", localhost[1].String())
+	}
+	localnets, ok := host.BuiltinACL("LOCALNETS")
+	assert.True(t, ok)
+	if assert.Len(t, localnets, 2) {
+		assert.Equal(t, "192.0.2.0/24", localnets[0].String())
+		assert.Equal(t, "2001:db8::/64", localnets[1].String())
+	}
+	any, ok := host.BuiltinACL("any")
+	assert.True(t, ok)
+	if assert.Len(t, any, 2) {
+		assert.True(t, any[0].Contains(net.ParseIP("203.0.113.1")))
+		assert.True(t, any[1].Contains(net.ParseIP("2001:db8::2")))
+	}
+	none, ok := host.BuiltinACL("none")
+	assert.True(t, ok)
+	assert.Empty(t, none)
+	_, ok = host.BuiltinACL("internal")
+	assert.False(t, ok)
+
+	_, err := Parse("named.conf", "acl localhost { 127.0.0.1; };")
+	assert.EqualError(t, err, "named.conf:1: acl localhost: cannot redefine a built-in ACL")
+}
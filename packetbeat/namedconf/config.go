@@ -0,0 +1,367 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namedconf
+
+import (
+	"net"
+	"strings"
+)
+
+type (
+	// Configuration of the name server: the ACLs, views, keys and zones of named.conf.
+	// The zones declared outside a view are in Zones, the other statements are kept in Statements.
+	Config struct {
+		ACLs       []*ACL
+		Views      []*View
+		Keys       []*Key
+		Zones      []*Zone
+		Statements []*Statement
+	}
+
+	// acl name { address_match_list };
+	ACL struct {
+		Name     string
+		Line     int
+		Elements []*AddressMatchElement
+	}
+
+	// Element of an address match list, exactly one of Address, ACL, Key and List is set
+	AddressMatchElement struct {
+		Line    int
+		Negated bool
+		// IP or CIDR in lower case, with its network in Prefix
+		Address string
+		Prefix  *net.IPNet
+		// Name of an ACL, including the built-in any, none, localhost and localnets
+		ACL string
+		// Name of a TSIG key
+		Key string
+		// Nested address match list
+		List []*AddressMatchElement
+	}
+
+	// view name [class] { match-clients ...; match-destinations ...; match-recursive-only ...; zone ...; };
+	View struct {
+		Name               string
+		Class              string
+		Line               int
+		MatchClients       []*AddressMatchElement
+		MatchDestinations  []*AddressMatchElement
+		MatchRecursiveOnly bool
+		Zones              []*Zone
+	}
+
+	// key name { algorithm ...; secret ...; };
+	Key struct {
+		Name      string
+		Line      int
+		Algorithm string
+		Secret    string
+	}
+
+	// zone name [class] { type ...; file ...; };
+	Zone struct {
+		Name  string
+		Class string
+		Line  int
+		Type  string
+		File  string
+	}
+)
+
+// Parse the configuration of the name server, name is the file reported in the errors.
+// The include statements aren't followed.
+func Parse(name string, data string) (*Config, error) {
+	statements, err := ParseStatements(name, data)
+	if err != nil {
+		return nil, err
+	}
+	return newConfig(statements)
+}
+
+// Parse a configuration file of the name server and the files it includes. The included paths are
+// relative to root, the directory the name server is chrooted to, or "" if it isn't chrooted.
+func ParseFile(path string, root string) (*Config, error) {
+	statements, err := newIncluder(root).parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newConfig(statements)
+}
+
+func newConfig(statements []*Statement) (*Config, error) {
+	config := &Config{Statements: statements}
+	for _, s := range statements {
+		switch s.Keyword() {
+		case "acl":
+			acl, err := parseACL(s)
+			if err != nil {
+				return nil, err
+			}
+			config.ACLs = append(config.ACLs, acl)
+		case "view":
+			view, err := parseView(s)
+			if err != nil {
+				return nil, err
+			}
+			config.Views = append(config.Views, view)
+		case "key":
+			key, err := parseKey(s)
+			if err != nil {
+				return nil, err
+			}
+			config.Keys = append(config.Keys, key)
+		case "zone":
+			zone, err := parseZone(s)
+			if err != nil {
+				return nil, err
+			}
+			config.Zones = append(config.Zones, zone)
+		}
+	}
+	return config, nil
+}
+
+// ACL of the given name, nil if it isn't declared
+func (c *Config) ACL(name string) *ACL {
+	for _, acl := range c.ACLs {
+		if acl.Name == name {
+			return acl
+		}
+	}
+	return nil
+}
+
+// View of the given name, nil if it isn't declared
+func (c *Config) View(name string) *View {
+	for _, view := range c.Views {
+		if view.Name == name {
+			return view
+		}
+	}
+	return nil
+}
+
+// Key of the given name, nil if it isn't declared
+func (c *Config) Key(name string) *Key {
+	for _, key := range c.Keys {
+		if key.Name == name {
+			return key
+		}
+	}
+	return nil
+}
+
+func errorf(s *Statement, line int, format string, args ...interface{}) error {
+	l := lexer{name: s.File}
+	return l.errorf(line, format, args...)
+}
+
+// Name and optional class in front of the block of a statement, e.g. view "internal" IN { ... };
+func nameClassBlock(s *Statement) (string, string, []*Statement, error) {
+	keyword := s.Keyword()
+	args := s.Args[1:]
+	if len(args) == 0 || !args[0].IsValue() {
+		return "", "", nil, errorf(s, s.Line, "%s without name", keyword)
+	}
+	name := args[0].Text
+	class := ""
+	args = args[1:]
+	if len(args) > 0 && args[0].IsValue() {
+		class = strings.ToUpper(args[0].Text)
+		args = args[1:]
+	}
+	if len(args) != 1 || !args[0].IsBlock() {
+		return "", "", nil, errorf(s, s.Line, "%s %s: expected a block", keyword, name)
+	}
+	return name, class, args[0].Block, nil
+}
+
+// Single value of an option, e.g. type master;
+func optionValue(s *Statement) (string, error) {
+	if len(s.Args) != 2 || !s.Args[1].IsValue() {
+		return "", errorf(s, s.Line, "%s: expected a single value", s.Keyword())
+	}
+	return s.Args[1].Text, nil
+}
+
+// Address match list in the block of an option, e.g. match-clients { ... };
+func optionAddressMatchList(s *Statement) ([]*AddressMatchElement, error) {
+	if len(s.Args) != 2 || !s.Args[1].IsBlock() {
+		return nil, errorf(s, s.Line, "%s: expected an address match list", s.Keyword())
+	}
+	return parseAddressMatchList(s.Args[1].Block)
+}
+
+func parseAddressMatchList(statements []*Statement) ([]*AddressMatchElement, error) {
+	elements := []*AddressMatchElement{}
+	for _, s := range statements {
+		element, err := parseAddressMatchElement(s)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+// [!] (ip_address[/length] | acl_name | key key_name | { address_match_list })
+func parseAddressMatchElement(s *Statement) (*AddressMatchElement, error) {
+	element := &AddressMatchElement{Line: s.Line}
+	args := s.Args
+	if args[0].IsNot() {
+		element.Negated = true
+		args = args[1:]
+	}
+	switch {
+	case len(args) == 1 && args[0].IsBlock():
+		list, err := parseAddressMatchList(args[0].Block)
+		if err != nil {
+			return nil, err
+		}
+		element.List = list
+	case len(args) == 2 && args[0].IsValue() && !args[0].Quoted && strings.ToLower(args[0].Text) == "key" && args[1].IsValue():
+		element.Key = args[1].Text
+	case len(args) == 1 && args[0].IsValue():
+		text := args[0].Text
+		if prefix := parsePrefix(text); prefix != nil && !args[0].Quoted {
+			element.Address = strings.ToLower(text)
+			element.Prefix = prefix
+		} else {
+			element.ACL = text
+		}
+	default:
+		return nil, errorf(s, s.Line, "invalid address match element")
+	}
+	return element, nil
+}
+
+// Network of an IP or a CIDR, nil if the text is neither
+func parsePrefix(text string) *net.IPNet {
+	if strings.Contains(text, "/") {
+		_, prefix, err := net.ParseCIDR(text)
+		if err != nil {
+			return nil
+		}
+		return prefix
+	}
+	ip := net.ParseIP(text)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func parseACL(s *Statement) (*ACL, error) {
+	if len(s.Args) != 3 || !s.Args[1].IsValue() || !s.Args[2].IsBlock() {
+		return nil, errorf(s, s.Line, "acl: expected a name and an address match list")
+	}
+	if IsBuiltinACL(s.Args[1].Text) {
+		return nil, errorf(s, s.Line, "acl %s: cannot redefine a built-in ACL", s.Args[1].Text)
+	}
+	elements, err := parseAddressMatchList(s.Args[2].Block)
+	if err != nil {
+		return nil, err
+	}
+	return &ACL{Name: s.Args[1].Text, Line: s.Line, Elements: elements}, nil
+}
+
+func parseView(s *Statement) (*View, error) {
+	name, class, block, err := nameClassBlock(s)
+	if err != nil {
+		return nil, err
+	}
+	view := &View{Name: name, Class: class, Line: s.Line}
+	for _, option := range block {
+		switch option.Keyword() {
+		case "match-clients":
+			if view.MatchClients, err = optionAddressMatchList(option); err != nil {
+				return nil, err
+			}
+		case "match-destinations":
+			if view.MatchDestinations, err = optionAddressMatchList(option); err != nil {
+				return nil, err
+			}
+		case "match-recursive-only":
+			value, err := optionValue(option)
+			if err != nil {
+				return nil, err
+			}
+			if view.MatchRecursiveOnly, err = parseBoolean(option, value); err != nil {
+				return nil, err
+			}
+		case "zone":
+			zone, err := parseZone(option)
+			if err != nil {
+				return nil, err
+			}
+			view.Zones = append(view.Zones, zone)
+		}
+	}
+	return view, nil
+}
+
+func parseBoolean(s *Statement, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0":
+		return false, nil
+	}
+	return false, errorf(s, s.Line, "%s: invalid boolean %q", s.Keyword(), value)
+}
+
+func parseKey(s *Statement) (*Key, error) {
+	if len(s.Args) != 3 || !s.Args[1].IsValue() || !s.Args[2].IsBlock() {
+		return nil, errorf(s, s.Line, "key: expected a name and a block")
+	}
+	key := &Key{Name: s.Args[1].Text, Line: s.Line}
+	for _, option := range s.Args[2].Block {
+		var err error
+		switch option.Keyword() {
+		case "algorithm":
+			key.Algorithm, err = optionValue(option)
+		case "secret":
+			key.Secret, err = optionValue(option)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func parseZone(s *Statement) (*Zone, error) {
+	name, class, block, err := nameClassBlock(s)
+	if err != nil {
+		return nil, err
+	}
+	zone := &Zone{Name: name, Class: class, Line: s.Line}
+	for _, option := range block {
+		switch option.Keyword() {
+		case "type":
+			zone.Type, err = optionValue(option)
+		case "file":
+			zone.File, err = optionValue(option)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return zone, nil
+}
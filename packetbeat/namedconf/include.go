@@ -0,0 +1,93 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namedconf
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Replaces the include statements by the statements of the included files
+type includer struct {
+	root string
+	// Files being parsed, the last one includes the next file
+	stack []string
+}
+
+func newIncluder(root string) *includer {
+	return &includer{root: root}
+}
+
+func (in *includer) parseFile(path string) ([]*Statement, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	statements, err := ParseStatements(path, string(data))
+	if err != nil {
+		return nil, err
+	}
+	in.stack = append(in.stack, filepath.Clean(path))
+	defer func() { in.stack = in.stack[:len(in.stack)-1] }()
+	return in.expand(statements)
+}
+
+// Statements with the included ones in place of the include statements, in the blocks too
+func (in *includer) expand(statements []*Statement) ([]*Statement, error) {
+	expanded := make([]*Statement, 0, len(statements))
+	for _, s := range statements {
+		if s.Keyword() != "include" {
+			for i := range s.Args {
+				if s.Args[i].IsBlock() {
+					block, err := in.expand(s.Args[i].Block)
+					if err != nil {
+						return nil, err
+					}
+					s.Args[i].Block = block
+				}
+			}
+			expanded = append(expanded, s)
+			continue
+		}
+
+		if len(s.Args) != 2 || !s.Args[1].IsValue() {
+			return nil, errorf(s, s.Line, "include: expected a file name")
+		}
+		path := in.resolve(s.Args[1].Text)
+		for _, parent := range in.stack {
+			if parent == path {
+				return nil, errorf(s, s.Line, "include cycle: %s", strings.Join(append(in.stack, path), " -> "))
+			}
+		}
+		included, err := in.parseFile(path)
+		if err != nil {
+			if _, ok := err.(*ParseError); ok {
+				return nil, err
+			}
+			return nil, errorf(s, s.Line, "include %q: %v", s.Args[1].Text, err)
+		}
+		expanded = append(expanded, included...)
+	}
+	return expanded, nil
+}
+
+// Path of an included file: the name server runs in the root directory, which it can't leave
+func (in *includer) resolve(name string) string {
+	if in.root == "" {
+		return filepath.Clean(name)
+	}
+	return filepath.Join(in.root, filepath.Clean("/"+name))
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package namedconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Chroot of the name server with the given files, keyed by their path in the chroot
+func newChroot(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "namedconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestParseFileIncludes(t *testing.T) {
+	root := newChroot(t, map[string]string{
+		"etc/named.conf": `
+include "/etc/acls.conf";
+view "internal" {
+	include "etc/views/internal.conf";
+};
+include "../../etc/acls.conf"; // can't leave the chroot
+`,
+		"etc/acls.conf":           "acl internal-nets { 10.0.0.0/8; };\n",
+		"etc/views/internal.conf": "match-clients { internal-nets; };\nzone \"example.com\" { type master; };\n",
+	})
+	defer os.RemoveAll(root)
+
+	config, err := ParseFile(filepath.Join(root, "etc/named.conf"), root)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, config.ACLs, 2)
+	assert.Equal(t, "internal-nets", config.ACLs[0].Name)
+	if view := config.View("internal"); assert.NotNil(t, view) {
+		if assert.Len(t, view.MatchClients, 1) {
+			assert.Equal(t, "internal-nets", view.MatchClients[0].ACL)
+		}
+		if assert.Len(t, view.Zones, 1) {
+			assert.Equal(t, "example.com", view.Zones[0].Name)
+		}
+	}
+}
+
+func TestParseFileIncludeErrors(t *testing.T) {
+	root := newChroot(t, map[string]string{
+		"etc/named.conf":   "acl a { any; };\ninclude \"/etc/a.conf\";\n",
+		"etc/a.conf":       "\n\ninclude \"/etc/b.conf\";\n",
+		"etc/b.conf":       "include \"/etc/a.conf\";\n",
+		"etc/missing.conf": "include \"/etc/none.conf\";\n",
+		"etc/error.conf":   "include \"/etc/syntax.conf\";\n",
+		"etc/syntax.conf":  "acl a {\n",
+		"etc/name.conf":    "include;\n",
+	})
+	defer os.RemoveAll(root)
+	path := func(name string) string {
+		return filepath.Join(root, name)
+	}
+
+	_, err := ParseFile(path("etc/named.conf"), root)
+	assert.Equal(t, &ParseError{
+		File: path("etc/b.conf"),
+		Line: 1,
+		Msg:  "include cycle: " + path("etc/named.conf") + " -> " + path("etc/a.conf") + " -> " + path("etc/b.conf") + " -> " + path("etc/a.conf"),
+	}, err)
+
+	_, err = ParseFile(path("etc/missing.conf"), root)
+	if assert.IsType(t, &ParseError{}, err) {
+		assert.Equal(t, 1, err.(*ParseError).Line)
+		assert.Contains(t, err.Error(), "include \"/etc/none.conf\": open "+path("etc/none.conf"))
+	}
+
+	_, err = ParseFile(path("etc/error.conf"), root)
+	assert.Equal(t, &ParseError{File: path("etc/syntax.conf"), Line: 1, Msg: "missing '}' closing the block"}, err)
+
+	_, err = ParseFile(path("etc/name.conf"), root)
+	assert.EqualError(t, err, path("etc/name.conf")+":1: include: expected a file name")
+
+	_, err = ParseFile(path("etc/nonexistent.conf"), root)
+	assert.True(t, os.IsNotExist(err))
+}
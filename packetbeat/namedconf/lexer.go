@@ -0,0 +1,172 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namedconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	TOKEN_EOF tokenKind = iota
+	TOKEN_WORD
+	TOKEN_STRING
+	TOKEN_LBRACE
+	TOKEN_RBRACE
+	TOKEN_SEMICOLON
+	TOKEN_NOT
+)
+
+type (
+	tokenKind int
+
+	token struct {
+		kind tokenKind
+		text string
+		line int
+	}
+
+	// Tokenizer of the BIND configuration: words, quoted strings and the punctuation { } ; !
+	// The //, # and /* */ comments are skipped.
+	lexer struct {
+		name string
+		data string
+		pos  int
+		line int
+	}
+
+	// Error of the configuration, at a line of a file
+	ParseError struct {
+		File string
+		Line int
+		Msg  string
+	}
+)
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case TOKEN_EOF:
+		return "end of file"
+	case TOKEN_STRING:
+		return fmt.Sprintf("\"%s\"", t.text)
+	default:
+		return fmt.Sprintf("'%s'", t.text)
+	}
+}
+
+func newLexer(name string, data string) *lexer {
+	return &lexer{name: name, data: data, line: 1}
+}
+
+func (l *lexer) errorf(line int, format string, args ...interface{}) error {
+	return &ParseError{File: l.name, Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.data) {
+		return token{kind: TOKEN_EOF, line: l.line}, nil
+	}
+	line := l.line
+	switch c := l.data[l.pos]; c {
+	case '{':
+		l.pos++
+		return token{kind: TOKEN_LBRACE, text: "{", line: line}, nil
+	case '}':
+		l.pos++
+		return token{kind: TOKEN_RBRACE, text: "}", line: line}, nil
+	case ';':
+		l.pos++
+		return token{kind: TOKEN_SEMICOLON, text: ";", line: line}, nil
+	case '!':
+		l.pos++
+		return token{kind: TOKEN_NOT, text: "!", line: line}, nil
+	case '"':
+		return l.quotedString()
+	}
+	start := l.pos
+	for l.pos < len(l.data) && !l.endOfWord() {
+		l.pos++
+	}
+	return token{kind: TOKEN_WORD, text: l.data[start:l.pos], line: line}, nil
+}
+
+func (l *lexer) endOfWord() bool {
+	switch l.data[l.pos] {
+	case ' ', '\t', '\r', '\n', '{', '}', ';', '!', '"', '#':
+		return true
+	}
+	return l.startOfComment()
+}
+
+func (l *lexer) startOfComment() bool {
+	return strings.HasPrefix(l.data[l.pos:], "//") || strings.HasPrefix(l.data[l.pos:], "/*")
+}
+
+func (l *lexer) skipSpaceAndComments() error {
+	for l.pos < len(l.data) {
+		switch c := l.data[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#' || strings.HasPrefix(l.data[l.pos:], "//"):
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.data[l.pos:], "/*"):
+			line := l.line
+			end := strings.Index(l.data[l.pos+2:], "*/")
+			if end < 0 {
+				return l.errorf(line, "unterminated comment")
+			}
+			comment := l.data[l.pos : l.pos+2+end+2]
+			l.line += strings.Count(comment, "\n")
+			l.pos += len(comment)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Quoted string, a backslash escapes the next character
+func (l *lexer) quotedString() (token, error) {
+	line := l.line
+	var text strings.Builder
+	for l.pos++; l.pos < len(l.data); l.pos++ {
+		c := l.data[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: TOKEN_STRING, text: text.String(), line: line}, nil
+		case c == '\\' && l.pos+1 < len(l.data):
+			l.pos++
+			c = l.data[l.pos]
+		}
+		if c == '\n' {
+			l.line++
+		}
+		text.WriteByte(c)
+	}
+	return token{}, l.errorf(line, "unterminated string")
+}
@@ -0,0 +1,222 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namedconf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Results of an address match list: the first matching element decides
+const (
+	NO_MATCH = iota
+	MATCH_ACCEPT
+	MATCH_REJECT
+)
+
+type (
+	// Attributes of a query the name server chooses a view with
+	ViewQuery struct {
+		// Source address of the query
+		Client net.IP
+		// Address the query was sent to, nil if unknown: match-destinations is then not checked
+		Destination net.IP
+		// RD bit of the query
+		Recursive bool
+		// Name of the TSIG key the query is signed with, empty if it isn't signed
+		Key string
+	}
+
+	// Views of named.conf compiled to choose the view of a query like the name server:
+	// the first view in the order of named.conf whose match-clients, match-destinations
+	// and match-recursive-only accept the query.
+	ViewMatcher struct {
+		views []*compiledView
+	}
+
+	compiledView struct {
+		name          string
+		clients       *addressMatchList
+		destinations  *addressMatchList
+		recursiveOnly bool
+	}
+
+	// Address match list with the ACLs it references resolved
+	addressMatchList struct {
+		elements []*compiledElement
+	}
+
+	// Element matching when its query address is in one of its prefixes, its key signs the query
+	// or its list accepts the query
+	compiledElement struct {
+		negated  bool
+		prefixes []*net.IPNet
+		key      string
+		list     *addressMatchList
+	}
+
+	compiler struct {
+		config *Config
+		host   *HostAddresses
+		acls   map[string]*addressMatchList
+		// ACLs being compiled, to detect the ACLs referencing themselves
+		compiling map[string]bool
+	}
+)
+
+// Compile the views of a configuration, host resolves localhost and localnets.
+// A view without match-clients or match-destinations matches any address.
+func CompileViews(config *Config, host *HostAddresses) (*ViewMatcher, error) {
+	c := &compiler{config: config, host: host, acls: map[string]*addressMatchList{}, compiling: map[string]bool{}}
+	m := &ViewMatcher{}
+	for _, view := range config.Views {
+		compiled := &compiledView{name: view.Name, recursiveOnly: view.MatchRecursiveOnly}
+		var err error
+		if view.MatchClients != nil {
+			if compiled.clients, err = c.compileList(view.MatchClients); err != nil {
+				return nil, fmt.Errorf("view %s: match-clients: %v", view.Name, err)
+			}
+		}
+		if view.MatchDestinations != nil {
+			if compiled.destinations, err = c.compileList(view.MatchDestinations); err != nil {
+				return nil, fmt.Errorf("view %s: match-destinations: %v", view.Name, err)
+			}
+		}
+		m.views = append(m.views, compiled)
+	}
+	return m, nil
+}
+
+func (c *compiler) compileList(elements []*AddressMatchElement) (*addressMatchList, error) {
+	list := &addressMatchList{elements: make([]*compiledElement, 0, len(elements))}
+	for _, element := range elements {
+		compiled := &compiledElement{negated: element.Negated}
+		switch {
+		case element.Prefix != nil:
+			compiled.prefixes = []*net.IPNet{element.Prefix}
+		case element.Key != "":
+			compiled.key = canonicalKeyName(element.Key)
+		case element.List != nil:
+			nested, err := c.compileList(element.List)
+			if err != nil {
+				return nil, err
+			}
+			compiled.list = nested
+		default:
+			if prefixes, ok := c.host.BuiltinACL(element.ACL); ok {
+				compiled.prefixes = prefixes
+				break
+			}
+			acl, err := c.compileACL(element)
+			if err != nil {
+				return nil, err
+			}
+			compiled.list = acl
+		}
+		list.elements = append(list.elements, compiled)
+	}
+	return list, nil
+}
+
+func (c *compiler) compileACL(element *AddressMatchElement) (*addressMatchList, error) {
+	if list, ok := c.acls[element.ACL]; ok {
+		return list, nil
+	}
+	acl := c.config.ACL(element.ACL)
+	if acl == nil {
+		return nil, fmt.Errorf("line %d: undefined ACL %s", element.Line, element.ACL)
+	}
+	if c.compiling[acl.Name] {
+		return nil, fmt.Errorf("line %d: ACL %s references itself", element.Line, acl.Name)
+	}
+	c.compiling[acl.Name] = true
+	list, err := c.compileList(acl.Elements)
+	delete(c.compiling, acl.Name)
+	if err != nil {
+		return nil, err
+	}
+	c.acls[acl.Name] = list
+	return list, nil
+}
+
+// Key names are domain names: case insensitive, with or without the trailing dot
+func canonicalKeyName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// Name of the view the name server chooses for the query, empty if no view matches it
+func (m *ViewMatcher) Match(query *ViewQuery) string {
+	if m == nil {
+		return ""
+	}
+	client := canonicalIP(query.Client)
+	destination := canonicalIP(query.Destination)
+	key := canonicalKeyName(query.Key)
+	for _, view := range m.views {
+		if view.recursiveOnly && !query.Recursive {
+			continue
+		}
+		if view.clients != nil && view.clients.match(client, key) != MATCH_ACCEPT {
+			continue
+		}
+		if view.destinations != nil && destination != nil && view.destinations.match(destination, key) != MATCH_ACCEPT {
+			continue
+		}
+		return view.name
+	}
+	return ""
+}
+
+// Result of the first matching element, NO_MATCH if there is none. A nested list or an ACL only
+// matches when it accepts the query: a rejection inside it doesn't become an acceptance when negated.
+func (l *addressMatchList) match(ip net.IP, key string) int {
+	for _, element := range l.elements {
+		if !element.match(ip, key) {
+			continue
+		}
+		if element.negated {
+			return MATCH_REJECT
+		}
+		return MATCH_ACCEPT
+	}
+	return NO_MATCH
+}
+
+func (e *compiledElement) match(ip net.IP, key string) bool {
+	switch {
+	case e.list != nil:
+		return e.list.match(ip, key) == MATCH_ACCEPT
+	case e.key != "":
+		return e.key == key
+	}
+	if ip == nil {
+		return false
+	}
+	for _, prefix := range e.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPv4 addresses, including the IPv4-mapped IPv6 ones, are matched in their 4-byte form
+func canonicalIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
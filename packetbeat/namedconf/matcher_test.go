@@ -0,0 +1,108 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package namedconf
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testViews = `
+acl "lab" { 10.1.0.0/16; };
+acl "internal" { !10.1.2.0/24; 10.0.0.0/8; };
+acl "not-lab" { !lab; any; };
+view "transfer" {
+	match-clients { key "xfr-key."; };
+};
+view "resolver" {
+	match-clients { internal; localnets; };
+	match-recursive-only yes;
+};
+view "internal-auth" {
+	match-clients { internal; };
+	match-destinations { 192.0.2.53; 2001:db8::53; };
+};
+view "lab" {
+	match-clients { !{ !lab; any; }; lab; };
+};
+view "default" {
+	match-clients { !203.0.113.9; !key "xfr-key"; not-lab; };
+};
+`
+
+func newTestMatcher(t *testing.T) *ViewMatcher {
+	config, err := Parse("named.conf", testViews)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	host := NewHostAddresses([]net.Addr{
+		&net.IPNet{IP: net.ParseIP("172.16.0.1"), Mask: net.CIDRMask(24, 32)},
+	})
+	matcher, err := CompileViews(config, host)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return matcher
+}
+
+func TestViewMatcher(t *testing.T) {
+	matcher := newTestMatcher(t)
+	for _, test := range []struct {
+		query ViewQuery
+		view  string
+	}{
+		// The signed queries go to the view of their key, whatever their address
+		{ViewQuery{Client: net.ParseIP("10.0.0.1"), Key: "XFR-KEY"}, "transfer"},
+		// Recursive queries of the internal clients and of the local networks
+		{ViewQuery{Client: net.ParseIP("10.0.0.1"), Recursive: true}, "resolver"},
+		{ViewQuery{Client: net.ParseIP("::ffff:172.16.0.9"), Recursive: true}, "resolver"},
+		// 10.1.2.0/24 is excluded of internal before 10.0.0.0/8 matches.
+		// The rejection of !lab inside the nested list isn't an acceptance of the negated list.
+		{ViewQuery{Client: net.ParseIP("10.1.2.3"), Recursive: true}, "lab"},
+		// Non recursive queries of the internal clients sent to the addresses of internal-auth
+		{ViewQuery{Client: net.ParseIP("10.0.0.1"), Destination: net.ParseIP("192.0.2.53")}, "internal-auth"},
+		{ViewQuery{Client: net.ParseIP("10.0.0.1"), Destination: net.ParseIP("2001:DB8::53")}, "internal-auth"},
+		{ViewQuery{Client: net.ParseIP("10.0.0.1")}, "internal-auth"},
+		{ViewQuery{Client: net.ParseIP("10.0.0.1"), Destination: net.ParseIP("192.0.2.54")}, "default"},
+		{ViewQuery{Client: net.ParseIP("10.1.0.1"), Destination: net.ParseIP("192.0.2.54")}, "lab"},
+		// The nested list of lab accepts the addresses out of lab, so the view rejects them
+		{ViewQuery{Client: net.ParseIP("203.0.113.1")}, "default"},
+		{ViewQuery{Client: net.ParseIP("203.0.113.9")}, ""},
+		{ViewQuery{Client: net.ParseIP("2001:db8::1")}, "default"},
+		{ViewQuery{}, ""},
+	} {
+		assert.Equal(t, test.view, matcher.Match(&test.query), "%+v", test.query)
+	}
+
+	var none *ViewMatcher
+	assert.Equal(t, "", none.Match(&ViewQuery{Client: net.ParseIP("10.0.0.1")}))
+}
+
+func TestCompileViewsErrors(t *testing.T) {
+	for conf, msg := range map[string]string{
+		"view v { match-clients { missing; }; };":                              "view v: match-clients: line 1: undefined ACL missing",
+		"acl a { b; };\nacl b { a; };\nview v { match-destinations { a; }; };": "view v: match-destinations: line 2: ACL a references itself",
+	} {
+		config, err := Parse("named.conf", conf)
+		if assert.NoError(t, err) {
+			_, err = CompileViews(config, NewHostAddresses(nil))
+			assert.EqualError(t, err, msg)
+		}
+	}
+}
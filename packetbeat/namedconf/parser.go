@@ -0,0 +1,146 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namedconf
+
+import (
+	"strings"
+)
+
+const MAX_BLOCK_DEPTH = 32
+
+type (
+	// Statement of the configuration: its arguments up to the semicolon ending it,
+	// e.g. view "internal" IN { ... }; has the arguments view, internal, IN and a block
+	Statement struct {
+		File string
+		Line int
+		Args []Arg
+	}
+
+	// Word, quoted string, ! or block of statements
+	Arg struct {
+		Line   int
+		Text   string
+		Quoted bool
+		// Statements of a block, not nil for a block even if it is empty
+		Block []*Statement
+	}
+
+	parser struct {
+		lexer *lexer
+		depth int
+	}
+)
+
+// Keyword of the statement: its first argument in lower case, empty if it isn't a word
+func (s *Statement) Keyword() string {
+	if len(s.Args) == 0 || s.Args[0].Quoted || s.Args[0].IsBlock() {
+		return ""
+	}
+	return strings.ToLower(s.Args[0].Text)
+}
+
+// First block of the statement, nil if it has none
+func (s *Statement) Block() []*Statement {
+	for _, arg := range s.Args {
+		if arg.IsBlock() {
+			return arg.Block
+		}
+	}
+	return nil
+}
+
+func (a *Arg) IsBlock() bool {
+	return a.Block != nil
+}
+
+func (a *Arg) IsNot() bool {
+	return a.Text == "!" && !a.Quoted && !a.IsBlock()
+}
+
+// Name or value: a word or a quoted string
+func (a *Arg) IsValue() bool {
+	return !a.IsBlock() && !a.IsNot()
+}
+
+// Parse the statements of a configuration, name is the file reported in the errors
+func ParseStatements(name string, data string) ([]*Statement, error) {
+	p := &parser{lexer: newLexer(name, data)}
+	return p.statements(nil)
+}
+
+// Statements up to the end of the file or, in a block, up to its closing brace
+func (p *parser) statements(open *token) ([]*Statement, error) {
+	statements := []*Statement{}
+	for {
+		tok, err := p.lexer.next()
+		if err != nil {
+			return nil, err
+		}
+		switch tok.kind {
+		case TOKEN_EOF:
+			if open != nil {
+				return nil, p.lexer.errorf(open.line, "missing '}' closing the block")
+			}
+			return statements, nil
+		case TOKEN_RBRACE:
+			if open == nil {
+				return nil, p.lexer.errorf(tok.line, "unexpected '}'")
+			}
+			return statements, nil
+		case TOKEN_SEMICOLON:
+			// Empty statement
+			continue
+		}
+		statement, err := p.statement(tok)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+}
+
+// Arguments of a statement up to its semicolon
+func (p *parser) statement(tok token) (*Statement, error) {
+	s := &Statement{File: p.lexer.name, Line: tok.line}
+	for {
+		switch tok.kind {
+		case TOKEN_SEMICOLON:
+			return s, nil
+		case TOKEN_EOF:
+			return nil, p.lexer.errorf(s.Line, "missing ';' at the end of the statement")
+		case TOKEN_RBRACE:
+			return nil, p.lexer.errorf(tok.line, "missing ';' before '}'")
+		case TOKEN_LBRACE:
+			if p.depth >= MAX_BLOCK_DEPTH {
+				return nil, p.lexer.errorf(tok.line, "blocks nested too deeply")
+			}
+			p.depth++
+			open := tok
+			block, err := p.statements(&open)
+			p.depth--
+			if err != nil {
+				return nil, err
+			}
+			s.Args = append(s.Args, Arg{Line: tok.line, Block: block})
+		default:
+			s.Args = append(s.Args, Arg{Line: tok.line, Text: tok.text, Quoted: tok.kind == TOKEN_STRING})
+		}
+		var err error
+		if tok, err = p.lexer.next(); err != nil {
+			return nil, err
+		}
+	}
+}
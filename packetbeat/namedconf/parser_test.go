@@ -0,0 +1,163 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package namedconf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testNamedConf = `
+# Generated by the BAM
+options {
+	directory "/var/named"; // working directory
+	allow-query { any; };
+};
+
+/* Statistics
+   ACLs */
+acl "_TrafficStatisticsAgent_Clients" { 10.0.0.0/8; 192.0.2.1; };
+acl internal-nets {
+	10.0.0.0/8;
+	! 10.1.0.0/16;
+	2001:DB8::/32;
+	{ "_TrafficStatisticsAgent_Clients"; key "tsig-key"; };
+};
+
+key "tsig-key" {
+	algorithm hmac-sha256;
+	secret "c2VjcmV0IHtrZXl9Ow==";
+};
+
+zone "." IN { type hint; file "named.ca"; };
+
+view "internal" IN {
+	match-clients { !192.0.2.1; internal-nets; };
+	match-destinations { 192.0.2.53; };
+	match-recursive-only yes;
+	zone "example.com" {
+		type master;
+		file "db.example.com";
+	};
+};
+view "external" {
+	match-clients { any; };
+};
+`
+
+func TestParse(t *testing.T) {
+	config, err := Parse("named.conf", testNamedConf)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, config.Statements, 7)
+	assert.Equal(t, "options", config.Statements[0].Keyword())
+
+	if assert.Len(t, config.ACLs, 2) {
+		acl := config.ACL("internal-nets")
+		if assert.NotNil(t, acl) && assert.Len(t, acl.Elements, 4) {
+			assert.Equal(t, 11, acl.Line)
+			assert.Equal(t, "10.0.0.0/8", acl.Elements[0].Address)
+			assert.Equal(t, "10.0.0.0/8", acl.Elements[0].Prefix.String())
+			assert.True(t, acl.Elements[1].Negated)
+			assert.Equal(t, 13, acl.Elements[1].Line)
+			assert.Equal(t, "2001:db8::/32", acl.Elements[2].Address)
+			nested := acl.Elements[3].List
+			if assert.Len(t, nested, 2) {
+				assert.Equal(t, "_TrafficStatisticsAgent_Clients", nested[0].ACL)
+				assert.Equal(t, "tsig-key", nested[1].Key)
+			}
+		}
+		assert.Equal(t, "192.0.2.1/32", config.ACL("_TrafficStatisticsAgent_Clients").Elements[1].Prefix.String())
+	}
+
+	if key := config.Key("tsig-key"); assert.NotNil(t, key) {
+		assert.Equal(t, "hmac-sha256", key.Algorithm)
+		assert.Equal(t, "c2VjcmV0IHtrZXl9Ow==", key.Secret)
+	}
+	if assert.Len(t, config.Zones, 1) {
+		assert.Equal(t, Zone{Name: ".", Class: "IN", Line: 23, Type: "hint", File: "named.ca"}, *config.Zones[0])
+	}
+
+	if assert.Len(t, config.Views, 2) {
+		view := config.View("internal")
+		assert.Equal(t, "IN", view.Class)
+		assert.True(t, view.MatchRecursiveOnly)
+		if assert.Len(t, view.MatchClients, 2) {
+			assert.True(t, view.MatchClients[0].Negated)
+			assert.Equal(t, "192.0.2.1", view.MatchClients[0].Address)
+			assert.Equal(t, "internal-nets", view.MatchClients[1].ACL)
+		}
+		if assert.Len(t, view.MatchDestinations, 1) {
+			assert.Equal(t, "192.0.2.53", view.MatchDestinations[0].Address)
+		}
+		if assert.Len(t, view.Zones, 1) {
+			assert.Equal(t, "db.example.com", view.Zones[0].File)
+		}
+		view = config.View("external")
+		assert.Equal(t, "", view.Class)
+		assert.False(t, view.MatchRecursiveOnly)
+		assert.Equal(t, "any", view.MatchClients[0].ACL)
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	statements, err := ParseStatements("named.conf", "a\"b c\"#comment\n//comment\n d/*x\n*/e \"q\\\"\";")
+	if assert.NoError(t, err) && assert.Len(t, statements, 1) {
+		args := statements[0].Args
+		if assert.Len(t, args, 5) {
+			assert.Equal(t, Arg{Line: 1, Text: "a"}, args[0])
+			assert.Equal(t, Arg{Line: 1, Text: "b c", Quoted: true}, args[1])
+			assert.Equal(t, Arg{Line: 3, Text: "d"}, args[2])
+			assert.Equal(t, Arg{Line: 4, Text: "e"}, args[3])
+			assert.Equal(t, Arg{Line: 4, Text: "q\"", Quoted: true}, args[4])
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		conf string
+		line int
+		msg  string
+	}{
+		{"acl a { 1.2.3.4; };\n};", 2, "unexpected '}'"},
+		{"options {\n\tdirectory \"/var\";\n", 1, "missing '}' closing the block"},
+		{"acl a { 1.2.3.4 };", 1, "missing ';' before '}'"},
+		{"options { };\nrecursion yes", 2, "missing ';' at the end of the statement"},
+		{"/* comment\n\nacl a { any; };", 1, "unterminated comment"},
+		{"\nzone \"example.com {\n};", 2, "unterminated string"},
+		{"acl a;", 1, "acl: expected a name and an address match list"},
+		{"\nacl a { 1.2.3.4 5.6.7.8; };", 2, "invalid address match element"},
+		{"view v {\n\tmatch-recursive-only maybe;\n};", 2, "match-recursive-only: invalid boolean \"maybe\""},
+		{"view { };", 1, "view without name"},
+		{"zone \"example.com\" IN;", 1, "zone example.com: expected a block"},
+	} {
+		_, err := Parse("named.conf", test.conf)
+		if assert.Error(t, err, test.conf) {
+			assert.Equal(t, &ParseError{File: "named.conf", Line: test.line, Msg: test.msg}, err, test.conf)
+		}
+	}
+
+	deep := ""
+	for i := 0; i <= MAX_BLOCK_DEPTH; i++ {
+		deep += "a {"
+	}
+	_, err := Parse("named.conf", deep)
+	assert.EqualError(t, err, "named.conf:1: blocks nested too deeply")
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outstats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+const (
+	FORMAT_JSON = "json"
+)
+
+type (
+	// Statistics of one interval
+	Interval struct {
+		Start time.Time
+		End   time.Time
+		// Statistics encoded in JSON, as logged in DNS_Statistics
+		Data []byte
+	}
+
+	// Destination of the interval statistics.
+	// Export is only called by the worker of the exporter, one interval at a time.
+	Exporter interface {
+		Name() string
+		Export(interval Interval) error
+		Close() error
+	}
+
+	// Exporter with a spool, the intervals its worker can't queue are spooled instead of dropped.
+	// Spool is called concurrently with Export.
+	SpoolingExporter interface {
+		Exporter
+		Spool(interval Interval) error
+	}
+
+	// Create an exporter from its configuration
+	ExporterFactory func(config config_statistics.ExporterConfig) (Exporter, error)
+
+	// Error of an interval the destination refused, the interval isn't retried
+	PermanentError struct {
+		Err error
+	}
+)
+
+func (err PermanentError) Error() string {
+	return err.Err.Error()
+}
+
+func IsPermanentError(err error) bool {
+	_, permanent := err.(PermanentError)
+	return permanent
+}
+
+// Key of an interval, the same for all the deliveries of the interval so that the destination can de-duplicate the replays
+func (interval Interval) IdempotencyKey(host string) string {
+	return fmt.Sprintf("%s-%d-%d", host, interval.Start.UnixNano(), interval.End.UnixNano())
+}
+
+var exporterFactories = make(map[string]ExporterFactory)
+
+// Register the factory of an exporter type, called from the init of the exporter file
+func RegisterExporter(exporterType string, factory ExporterFactory) {
+	if _, exist := exporterFactories[exporterType]; exist {
+		panic(fmt.Sprintf("exporter type '%v' exists already", exporterType))
+	}
+	exporterFactories[exporterType] = factory
+}
+
+func NewExporter(config config_statistics.ExporterConfig) (Exporter, error) {
+	factory, exist := exporterFactories[config.Type]
+	if !exist {
+		return nil, fmt.Errorf("unknown exporter type '%v'", config.Type)
+	}
+	return factory(config)
+}
+
+// Check the format of an exporter, empty is the first supported format
+func checkFormat(config config_statistics.ExporterConfig, formats ...string) (string, error) {
+	if config.Format == "" {
+		return formats[0], nil
+	}
+	for _, format := range formats {
+		if config.Format == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("exporter %v doesn't support the format '%v'", config.Type, config.Format)
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outstats
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+const (
+	// Number of intervals waiting for a slow exporter, the next ones are spooled or dropped
+	EXPORTER_QUEUE_SIZE = 10
+	// Maximum delay in seconds between two retries without max_backoff in the retry policy
+	DEFAULT_MAX_BACKOFF = 60
+)
+
+type (
+	// Fan out the intervals to all the enabled exporters.
+	// Each exporter has its own worker, a slow or failing exporter doesn't delay the others.
+	Exporters struct {
+		workers []*exporterWorker
+		wg      sync.WaitGroup
+	}
+
+	exporterWorker struct {
+		exporter Exporter
+		retry    config_statistics.RetryConfig
+		queue    chan Interval
+		done     chan struct{}
+	}
+)
+
+// Create the enabled exporters of the configuration and start their worker, and the workers of the exporters
+// created elsewhere (e.g. the Kafka producer of the DNS analyzer), which aren't retried.
+// Without exporter in the configuration, the statistics are sent to the SNMP sub-agent at statistics_destination.
+func NewExporters(config config_statistics.ConfigStatistics, extraExporters ...Exporter) *Exporters {
+	configs := config.Exporters
+	if len(configs) == 0 {
+		configs = []config_statistics.ExporterConfig{{
+			Type:        EXPORTER_SNMP_AGENT,
+			Enabled:     true,
+			Format:      FORMAT_JSON,
+			Destination: config.StatisticsDestination,
+		}}
+	}
+
+	exporters := &Exporters{}
+	for _, exporterConfig := range configs {
+		if !exporterConfig.Enabled {
+			continue
+		}
+		exporter, err := NewExporter(exporterConfig)
+		if err != nil {
+			logp.Err("Couldn't create the exporter %v: %v", exporterConfig.Type, err)
+			continue
+		}
+		exporters.start(exporter, exporterConfig.Retry)
+	}
+	for _, exporter := range extraExporters {
+		exporters.start(exporter, config_statistics.RetryConfig{})
+	}
+	return exporters
+}
+
+func (e *Exporters) start(exporter Exporter, retry config_statistics.RetryConfig) {
+	logp.Info("Export the statistics to %v", exporter.Name())
+	worker := &exporterWorker{
+		exporter: exporter,
+		retry:    retry,
+		queue:    make(chan Interval, EXPORTER_QUEUE_SIZE),
+		done:     make(chan struct{}),
+	}
+	e.workers = append(e.workers, worker)
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		worker.run()
+	}()
+}
+
+// Queue an interval for all the exporters
+func (e *Exporters) Publish(interval Interval) {
+	if e == nil {
+		return
+	}
+	for _, worker := range e.workers {
+		select {
+		case worker.queue <- interval:
+		default:
+			worker.overflow(interval)
+		}
+	}
+}
+
+// Stop the workers and close the exporters, the queued intervals are dropped
+func (e *Exporters) Close() {
+	if e == nil {
+		return
+	}
+	for _, worker := range e.workers {
+		close(worker.done)
+	}
+	e.wg.Wait()
+	for _, worker := range e.workers {
+		if err := worker.exporter.Close(); err != nil {
+			logp.Err("Couldn't close the exporter %v: %v", worker.exporter.Name(), err)
+		}
+	}
+}
+
+func (w *exporterWorker) run() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case interval := <-w.queue:
+			w.export(interval)
+		}
+	}
+}
+
+// Spool the interval the queue can't hold when the exporter has a spool, drop it otherwise.
+// The queued intervals are spooled first to keep the order of the intervals.
+func (w *exporterWorker) overflow(interval Interval) {
+	spooler, spooling := w.exporter.(SpoolingExporter)
+	if !spooling {
+		logp.Err("Exporter %v is too slow, drop the interval %v - %v", w.exporter.Name(), interval.Start, interval.End)
+		return
+	}
+	for drained := false; !drained; {
+		select {
+		case queued := <-w.queue:
+			w.spool(spooler, queued)
+		default:
+			drained = true
+		}
+	}
+	w.spool(spooler, interval)
+}
+
+func (w *exporterWorker) spool(spooler SpoolingExporter, interval Interval) {
+	if err := spooler.Spool(interval); err != nil {
+		logp.Err("Exporter %v is too slow and couldn't spool the interval %v - %v: %v", w.exporter.Name(), interval.Start, interval.End, err)
+	}
+}
+
+// Export an interval, retried according to the retry policy of the exporter
+func (w *exporterWorker) export(interval Interval) {
+	for attempt := 0; ; attempt++ {
+		err := w.exporter.Export(interval)
+		if err == nil {
+			return
+		}
+		if IsPermanentError(err) || attempt >= w.retry.MaxRetries {
+			logp.Err("Exporter %v couldn't export the interval %v - %v: %v", w.exporter.Name(), interval.Start, interval.End, err)
+			return
+		}
+		logp.Debug("outstats", "Exporter %v failed, retry %d: %v", w.exporter.Name(), attempt+1, err)
+		select {
+		case <-w.done:
+			return
+		case <-time.After(w.retryDelay(attempt)):
+		}
+	}
+}
+
+// Delay before a retry: backoff seconds doubled at each retry up to max_backoff, with a random jitter
+// of up to half the delay so that the retries of the exporters don't synchronize
+func (w *exporterWorker) retryDelay(attempt int) time.Duration {
+	delay := time.Duration(w.retry.Backoff) * time.Second
+	maxDelay := time.Duration(w.retry.MaxBackoff) * time.Second
+	if maxDelay <= 0 {
+		maxDelay = DEFAULT_MAX_BACKOFF * time.Second
+	}
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,309 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package outstats
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+// Exporter failing the first failures exports
+type testExporter struct {
+	mutex    sync.Mutex
+	failures int
+	exported []string
+	closed   bool
+}
+
+func (exporter *testExporter) Name() string { return "test" }
+
+func (exporter *testExporter) Export(interval Interval) error {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	if exporter.failures > 0 {
+		exporter.failures--
+		return errors.New("failure")
+	}
+	exporter.exported = append(exporter.exported, string(interval.Data))
+	return nil
+}
+
+func (exporter *testExporter) Close() error {
+	exporter.closed = true
+	return nil
+}
+
+func (exporter *testExporter) getExported() []string {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	return append([]string{}, exporter.exported...)
+}
+
+func newTestExporters(exporters map[string]*testExporter, configs []config_statistics.ExporterConfig) *Exporters {
+	for exporterType, exporter := range exporters {
+		exporter := exporter
+		exporterFactories[exporterType] = func(config config_statistics.ExporterConfig) (Exporter, error) {
+			return exporter, nil
+		}
+	}
+	return NewExporters(config_statistics.ConfigStatistics{Exporters: configs})
+}
+
+func TestExportersFanOut(t *testing.T) {
+	first, second, disabled := &testExporter{}, &testExporter{failures: 2}, &testExporter{}
+	exporters := newTestExporters(map[string]*testExporter{"first": first, "second": second, "disabled": disabled}, []config_statistics.ExporterConfig{
+		{Type: "first", Enabled: true},
+		{Type: "second", Enabled: true, Retry: config_statistics.RetryConfig{MaxRetries: 2}},
+		{Type: "disabled", Enabled: false},
+		{Type: "unknown", Enabled: true},
+	})
+	assert.Len(t, exporters.workers, 2)
+
+	exporters.Publish(Interval{Data: []byte("1")})
+	exporters.Publish(Interval{Data: []byte("2")})
+	for i := 0; i < 100 && (len(first.getExported()) < 2 || len(second.getExported()) < 2); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	exporters.Close()
+
+	assert.Equal(t, []string{"1", "2"}, first.getExported())
+	// The first interval is exported by the second retry
+	assert.Equal(t, []string{"1", "2"}, second.getExported())
+	assert.True(t, first.closed)
+	assert.Empty(t, disabled.getExported())
+}
+
+func TestExportersSpoolOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mutex := sync.Mutex{}
+	received := []string{}
+	attempts := 0
+	available := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts++
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		received = append(received, string(body))
+	}))
+	defer server.Close()
+	getAttempts := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return attempts
+	}
+	getReceived := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string{}, received...)
+	}
+
+	// The worker waits at least one second before retrying the first interval while the next ones overflow its queue
+	exporters := NewExporters(config_statistics.ConfigStatistics{Exporters: []config_statistics.ExporterConfig{{
+		Type: EXPORTER_SNMP_AGENT, Enabled: true, Destination: server.URL,
+		Retry: config_statistics.RetryConfig{MaxRetries: 10, Backoff: 2, MaxBackoff: 2},
+		Spool: config_statistics.SpoolConfig{Path: dir},
+	}}})
+	defer exporters.Close()
+	expected := []string{}
+	for i := 1; i <= 2*EXPORTER_QUEUE_SIZE; i++ {
+		expected = append(expected, fmt.Sprint(i))
+	}
+	exporters.Publish(Interval{Data: []byte(expected[0])})
+	for i := 0; i < 100 && getAttempts() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, data := range expected[1:] {
+		exporters.Publish(Interval{Data: []byte(data)})
+	}
+
+	mutex.Lock()
+	available = true
+	mutex.Unlock()
+	for i := 0; i < 500 && len(getReceived()) < len(expected); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// No interval is lost and the order is kept
+	assert.Equal(t, expected, getReceived())
+}
+
+func TestSNMPAgentExporterSpoolsFailedIntervals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mutex := sync.Mutex{}
+	received := []string{}
+	available := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !available {
+			panic(http.ErrAbortHandler)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		received = append(received, string(body))
+	}))
+	defer server.Close()
+
+	config := config_statistics.ExporterConfig{Type: EXPORTER_SNMP_AGENT, Destination: server.URL, Spool: config_statistics.SpoolConfig{Path: dir}}
+	exporter, err := NewSNMPAgentExporter(config)
+	assert.NoError(t, err)
+	defer exporter.Close()
+
+	assert.Error(t, exporter.Export(Interval{Data: []byte("1")}))
+	// A retried interval isn't spooled twice
+	assert.Error(t, exporter.Export(Interval{Data: []byte("1")}))
+	assert.Error(t, exporter.Export(Interval{Data: []byte("2")}))
+	spool := exporter.(*SNMPAgentExporter).spool
+	assert.Equal(t, SpoolStats{Queued: 2, Pending: 2}, spool.Stats())
+
+	mutex.Lock()
+	available = true
+	mutex.Unlock()
+	assert.NoError(t, exporter.Export(Interval{Data: []byte("3")}))
+	assert.Equal(t, []string{"1", "2", "3"}, received)
+	assert.Equal(t, SpoolStats{Queued: 3, Replayed: 3}, spool.Stats())
+
+	_, err = NewSNMPAgentExporter(config_statistics.ExporterConfig{Type: EXPORTER_SNMP_AGENT, Format: "influx"})
+	assert.Error(t, err)
+}
+
+func TestSNMPAgentExporterSpoolTooSmall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mutex := sync.Mutex{}
+	received := []string{}
+	available := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		received = append(received, string(body))
+	}))
+	defer server.Close()
+
+	exporter, err := NewSNMPAgentExporter(config_statistics.ExporterConfig{Type: EXPORTER_SNMP_AGENT, Destination: server.URL, Spool: config_statistics.SpoolConfig{Path: dir}})
+	assert.NoError(t, err)
+	snmpExporter := exporter.(*SNMPAgentExporter)
+	snmpExporter.spool.Close()
+	// Room for one interval of one byte of data
+	snmpExporter.spool, err = OpenSpool("small", dir, int64(spoolHeaderSize+1+spoolChecksumSize), 0)
+	assert.NoError(t, err)
+	defer exporter.Close()
+
+	// An interval bigger than the spool isn't spooled, it stays failed until it is sent
+	assert.Error(t, exporter.Export(Interval{Data: []byte("big")}))
+	assert.Error(t, exporter.Export(Interval{Data: []byte("big")}))
+	assert.Equal(t, SpoolStats{}, snmpExporter.spool.Stats())
+
+	// Behind a spooled interval too, without dropping the spooled one
+	assert.Error(t, exporter.Export(Interval{Data: []byte("1")}))
+	assert.Error(t, exporter.Export(Interval{Data: []byte("big")}))
+	assert.Equal(t, SpoolStats{Queued: 1, Pending: 1}, snmpExporter.spool.Stats())
+
+	mutex.Lock()
+	available = true
+	mutex.Unlock()
+	assert.NoError(t, exporter.Export(Interval{Data: []byte("big")}))
+	assert.Equal(t, []string{"1", "big"}, received)
+	assert.Equal(t, SpoolStats{Queued: 1, Replayed: 1}, snmpExporter.spool.Stats())
+}
+
+func TestSNMPAgentExporterStatusCodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mutex := sync.Mutex{}
+	status := http.StatusInternalServerError
+	keys := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		assert.Equal(t, http.MethodPost, req.Method)
+		keys = append(keys, req.Header.Get(IDEMPOTENCY_KEY_HEADER))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	config := config_statistics.ExporterConfig{Type: EXPORTER_SNMP_AGENT, Destination: server.URL, Spool: config_statistics.SpoolConfig{Path: dir}}
+	exporter, err := NewSNMPAgentExporter(config)
+	assert.NoError(t, err)
+	defer exporter.Close()
+	spool := exporter.(*SNMPAgentExporter).spool
+	host := exporter.(*SNMPAgentExporter).host
+
+	// A 5xx status is a failure, the interval is spooled
+	first := Interval{Start: time.Unix(60, 0), End: time.Unix(120, 0), Data: []byte("1")}
+	err = exporter.Export(first)
+	assert.Error(t, err)
+	assert.False(t, IsPermanentError(err))
+	assert.Equal(t, SpoolStats{Queued: 1, Pending: 1}, spool.Stats())
+
+	// A 4xx status is permanent, the spooled intervals are dropped
+	mutex.Lock()
+	status = http.StatusBadRequest
+	mutex.Unlock()
+	second := Interval{Start: time.Unix(120, 0), End: time.Unix(180, 0), Data: []byte("2")}
+	assert.NoError(t, exporter.Export(second))
+	assert.Equal(t, SpoolStats{Queued: 2, Dropped: 2}, spool.Stats())
+	third := Interval{Start: time.Unix(180, 0), End: time.Unix(240, 0), Data: []byte("3")}
+	err = exporter.Export(third)
+	assert.True(t, IsPermanentError(err))
+	assert.Equal(t, SpoolStats{Queued: 2, Dropped: 2}, spool.Stats())
+
+	// The replays of an interval have the same idempotency key
+	assert.Equal(t, []string{first.IdempotencyKey(host), first.IdempotencyKey(host), second.IdempotencyKey(host), third.IdempotencyKey(host)}, keys)
+	assert.Equal(t, host+"-60000000000-120000000000", first.IdempotencyKey(host))
+}
+
+func TestExporterRetryDelay(t *testing.T) {
+	worker := &exporterWorker{retry: config_statistics.RetryConfig{Backoff: 1, MaxBackoff: 5}}
+	for attempt, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		for i := 0; i < 10; i++ {
+			retryDelay := worker.retryDelay(attempt)
+			assert.True(t, retryDelay >= delay/2 && retryDelay <= delay, "attempt %d: %v", attempt, retryDelay)
+		}
+	}
+	worker.retry = config_statistics.RetryConfig{}
+	assert.Equal(t, time.Duration(0), worker.retryDelay(3))
+}
@@ -0,0 +1,242 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outstats
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common/file"
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+const (
+	EXPORTER_INFLUXDB            = "influxdb"
+	FORMAT_LINE_PROTOCOL         = "line"
+	DEFAULT_INFLUXDB_FILE        = "influxdb/statistics.lp"
+	DEFAULT_INFLUXDB_PREFIX      = "bcn_dns_"
+	DEFAULT_INFLUXDB_PRECISION   = "ns"
+	DEFAULT_INFLUXDB_MAX_SIZE    = 10
+	DEFAULT_INFLUXDB_MAX_BACKUPS = 7
+)
+
+// Duration of a unit of the timestamp precisions
+var influxDBPrecisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// Write each interval as InfluxDB line protocol, one point per entry of the stats_map:
+// the measurement is the prefix followed by the entry type (bcn_dns_perClient, bcn_dns_perView...),
+// the tags are host and ip, view or zone, the fields are the counters (integers), average_time and the
+// percentiles of the histograms, the timestamp is the end of the interval.
+// The points are sent to an HTTP /write endpoint (InfluxDB, VictoriaMetrics) or appended to a rotating file.
+type InfluxDBExporter struct {
+	destination string
+	prefix      string
+	host        string
+	precision   time.Duration
+	username    string
+	password    string
+	token       string
+	client      *http.Client
+	rotator     *file.Rotator
+}
+
+// Escape the special characters of the measurements, tag keys, tag values and field keys
+var (
+	influxDBMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxDBKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+func init() {
+	RegisterExporter(EXPORTER_INFLUXDB, NewInfluxDBExporter)
+}
+
+func NewInfluxDBExporter(config config_statistics.ExporterConfig) (Exporter, error) {
+	if _, err := checkFormat(config, FORMAT_LINE_PROTOCOL); err != nil {
+		return nil, err
+	}
+	options := config.InfluxDB
+	exporter := &InfluxDBExporter{
+		destination: config.Destination,
+		prefix:      options.Prefix,
+		host:        options.Host,
+		username:    options.Username,
+		password:    options.Password,
+		token:       options.Token,
+	}
+	if exporter.prefix == "" {
+		exporter.prefix = DEFAULT_INFLUXDB_PREFIX
+	}
+	if exporter.host == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		exporter.host = host
+	}
+	precision := options.Precision
+	if precision == "" {
+		precision = DEFAULT_INFLUXDB_PRECISION
+	}
+	var exist bool
+	if exporter.precision, exist = influxDBPrecisions[precision]; !exist {
+		return nil, fmt.Errorf("exporter %v doesn't support the precision '%v'", config.Type, precision)
+	}
+
+	if strings.HasPrefix(exporter.destination, "http://") || strings.HasPrefix(exporter.destination, "https://") {
+		writeURL, err := url.Parse(exporter.destination)
+		if err != nil {
+			return nil, err
+		}
+		query := writeURL.Query()
+		if query.Get("precision") == "" {
+			query.Set("precision", precision)
+			writeURL.RawQuery = query.Encode()
+		}
+		exporter.destination = writeURL.String()
+		exporter.client = &http.Client{Timeout: 5 * time.Second}
+		return exporter, nil
+	}
+
+	exporter.destination = strings.TrimPrefix(exporter.destination, "file://")
+	if exporter.destination == "" {
+		exporter.destination = DEFAULT_INFLUXDB_FILE
+	}
+	exporter.destination = config_statistics.ResolvePath(exporter.destination)
+	maxSize, maxBackups := options.MaxSize, options.MaxBackups
+	if maxSize <= 0 {
+		maxSize = DEFAULT_INFLUXDB_MAX_SIZE
+	}
+	if maxBackups <= 0 {
+		maxBackups = DEFAULT_INFLUXDB_MAX_BACKUPS
+	}
+	rotatorOptions := []file.RotatorOption{
+		file.MaxSizeBytes(uint(maxSize) * 1024 * 1024),
+		file.MaxBackups(uint(maxBackups)),
+		file.Permissions(0640),
+	}
+	if options.RotateInterval > 0 {
+		rotatorOptions = append(rotatorOptions, file.Interval(time.Duration(options.RotateInterval)*time.Second))
+	}
+	rotator, err := file.NewFileRotator(exporter.destination, rotatorOptions...)
+	if err != nil {
+		return nil, err
+	}
+	exporter.rotator = rotator
+	return exporter, nil
+}
+
+func (exporter *InfluxDBExporter) Name() string {
+	return fmt.Sprintf("influxdb %v", exporter.destination)
+}
+
+func (exporter *InfluxDBExporter) Export(interval Interval) error {
+	stats, err := decodeIntervalStats(interval.Data)
+	if err != nil {
+		// Retrying doesn't fix a malformed interval
+		return PermanentError{Err: err}
+	}
+	end := stats.End
+	if end.IsZero() {
+		end = interval.End
+	}
+	points := exporter.points(stats, end)
+	if len(points) == 0 {
+		return nil
+	}
+	if exporter.rotator != nil {
+		// The points of an interval are written at once, a rotation doesn't split them
+		_, err := exporter.rotator.Write(points)
+		return err
+	}
+	return exporter.write(points)
+}
+
+func (exporter *InfluxDBExporter) Close() error {
+	if exporter.rotator != nil {
+		return exporter.rotator.Close()
+	}
+	return nil
+}
+
+func (exporter *InfluxDBExporter) write(points []byte) error {
+	req, err := http.NewRequest(http.MethodPost, exporter.destination, bytes.NewReader(points))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if exporter.token != "" {
+		req.Header.Set("Authorization", "Token "+exporter.token)
+	} else if exporter.username != "" {
+		req.SetBasicAuth(exporter.username, exporter.password)
+	}
+	resp, err := exporter.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return checkResponse(resp.StatusCode, body)
+}
+
+// Return the points of an interval, one line per entry with metrics, sorted by entry key
+func (exporter *InfluxDBExporter) points(stats *intervalStats, end time.Time) []byte {
+	points := &bytes.Buffer{}
+	timestamp := strconv.FormatInt(end.UnixNano()/int64(exporter.precision), 10)
+	for _, key := range stats.sortedKeys() {
+		entry := stats.StatsMap[key]
+		metrics := entry.metrics()
+		if len(metrics) == 0 {
+			continue
+		}
+		points.WriteString(influxDBMeasurementEscaper.Replace(exporter.prefix + entry.Type))
+		points.WriteString(",host=" + influxDBKeyEscaper.Replace(exporter.host))
+		points.WriteString("," + entry.influxDBTag() + "=" + influxDBKeyEscaper.Replace(key))
+		for i, metric := range metrics {
+			if i == 0 {
+				points.WriteByte(' ')
+			} else {
+				points.WriteByte(',')
+			}
+			points.WriteString(influxDBKeyEscaper.Replace(metric.Name) + "=")
+			if metric.Kind == METRIC_COUNTER {
+				points.WriteString(strconv.FormatInt(int64(metric.Value), 10) + "i")
+			} else {
+				points.WriteString(strconv.FormatFloat(metric.Value, 'f', -1, 64))
+			}
+		}
+		points.WriteString(" " + timestamp + "\n")
+	}
+	return points.Bytes()
+}
+
+// The clients and the servers are tagged by ip, the views by view and the zones by zone
+func (entry intervalEntry) influxDBTag() string {
+	if tag := entry.keyTag(); tag != "client" && tag != "server" {
+		return tag
+	}
+	return "ip"
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package outstats
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+const testInfluxDBPoints = `bcn_dns_perClient,host=dns\ 1,ip=10.0.0.1 average_time=2,latency_max=2.5,latency_p50=1.5,latency_p90=2,latency_p99=2,total_queries=3i,total_responses=0i 1577836860
+bcn_dns_perView,host=dns\ 1,view=internal total_queries=1i 1577836860
+`
+
+func TestInfluxDBExporterHTTP(t *testing.T) {
+	var query, authorization string
+	var body []byte
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, authorization = r.URL.RawQuery, r.Header.Get("Authorization")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	exporter, err := NewInfluxDBExporter(config_statistics.ExporterConfig{Type: EXPORTER_INFLUXDB, Destination: server.URL + "/write?db=dns",
+		InfluxDB: config_statistics.InfluxDBConfig{Host: "dns 1", Precision: "s", Token: "secret"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer exporter.Close()
+	assert.NoError(t, exporter.Export(Interval{Data: []byte(testStatsDInterval)}))
+	assert.Equal(t, "db=dns&precision=s", query)
+	assert.Equal(t, "Token secret", authorization)
+	assert.Equal(t, testInfluxDBPoints, string(body))
+
+	status = http.StatusBadRequest
+	assert.True(t, IsPermanentError(exporter.Export(Interval{Data: []byte(testStatsDInterval)})))
+	assert.True(t, IsPermanentError(exporter.Export(Interval{Data: []byte("{")})))
+
+	_, err = NewInfluxDBExporter(config_statistics.ExporterConfig{Type: EXPORTER_INFLUXDB, InfluxDB: config_statistics.InfluxDBConfig{Precision: "h"}})
+	assert.Error(t, err)
+}
+
+func TestInfluxDBExporterFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lp", "statistics.lp")
+
+	exporter, err := NewInfluxDBExporter(config_statistics.ExporterConfig{Type: EXPORTER_INFLUXDB, Destination: "file://" + path,
+		InfluxDB: config_statistics.InfluxDBConfig{Host: "dns 1", Precision: "s"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	interval := Interval{Data: []byte(testStatsDInterval)}
+	assert.NoError(t, exporter.Export(interval))
+	assert.NoError(t, exporter.Export(interval))
+	assert.NoError(t, exporter.Close())
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat(testInfluxDBPoints, 2), string(content))
+}
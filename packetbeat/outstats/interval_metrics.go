@@ -0,0 +1,135 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outstats
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Kinds of the metrics of an entry
+const (
+	METRIC_COUNTER = "counter"
+	METRIC_GAUGE   = "gauge"
+)
+
+// Entry types of the stats_map, with the tag naming their key
+var entryKeyTags = map[string]string{
+	"perClient": "client",
+	"perServer": "server",
+	"perView":   "view",
+	"perZone":   "zone",
+}
+
+// Scalar metrics that aren't counters, a whole average time is encoded as an integer
+var gaugeMetrics = map[string]bool{
+	"average_time": true,
+}
+
+type (
+	// Statistics of an interval as encoded in Interval.Data
+	intervalStats struct {
+		Start    time.Time                `json:"start"`
+		End      time.Time                `json:"end"`
+		StatsMap map[string]intervalEntry `json:"stats_map"`
+	}
+
+	intervalEntry struct {
+		Type       string                     `json:"type"`
+		DNSMetrics map[string]json.RawMessage `json:"dnsmetrics"`
+	}
+
+	histogramSummary struct {
+		Count *int64  `json:"count"`
+		P50   float64 `json:"p50"`
+		P90   float64 `json:"p90"`
+		P99   float64 `json:"p99"`
+		Max   float64 `json:"max"`
+	}
+
+	// Scalar metric of an entry: a counter of the interval, the average time or a percentile of a histogram
+	entryMetric struct {
+		Name  string
+		Kind  string
+		Value float64
+	}
+)
+
+func decodeIntervalStats(data []byte) (*intervalStats, error) {
+	stats := &intervalStats{}
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Return the entry keys sorted, for a stable output
+func (stats *intervalStats) sortedKeys() []string {
+	keys := make([]string, 0, len(stats.StatsMap))
+	for key := range stats.StatsMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Tag of the key of an entry type: client, server, view or zone
+func KeyTag(entryType string) string {
+	if tag, exist := entryKeyTags[entryType]; exist {
+		return tag
+	}
+	return "key"
+}
+
+func (entry intervalEntry) keyTag() string {
+	return KeyTag(entry.Type)
+}
+
+// Return the scalar metrics of an entry sorted by name.
+// The counters are integers, the average time is a gauge and the histograms give their percentiles and maximum as gauges.
+// The breakdowns (qtype_outcome, udp_size_buckets) aren't scalar and are skipped.
+func (entry intervalEntry) metrics() []entryMetric {
+	metrics := make([]entryMetric, 0, len(entry.DNSMetrics))
+	for name, raw := range entry.DNSMetrics {
+		// average_time is null without response
+		if string(raw) == "null" {
+			continue
+		}
+		var counter int64
+		if err := json.Unmarshal(raw, &counter); err == nil && !gaugeMetrics[name] {
+			metrics = append(metrics, entryMetric{Name: name, Kind: METRIC_COUNTER, Value: float64(counter)})
+			continue
+		}
+		var gauge float64
+		if err := json.Unmarshal(raw, &gauge); err == nil {
+			metrics = append(metrics, entryMetric{Name: name, Kind: METRIC_GAUGE, Value: gauge})
+			continue
+		}
+		var histogram histogramSummary
+		if err := json.Unmarshal(raw, &histogram); err == nil && histogram.Count != nil {
+			if *histogram.Count == 0 {
+				continue
+			}
+			metrics = append(metrics,
+				entryMetric{Name: name + "_p50", Kind: METRIC_GAUGE, Value: histogram.P50},
+				entryMetric{Name: name + "_p90", Kind: METRIC_GAUGE, Value: histogram.P90},
+				entryMetric{Name: name + "_p99", Kind: METRIC_GAUGE, Value: histogram.P99},
+				entryMetric{Name: name + "_max", Kind: METRIC_GAUGE, Value: histogram.Max})
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	return metrics
+}
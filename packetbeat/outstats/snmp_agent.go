@@ -0,0 +1,173 @@
+// Copyright 2019 BlueCat Networks (USA) Inc. and its affiliates
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//     http://www.apache.org/licenses/LICENSE-2.0
+// 
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outstats
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+const (
+	EXPORTER_SNMP_AGENT    = "snmp_agent"
+	IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+)
+
+// POST the statistics to the HTTP server of the SNMP sub-agent, with the idempotency key of the interval.
+// The intervals which couldn't be sent are spooled on disk and replayed in order with the next ones,
+// the intervals refused by the sub-agent (4xx status) are dropped.
+type SNMPAgentExporter struct {
+	destination string
+	host        string
+	client      *http.Client
+	spool       *Spool
+	// Last spooled interval, a retried interval is spooled once
+	lastSpooled *Interval
+}
+
+func init() {
+	RegisterExporter(EXPORTER_SNMP_AGENT, NewSNMPAgentExporter)
+}
+
+func NewSNMPAgentExporter(config config_statistics.ExporterConfig) (Exporter, error) {
+	if _, err := checkFormat(config, FORMAT_JSON); err != nil {
+		return nil, err
+	}
+	destination := config.Destination
+	if destination == "" {
+		destination = config_statistics.ConfigStat.StatisticsDestination
+	}
+	spool, err := OpenExporterSpool(config)
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	exporter := &SNMPAgentExporter{
+		destination: destination,
+		host:        host,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		spool:       spool,
+	}
+	return exporter, nil
+}
+
+func (exporter *SNMPAgentExporter) Name() string {
+	return fmt.Sprintf("%v %v", EXPORTER_SNMP_AGENT, exporter.destination)
+}
+
+func (exporter *SNMPAgentExporter) Export(interval Interval) error {
+	if !exporter.isSpooled(interval) {
+		if exporter.spool.Len() == 0 {
+			err := exporter.sendData(interval)
+			if err == nil || IsPermanentError(err) {
+				return err
+			}
+			exporter.spoolInterval(interval)
+			return err
+		}
+		// Sent after the spooled intervals
+		if !exporter.spoolInterval(interval) {
+			// Sent directly once the spool is empty, the worker retries it otherwise
+			if err := exporter.replay(); err != nil {
+				return err
+			}
+			return exporter.sendData(interval)
+		}
+	}
+	return exporter.replay()
+}
+
+// Spool an interval the worker can't queue, it is sent with the next export
+func (exporter *SNMPAgentExporter) Spool(interval Interval) error {
+	return exporter.spool.Append(interval)
+}
+
+func (exporter *SNMPAgentExporter) Close() error {
+	return exporter.spool.Close()
+}
+
+// Send the spooled intervals, the oldest first
+func (exporter *SNMPAgentExporter) replay() error {
+	for {
+		interval, exist := exporter.spool.Peek()
+		if !exist {
+			return nil
+		}
+		err := exporter.sendData(interval)
+		if IsPermanentError(err) {
+			logp.Err("Drop the spooled interval %v - %v: %v", interval.Start, interval.End, err)
+			exporter.spool.Drop()
+			continue
+		}
+		if err != nil {
+			logp.Debug("outstats", "%d intervals spooled for %v", exporter.spool.Len(), exporter.destination)
+			return err
+		}
+		exporter.spool.Ack()
+	}
+}
+
+// Spool an interval once, false if the spool can't hold it
+func (exporter *SNMPAgentExporter) spoolInterval(interval Interval) bool {
+	if err := exporter.spool.Append(interval); err != nil {
+		logp.Err("Couldn't spool the interval %v - %v: %v", interval.Start, interval.End, err)
+		return false
+	}
+	exporter.lastSpooled = &interval
+	return true
+}
+
+func (exporter *SNMPAgentExporter) isSpooled(interval Interval) bool {
+	return exporter.lastSpooled != nil && exporter.lastSpooled.Start.Equal(interval.Start) &&
+		exporter.lastSpooled.End.Equal(interval.End) && bytes.Equal(exporter.lastSpooled.Data, interval.Data)
+}
+
+func (exporter *SNMPAgentExporter) sendData(interval Interval) error {
+	req, err := http.NewRequest(http.MethodPost, exporter.destination, bytes.NewReader(interval.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDEMPOTENCY_KEY_HEADER, interval.IdempotencyKey(exporter.host))
+	resp, err := exporter.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return checkResponse(resp.StatusCode, body)
+}
+
+// Only a 2xx status is a success, the other 4xx than timeout and too many requests are permanent
+func checkResponse(statusCode int, body []byte) error {
+	logp.Debug("outstats", "Out Statistics Response %d %s", statusCode, body)
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+	err := fmt.Errorf("HTTP status %d: %s", statusCode, bytes.TrimSpace(body))
+	if statusCode >= 400 && statusCode < 500 && statusCode != http.StatusRequestTimeout && statusCode != http.StatusTooManyRequests {
+		return PermanentError{Err: err}
+	}
+	return err
+}
@@ -0,0 +1,322 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outstats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+// Segment file: magic, interval start and end (unix nanoseconds), data length, data
+// and the CRC-32C of all the previous bytes, big endian.
+const (
+	SPOOL_SEGMENT_EXT   = ".seg"
+	SPOOL_TMP_EXT       = ".tmp"
+	SPOOL_SEGMENT_MAGIC = "BSP1"
+	spoolHeaderSize     = 4 + 8 + 8 + 4
+	spoolChecksumSize   = 4
+)
+
+// Bounds of a spool without max_size (MB) or max_age (seconds) in its configuration
+const (
+	DEFAULT_SPOOL_MAX_SIZE = 64
+	DEFAULT_SPOOL_MAX_AGE  = 24 * 60 * 60
+)
+
+var (
+	spoolCRCTable = crc32.MakeTable(crc32.Castagnoli)
+	// Counters of the spools: outstats.spool.<name>.queued, replayed, dropped and pending
+	spoolRegistry      = monitoring.Default.NewRegistry("outstats.spool")
+	spoolRegistryMutex sync.Mutex
+	errSpoolChecksum   = errors.New("spool segment checksum mismatch")
+)
+
+type (
+	// Persistent FIFO of the intervals which couldn't be exported, one segment file per interval.
+	// The spool is bounded in size and age, the oldest intervals are dropped beyond.
+	Spool struct {
+		name     string
+		dir      string
+		maxBytes int64
+		maxAge   time.Duration
+
+		mutex    sync.Mutex
+		segments []spoolSegment
+		bytes    int64
+		nextSeq  uint64
+
+		registry *monitoring.Registry
+		queued   *monitoring.Int
+		replayed *monitoring.Int
+		dropped  *monitoring.Int
+		pending  *monitoring.Int
+	}
+
+	spoolSegment struct {
+		seq     uint64
+		size    int64
+		created time.Time
+	}
+
+	SpoolStats struct {
+		Queued   int64
+		Replayed int64
+		Dropped  int64
+		Pending  int64
+	}
+)
+
+// Open the spool directory, the segments left by a previous run are kept for replay
+func OpenSpool(name string, dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	spool := &Spool{name: name, dir: dir, maxBytes: maxBytes, maxAge: maxAge, nextSeq: 1}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		switch filepath.Ext(file.Name()) {
+		case SPOOL_TMP_EXT:
+			// Interrupted write
+			os.Remove(filepath.Join(dir, file.Name()))
+		case SPOOL_SEGMENT_EXT:
+			seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), SPOOL_SEGMENT_EXT), 16, 64)
+			if err != nil {
+				continue
+			}
+			spool.segments = append(spool.segments, spoolSegment{seq: seq, size: file.Size(), created: file.ModTime()})
+			spool.bytes += file.Size()
+			if seq >= spool.nextSeq {
+				spool.nextSeq = seq + 1
+			}
+		}
+	}
+	sort.Slice(spool.segments, func(i, j int) bool { return spool.segments[i].seq < spool.segments[j].seq })
+	spool.register()
+	spool.pending.Set(int64(len(spool.segments)))
+	if len(spool.segments) > 0 {
+		logp.Info("Spool %v: %d intervals to replay", dir, len(spool.segments))
+	}
+	return spool, nil
+}
+
+// Open the spool of an exporter, spool/<type> in the packetbeat directory by default
+func OpenExporterSpool(config config_statistics.ExporterConfig) (*Spool, error) {
+	path := config.Spool.Path
+	if path == "" {
+		path = filepath.Join("spool", config.Type)
+	}
+	maxSize := config.Spool.MaxSize
+	if maxSize <= 0 {
+		maxSize = DEFAULT_SPOOL_MAX_SIZE
+	}
+	maxAge := config.Spool.MaxAge
+	if maxAge <= 0 {
+		maxAge = DEFAULT_SPOOL_MAX_AGE
+	}
+	return OpenSpool(config.Type, config_statistics.ResolvePath(path), int64(maxSize)<<20, time.Duration(maxAge)*time.Second)
+}
+
+// Register the counters of the spool in the beat registry, under a name not used by another spool
+func (spool *Spool) register() {
+	spoolRegistryMutex.Lock()
+	defer spoolRegistryMutex.Unlock()
+	name := spool.name
+	for i := 2; spoolRegistry.Get(name) != nil; i++ {
+		name = fmt.Sprintf("%s_%d", spool.name, i)
+	}
+	spool.name = name
+	spool.registry = spoolRegistry.NewRegistry(name)
+	spool.queued = monitoring.NewInt(spool.registry, "queued")
+	spool.replayed = monitoring.NewInt(spool.registry, "replayed")
+	spool.dropped = monitoring.NewInt(spool.registry, "dropped")
+	spool.pending = monitoring.NewInt(spool.registry, "pending")
+}
+
+// Store an interval at the end of the spool, an interval bigger than the maximum size is refused
+func (spool *Spool) Append(interval Interval) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	size := int64(spoolHeaderSize + len(interval.Data) + spoolChecksumSize)
+	if spool.maxBytes > 0 && size > spool.maxBytes {
+		return fmt.Errorf("interval of %d bytes bigger than the spool maximum size of %d bytes", size, spool.maxBytes)
+	}
+	seq := spool.nextSeq
+	spool.nextSeq++
+
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	buf.WriteString(SPOOL_SEGMENT_MAGIC)
+	binary.Write(buf, binary.BigEndian, interval.Start.UnixNano())
+	binary.Write(buf, binary.BigEndian, interval.End.UnixNano())
+	binary.Write(buf, binary.BigEndian, uint32(len(interval.Data)))
+	buf.Write(interval.Data)
+	binary.Write(buf, binary.BigEndian, crc32.Checksum(buf.Bytes(), spoolCRCTable))
+
+	// Write the segment under a temporary name, a segment is never seen partially written
+	path := spool.segmentPath(seq)
+	if err := writeFileSync(path+SPOOL_TMP_EXT, buf.Bytes()); err != nil {
+		os.Remove(path + SPOOL_TMP_EXT)
+		return err
+	}
+	if err := os.Rename(path+SPOOL_TMP_EXT, path); err != nil {
+		os.Remove(path + SPOOL_TMP_EXT)
+		return err
+	}
+	spool.segments = append(spool.segments, spoolSegment{seq: seq, size: int64(buf.Len()), created: time.Now()})
+	spool.bytes += int64(buf.Len())
+	spool.queued.Inc()
+	spool.enforceBounds()
+	return nil
+}
+
+// Return the oldest interval of the spool, false if the spool is empty.
+// Expired and corrupted segments are dropped.
+func (spool *Spool) Peek() (Interval, bool) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	spool.enforceBounds()
+	for len(spool.segments) > 0 {
+		interval, err := readSegment(spool.segmentPath(spool.segments[0].seq))
+		if err == nil {
+			return interval, true
+		}
+		logp.Err("Drop the spool segment %v: %v", spool.segmentPath(spool.segments[0].seq), err)
+		spool.removeOldest(spool.dropped)
+	}
+	return Interval{}, false
+}
+
+// Remove the oldest interval once it is exported
+func (spool *Spool) Ack() {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	if len(spool.segments) > 0 {
+		spool.removeOldest(spool.replayed)
+	}
+}
+
+// Remove the oldest interval when it can't be exported
+func (spool *Spool) Drop() {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	if len(spool.segments) > 0 {
+		spool.removeOldest(spool.dropped)
+	}
+}
+
+func (spool *Spool) Len() int {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	return len(spool.segments)
+}
+
+func (spool *Spool) Stats() SpoolStats {
+	return SpoolStats{
+		Queued:   spool.queued.Get(),
+		Replayed: spool.replayed.Get(),
+		Dropped:  spool.dropped.Get(),
+		Pending:  spool.pending.Get(),
+	}
+}
+
+// Unregister the counters, the segments stay on disk for the next run
+func (spool *Spool) Close() error {
+	spoolRegistryMutex.Lock()
+	defer spoolRegistryMutex.Unlock()
+	spoolRegistry.Remove(spool.name)
+	return nil
+}
+
+// Drop the oldest segments beyond the maximum size or age
+func (spool *Spool) enforceBounds() {
+	for len(spool.segments) > 0 && spool.maxBytes > 0 && spool.bytes > spool.maxBytes {
+		spool.removeOldest(spool.dropped)
+	}
+	for len(spool.segments) > 0 && spool.maxAge > 0 && time.Since(spool.segments[0].created) > spool.maxAge {
+		spool.removeOldest(spool.dropped)
+	}
+	spool.pending.Set(int64(len(spool.segments)))
+}
+
+func (spool *Spool) removeOldest(counter *monitoring.Int) {
+	segment := spool.segments[0]
+	if err := os.Remove(spool.segmentPath(segment.seq)); err != nil && !os.IsNotExist(err) {
+		logp.Err("Couldn't remove the spool segment: %v", err)
+	}
+	spool.segments = spool.segments[1:]
+	spool.bytes -= segment.size
+	spool.pending.Set(int64(len(spool.segments)))
+	counter.Inc()
+}
+
+func (spool *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(spool.dir, fmt.Sprintf("%016x%s", seq, SPOOL_SEGMENT_EXT))
+}
+
+func readSegment(path string) (Interval, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Interval{}, err
+	}
+	if len(content) < spoolHeaderSize+spoolChecksumSize || string(content[:4]) != SPOOL_SEGMENT_MAGIC {
+		return Interval{}, errors.New("invalid spool segment")
+	}
+	body := content[:len(content)-spoolChecksumSize]
+	if crc32.Checksum(body, spoolCRCTable) != binary.BigEndian.Uint32(content[len(body):]) {
+		return Interval{}, errSpoolChecksum
+	}
+	length := binary.BigEndian.Uint32(body[20:24])
+	if int(length) != len(body)-spoolHeaderSize {
+		return Interval{}, errors.New("invalid spool segment length")
+	}
+	return Interval{
+		Start: time.Unix(0, int64(binary.BigEndian.Uint64(body[4:12]))),
+		End:   time.Unix(0, int64(binary.BigEndian.Uint64(body[12:20]))),
+		Data:  body[spoolHeaderSize:],
+	}, nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package outstats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSpool(t *testing.T, dir string, maxBytes int64, maxAge time.Duration) *Spool {
+	spool, err := OpenSpool("test", dir, maxBytes, maxAge)
+	assert.NoError(t, err)
+	return spool
+}
+
+func testInterval(data string) Interval {
+	start := time.Unix(1577836800, 0)
+	return Interval{Start: start, End: start.Add(time.Minute), Data: []byte(data)}
+}
+
+func drainSpool(spool *Spool) []string {
+	intervals := []string{}
+	for {
+		interval, exist := spool.Peek()
+		if !exist {
+			return intervals
+		}
+		intervals = append(intervals, string(interval.Data))
+		spool.Ack()
+	}
+}
+
+func TestSpoolReplayInOrderAfterReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spool := newTestSpool(t, dir, 0, 0)
+	for _, data := range []string{"1", "2", "3"} {
+		assert.NoError(t, spool.Append(testInterval(data)))
+	}
+	interval, exist := spool.Peek()
+	assert.True(t, exist)
+	assert.Equal(t, testInterval("1").Start.UnixNano(), interval.Start.UnixNano())
+	assert.Equal(t, testInterval("1").End.UnixNano(), interval.End.UnixNano())
+	spool.Ack()
+	spool.Close()
+
+	// An interrupted write is ignored
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0000000000000009"+SPOOL_SEGMENT_EXT+SPOOL_TMP_EXT), []byte("4"), 0640))
+	spool = newTestSpool(t, dir, 0, 0)
+	defer spool.Close()
+	assert.Equal(t, 2, spool.Len())
+	assert.NoError(t, spool.Append(testInterval("4")))
+	assert.Equal(t, []string{"2", "3", "4"}, drainSpool(spool))
+	assert.Equal(t, SpoolStats{Queued: 1, Replayed: 3}, spool.Stats())
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Empty(t, files)
+}
+
+func TestSpoolDropsCorruptedSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spool := newTestSpool(t, dir, 0, 0)
+	defer spool.Close()
+	assert.NoError(t, spool.Append(testInterval("corrupted")))
+	assert.NoError(t, spool.Append(testInterval("valid")))
+
+	path := spool.segmentPath(spool.segments[0].seq)
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	content[spoolHeaderSize] ^= 0xff
+	assert.NoError(t, ioutil.WriteFile(path, content, 0640))
+
+	assert.Equal(t, []string{"valid"}, drainSpool(spool))
+	assert.Equal(t, SpoolStats{Queued: 2, Replayed: 1, Dropped: 1}, spool.Stats())
+}
+
+func TestSpoolBounds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Room for two segments of one byte of data
+	segmentSize := int64(spoolHeaderSize + 1 + spoolChecksumSize)
+	spool := newTestSpool(t, dir, 2*segmentSize, 0)
+	for _, data := range []string{"1", "2", "3"} {
+		assert.NoError(t, spool.Append(testInterval(data)))
+	}
+	assert.Equal(t, SpoolStats{Queued: 3, Dropped: 1, Pending: 2}, spool.Stats())
+	// An interval bigger than the spool is refused without dropping the others
+	assert.Error(t, spool.Append(testInterval("interval bigger than the spool of two segments")))
+	assert.Equal(t, SpoolStats{Queued: 3, Dropped: 1, Pending: 2}, spool.Stats())
+	assert.Equal(t, []string{"2", "3"}, drainSpool(spool))
+	spool.Close()
+
+	spool = newTestSpool(t, dir, 0, 10*time.Millisecond)
+	defer spool.Close()
+	assert.NoError(t, spool.Append(testInterval("expired")))
+	time.Sleep(20 * time.Millisecond)
+	_, exist := spool.Peek()
+	assert.False(t, exist)
+	assert.Equal(t, SpoolStats{Queued: 1, Dropped: 1}, spool.Stats())
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outstats
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+const (
+	EXPORTER_STATSD         = "statsd"
+	FORMAT_STATSD           = "statsd"
+	FORMAT_DOGSTATSD        = "dogstatsd"
+	DEFAULT_STATSD_ADDRESS  = "127.0.0.1:8125"
+	DEFAULT_STATSD_PACKET   = 1432
+	DEFAULT_STATSD_PREFIX   = "bcn_dns"
+	STATSD_TYPE_COUNTER     = "c"
+	STATSD_TYPE_GAUGE       = "g"
+	STATSD_NAME_REPLACEMENT = "_"
+)
+
+// Send the counters and the latency figures of each entry as StatsD or DogStatsD metrics,
+// over UDP (udp://host:port or host:port) or a Unix datagram socket (unixgram:///path).
+// StatsD has no tag: the instance, the entry type and its key are parts of the metric name,
+// e.g. bcn_dns.<instance>.perClient.10_0_0_1.total_queries.
+// DogStatsD tags the metrics with instance, type and client, server, view or zone.
+type StatsDExporter struct {
+	network       string
+	address       string
+	format        string
+	prefix        string
+	instance      string
+	sampleRate    float64
+	maxPacketSize int
+	conn          net.Conn
+}
+
+var statsDNameReplacer = strings.NewReplacer(".", STATSD_NAME_REPLACEMENT, ":", STATSD_NAME_REPLACEMENT,
+	"|", STATSD_NAME_REPLACEMENT, "@", STATSD_NAME_REPLACEMENT, "#", STATSD_NAME_REPLACEMENT,
+	",", STATSD_NAME_REPLACEMENT, " ", STATSD_NAME_REPLACEMENT, "/", STATSD_NAME_REPLACEMENT)
+
+var statsDTagReplacer = strings.NewReplacer("|", STATSD_NAME_REPLACEMENT, ",", STATSD_NAME_REPLACEMENT,
+	"#", STATSD_NAME_REPLACEMENT, " ", STATSD_NAME_REPLACEMENT)
+
+func init() {
+	RegisterExporter(EXPORTER_STATSD, NewStatsDExporter)
+}
+
+func NewStatsDExporter(config config_statistics.ExporterConfig) (Exporter, error) {
+	format, err := checkFormat(config, FORMAT_STATSD, FORMAT_DOGSTATSD)
+	if err != nil {
+		return nil, err
+	}
+	exporter := &StatsDExporter{
+		network:       "udp",
+		address:       config.Destination,
+		format:        format,
+		prefix:        config.StatsD.Prefix,
+		instance:      config.StatsD.Instance,
+		sampleRate:    config.StatsD.SampleRate,
+		maxPacketSize: config.StatsD.MaxPacketSize,
+	}
+	switch {
+	case exporter.address == "":
+		exporter.address = DEFAULT_STATSD_ADDRESS
+	case strings.HasPrefix(exporter.address, "udp://"):
+		exporter.address = strings.TrimPrefix(exporter.address, "udp://")
+	case strings.HasPrefix(exporter.address, "unixgram://"):
+		exporter.network, exporter.address = "unixgram", strings.TrimPrefix(exporter.address, "unixgram://")
+	}
+	if exporter.prefix == "" {
+		exporter.prefix = DEFAULT_STATSD_PREFIX
+	}
+	if exporter.instance == "" {
+		if exporter.instance, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	if exporter.sampleRate <= 0 || exporter.sampleRate > 1 {
+		exporter.sampleRate = 1
+	}
+	if exporter.maxPacketSize <= 0 {
+		exporter.maxPacketSize = DEFAULT_STATSD_PACKET
+	}
+	return exporter, nil
+}
+
+func (exporter *StatsDExporter) Name() string {
+	return fmt.Sprintf("%v %v://%v", exporter.format, exporter.network, exporter.address)
+}
+
+func (exporter *StatsDExporter) Export(interval Interval) error {
+	stats, err := decodeIntervalStats(interval.Data)
+	if err != nil {
+		// Retrying doesn't fix a malformed interval
+		return PermanentError{Err: err}
+	}
+	// The Unix socket of the daemon may not exist yet, the connection is opened at the first export
+	if exporter.conn == nil {
+		if exporter.conn, err = net.Dial(exporter.network, exporter.address); err != nil {
+			return err
+		}
+	}
+	for _, packet := range exporter.packets(stats) {
+		if _, err := exporter.conn.Write(packet); err != nil {
+			exporter.conn.Close()
+			exporter.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (exporter *StatsDExporter) Close() error {
+	if exporter.conn != nil {
+		return exporter.conn.Close()
+	}
+	return nil
+}
+
+// Return the metric lines packed into datagrams of at most maxPacketSize bytes
+func (exporter *StatsDExporter) packets(stats *intervalStats) [][]byte {
+	packets := [][]byte{}
+	packet := &bytes.Buffer{}
+	for _, line := range exporter.lines(stats) {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > exporter.maxPacketSize {
+			packets = append(packets, packet.Bytes())
+			packet = &bytes.Buffer{}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.Bytes())
+	}
+	return packets
+}
+
+// Return the metric lines of an interval. The null counters are skipped, the counters are sampled.
+func (exporter *StatsDExporter) lines(stats *intervalStats) []string {
+	lines := []string{}
+	for _, key := range stats.sortedKeys() {
+		entry := stats.StatsMap[key]
+		for _, metric := range entry.metrics() {
+			metricType, rate := STATSD_TYPE_GAUGE, ""
+			if metric.Kind == METRIC_COUNTER {
+				if metric.Value == 0 || (exporter.sampleRate < 1 && rand.Float64() >= exporter.sampleRate) {
+					continue
+				}
+				metricType = STATSD_TYPE_COUNTER
+				if exporter.sampleRate < 1 {
+					rate = "|@" + strconv.FormatFloat(exporter.sampleRate, 'f', -1, 64)
+				}
+			}
+			value := strconv.FormatFloat(metric.Value, 'f', -1, 64)
+			if exporter.format == FORMAT_DOGSTATSD {
+				tags := fmt.Sprintf("|#instance:%s,type:%s,%s:%s", statsDTagReplacer.Replace(exporter.instance),
+					entry.Type, entry.keyTag(), statsDTagReplacer.Replace(key))
+				lines = append(lines, fmt.Sprintf("%s.%s:%s|%s%s%s", exporter.prefix, metric.Name, value, metricType, rate, tags))
+			} else {
+				lines = append(lines, fmt.Sprintf("%s.%s.%s.%s.%s:%s|%s%s", exporter.prefix, statsDNameReplacer.Replace(exporter.instance),
+					entry.Type, statsDNameReplacer.Replace(key), metric.Name, value, metricType, rate))
+			}
+		}
+	}
+	return lines
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package outstats
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+const testStatsDInterval = `{"start":"2020-01-01T00:00:00Z","end":"2020-01-01T00:01:00Z","stats_map":{
+"10.0.0.1":{"type":"perClient","dnsmetrics":{"total_queries":3,"total_responses":0,"average_time":2,
+"latency":{"count":3,"p50":1.5,"p90":2,"p99":2,"max":2.5},"request_size":{"count":0},"qtype_outcome":{"A":{"success":3}}}},
+"internal":{"type":"perView","dnsmetrics":{"total_queries":1,"average_time":null}}}}`
+
+// Return the lines of the datagrams received until the timeout
+func receiveStatsD(t *testing.T, conn net.PacketConn, packets int) []string {
+	lines := []string{}
+	buffer := make([]byte, 65536)
+	for i := 0; i < packets; i++ {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buffer)
+		if !assert.NoError(t, err) {
+			break
+		}
+		lines = append(lines, strings.Split(string(buffer[:n]), "\n")...)
+	}
+	return lines
+}
+
+func TestStatsDExporterFormats(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	interval := Interval{Data: []byte(testStatsDInterval)}
+
+	statsd, err := NewStatsDExporter(config_statistics.ExporterConfig{Type: EXPORTER_STATSD,
+		Destination: "udp://" + conn.LocalAddr().String(), StatsD: config_statistics.StatsDConfig{Instance: "dns1.example.com"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer statsd.Close()
+	assert.NoError(t, statsd.Export(interval))
+	assert.Equal(t, []string{
+		"bcn_dns.dns1_example_com.perClient.10_0_0_1.average_time:2|g",
+		"bcn_dns.dns1_example_com.perClient.10_0_0_1.latency_max:2.5|g",
+		"bcn_dns.dns1_example_com.perClient.10_0_0_1.latency_p50:1.5|g",
+		"bcn_dns.dns1_example_com.perClient.10_0_0_1.latency_p90:2|g",
+		"bcn_dns.dns1_example_com.perClient.10_0_0_1.latency_p99:2|g",
+		"bcn_dns.dns1_example_com.perClient.10_0_0_1.total_queries:3|c",
+		"bcn_dns.dns1_example_com.perView.internal.total_queries:1|c",
+	}, receiveStatsD(t, conn, 1))
+	assert.True(t, IsPermanentError(statsd.Export(Interval{Data: []byte("{")})))
+
+	dogstatsd, err := NewStatsDExporter(config_statistics.ExporterConfig{Type: EXPORTER_STATSD, Format: FORMAT_DOGSTATSD,
+		Destination: conn.LocalAddr().String(), StatsD: config_statistics.StatsDConfig{Prefix: "dns", Instance: "dns1", MaxPacketSize: 100}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer dogstatsd.Close()
+	assert.NoError(t, dogstatsd.Export(interval))
+	// One line per datagram
+	lines := receiveStatsD(t, conn, 7)
+	assert.Len(t, lines, 7)
+	assert.Contains(t, lines, "dns.total_queries:3|c|#instance:dns1,type:perClient,client:10.0.0.1")
+	assert.Contains(t, lines, "dns.total_queries:1|c|#instance:dns1,type:perView,view:internal")
+	assert.Contains(t, lines, "dns.latency_p50:1.5|g|#instance:dns1,type:perClient,client:10.0.0.1")
+
+	_, err = NewStatsDExporter(config_statistics.ExporterConfig{Type: EXPORTER_STATSD, Format: FORMAT_JSON})
+	assert.Error(t, err)
+}
+
+func TestStatsDExporterSampling(t *testing.T) {
+	exporter, err := NewStatsDExporter(config_statistics.ExporterConfig{Type: EXPORTER_STATSD,
+		StatsD: config_statistics.StatsDConfig{Instance: "dns1", SampleRate: 0.5}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	stats, err := decodeIntervalStats([]byte(testStatsDInterval))
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 20; i++ {
+		for _, line := range exporter.(*StatsDExporter).lines(stats) {
+			if strings.Contains(line, "|c") {
+				assert.True(t, strings.HasSuffix(line, "|c|@0.5"), line)
+			}
+		}
+	}
+}
+
+func TestStatsDExporterUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dsd.socket")
+
+	exporter, err := NewStatsDExporter(config_statistics.ExporterConfig{Type: EXPORTER_STATSD, Destination: "unixgram://" + path,
+		StatsD: config_statistics.StatsDConfig{Instance: "dns1"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer exporter.Close()
+	interval := Interval{Data: []byte(testStatsDInterval)}
+	// The daemon isn't listening yet
+	assert.Error(t, exporter.Export(interval))
+
+	conn, err := net.ListenPacket("unixgram", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.NoError(t, exporter.Export(interval))
+	assert.Contains(t, receiveStatsD(t, conn, 1), "bcn_dns.dns1.perView.internal.total_queries:1|c")
+}
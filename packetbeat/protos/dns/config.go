@@ -31,6 +31,9 @@ type dnsConfig struct {
 
 	// [Bluecat]
 	DropDecodedPacket bool `config:"drop_decoded_packet"`
+	// Publish the transactions and the statistics of each interval through the libbeat pipeline
+	PublishTransactions bool `config:"publish_transactions"`
+	PublishStatistics   bool `config:"publish_statistics"`
 
 	Kafka KafkaConfig `json:"kafka"`
 }
@@ -39,6 +42,8 @@ type (
 	KafkaConfig struct {
 		Hosts []string `config:"hosts"               validate:"required"`
 		Topic string   `config:"topic"               validate:"required"`
+		// [Bluecat] Topic of the per-transaction records, not published if empty
+		TransactionsTopic string `config:"transactions_topic"`
 		// TLS              *tlscommon.Config         `config:"ssl"`
 		Timeout time.Duration `config:"timeout"             validate:"min=1"`
 		// Metadata         MetaConfig                `config:"metadata"`
@@ -81,5 +86,17 @@ var (
 		ProtocolCommon: config.ProtocolCommon{
 			TransactionTimeout: protos.DefaultTransactionExpiration,
 		},
+		// [Bluecat] Defaults of the libbeat Kafka output
+		Kafka: KafkaConfig{
+			Timeout:          30 * time.Second,
+			BrokerTimeout:    10 * time.Second,
+			Compression:      "gzip",
+			CompressionLevel: 4,
+			Version:          kafka.Version("1.0.0"),
+			MaxRetries:       3,
+			ClientID:         "beats",
+			ChanBufferSize:   256,
+			MaxMessageBytes:  1000000,
+		},
 	}
 )
@@ -63,6 +63,13 @@ type dnsPlugin struct {
 
 	// [Bluecat]
 	dropDecodedPacket bool
+	// Statistics engine counting the DNS traffic, nil until it is set
+	statistics *statsdns.StatisticsEngine
+	// Kafka producer of the statistics and the transactions, nil without kafka hosts
+	kafka *kafkaProducer
+	// Publish the transactions and the statistics through the libbeat pipeline
+	publishTransactions bool
+	publishStatistics   bool
 
 }
 
@@ -274,6 +281,16 @@ func (dns *dnsPlugin) init(results protos.Reporter, config *dnsConfig) error {
 
 	// [Bluecat]
 	dns.dropDecodedPacket = config.DropDecodedPacket
+	dns.publishTransactions = config.PublishTransactions
+	dns.publishStatistics = config.PublishStatistics
+	if len(config.Kafka.Hosts) > 0 {
+		kafka, err := newKafkaProducer(&config.Kafka)
+		if err != nil {
+			logp.Err("Kafka output disabled: %v", err)
+		} else {
+			dns.kafka = kafka
+		}
+	}
 
 	return nil
 }
@@ -308,6 +325,20 @@ func (dns *dnsPlugin) deleteTransaction(k hashableDNSTuple) *dnsTransaction {
 	return nil
 }
 
+// [Bluecat] Set the statistics engine counting the DNS traffic
+func (dns *dnsPlugin) SetStatisticsEngine(statistics *statsdns.StatisticsEngine) {
+	// The same analyzer is set for UDP and TCP, its producer is added once
+	if statistics != nil && statistics != dns.statistics {
+		if dns.kafka != nil {
+			statistics.AddExporter(dns.kafka)
+		}
+		if dns.publishStatistics && dns.results != nil {
+			statistics.AddExporter(&statisticsReporter{results: dns.results})
+		}
+	}
+	dns.statistics = statistics
+}
+
 func (dns *dnsPlugin) GetPorts() []int {
 	return dns.ports
 }
@@ -324,7 +355,7 @@ func (dns *dnsPlugin) receivedDNSRequest(tuple *dnsTuple, msg *dnsMessage) {
 	isDuplicated := false
 
 	// Don't receive internal DNS request
-	if statsdns.IsInternalCall(srcIP, dstIP) {
+	if dns.statistics.IsInternalCall(srcIP, dstIP) {
 		return
 	}
 
@@ -355,8 +386,10 @@ func (dns *dnsPlugin) receivedDNSRequest(tuple *dnsTuple, msg *dnsMessage) {
 	}
 
 	//Bluecat
-	queryDNS := statsdns.NewQueryDNS(srcIP, dstIP, isDuplicated)
-	statsdns.QStatDNS.PushQueryDNS(queryDNS)
+	queryDNS := statsdns.NewQueryDNS(srcIP, dstIP, questionName(msg.data), isDuplicated).
+		WithTransport(tuple.transport.String(), requestOpt(msg.data)).
+		WithViewSelection(viewSelection(msg.data))
+	dns.statistics.PushQueryDNS(queryDNS)
 
 	trans = newTransaction(msg.ts, *tuple, *msg.cmdlineTuple)
 
@@ -369,7 +402,7 @@ func (dns *dnsPlugin) receivedDNSRequest(tuple *dnsTuple, msg *dnsMessage) {
 	trans.request = msg
 	// Bluecat Store all request messages for the recursion counting purpose
 	if trans.request != nil && trans.request.data != nil {
-		statsdns.AddRequestMsgMap(trans.src.IP, trans.dst.IP, tuple.id, trans.request.data.Question)
+		dns.statistics.AddRequestMsgMap(trans.src.IP, trans.dst.IP, tuple.id, trans.request.data.Question)
 	}
 }
 
@@ -380,7 +413,7 @@ func (dns *dnsPlugin) receivedDNSResponse(tuple *dnsTuple, msg *dnsMessage) {
 	dstIP := msg.tuple.DstIP.String()
 	isDrop := false
 	// Don't receive internal DNS response
-	if statsdns.IsInternalCall(srcIP, dstIP) {
+	if dns.statistics.IsInternalCall(srcIP, dstIP) {
 		return
 	}
 
@@ -392,6 +425,7 @@ func (dns *dnsPlugin) receivedDNSResponse(tuple *dnsTuple, msg *dnsMessage) {
 		debugf("%s %s", orphanedResponse.Error(), tuple.String())
 		isDrop = true
 		unmatchedResponses.Add(1)
+		dns.statistics.PushOrphanedDNS(statsdns.NewQueryDNS(srcIP, dstIP, questionName(msg.data), false))
 	}
 
 	trans.response = msg
@@ -419,7 +453,8 @@ func (dns *dnsPlugin) receivedDNSResponse(tuple *dnsTuple, msg *dnsMessage) {
 	}
 	// Bluecat Determine the recursion query
 	if trans.request != nil && trans.request.data != nil {
-		statsdns.CalculateRecursiveMsg(trans.src.IP, trans.dst.IP, tuple.id, trans.request.data.Question, trans.response.data)
+		recursionDesired, tsigKey := viewSelection(trans.request.data)
+		dns.statistics.CalculateRecursiveMsg(trans.src.IP, trans.dst.IP, tuple.id, trans.request.data.Question, trans.response.data, recursionDesired, tsigKey)
 	}
 
 	dns.publishTransaction(trans, isDrop)
@@ -482,6 +517,10 @@ func (dns *dnsPlugin) publishTransaction(t *dnsTransaction, isDrop bool) {
 		// If transaction has not response, 
 		// not need to increase TotalResponse and otherDNSType
 		// [eg: Responses decode error]
+		record.DNS = dnsRec
+		dns.kafka.publishRecord(record)
+		dns.publishEvent(t, record)
+		dns.statistics.LogUnansweredDNS(record)
 		return
 	} else if t.response != nil {
 		record.BytesOut = t.response.length
@@ -497,8 +536,10 @@ func (dns *dnsPlugin) publishTransaction(t *dnsTransaction, isDrop bool) {
 	record.DNS = dnsRec
 
 	logp.Debug("Record Decoded", "%v", record)
+	dns.kafka.publishRecord(record)
+	dns.publishEvent(t, record)
 	if !isDrop {
-		statsdns.QStatDNS.PushRecordDNS(record)
+		dns.statistics.PushRecordDNS(record)
 	}
 }
 
@@ -568,6 +609,46 @@ func (dns *dnsPlugin) expireTransaction(t *dnsTransaction) {
 	// debugf("%s %s", noResponse.Error(), t.tuple.String())
 	dns.publishTransaction(t, true)
 	unmatchedRequests.Add(1)
+	// [Bluecat] Count the query that never got a response
+	if t.request != nil && t.response == nil {
+		dns.statistics.PushTimeoutDNS(statsdns.NewQueryDNS(t.src.IP, t.dst.IP, questionName(t.request.data), false).
+			WithViewSelection(viewSelection(t.request.data)))
+	}
+}
+
+// [Bluecat] Return the name of the first question, empty if there is none
+func questionName(msg *mkdns.Msg) string {
+	if msg == nil || len(msg.Question) == 0 {
+		return ""
+	}
+	return msg.Question[0].Name
+}
+
+// [Bluecat] Return the EDNS OPT record of the query, nil without EDNS
+func requestOpt(msg *mkdns.Msg) *model.Opt {
+	if msg == nil {
+		return nil
+	}
+	if rrOPT := msg.IsEdns0(); rrOPT != nil {
+		return toOpt(rrOPT)
+	}
+	return nil
+}
+
+// [Bluecat] Return the RD bit and the TSIG key name of the query, the name server chooses its view with them
+func viewSelection(msg *mkdns.Msg) (bool, string) {
+	if msg == nil {
+		return false, ""
+	}
+	return msg.RecursionDesired, tsigKeyName(msg)
+}
+
+// [Bluecat] Return the name of the TSIG key the message is signed with, empty if it isn't signed
+func tsigKeyName(msg *mkdns.Msg) string {
+	if tsig := msg.IsTsig(); tsig != nil {
+		return tsig.Hdr.Name
+	}
+	return ""
 }
 
 // Adds the DNS message data to the supplied MapStr.
@@ -665,6 +746,7 @@ func toDNSRecord(dns *mkdns.Msg, authority bool, additional bool) *model.DNS {
 	if rrOPT != nil {
 		r.Opt = toOpt(rrOPT)
 	}
+	r.TSIGKey = tsigKeyName(dns)
 
 	r.AnswersCount = len(dns.Answer)
 	if len(dns.Answer) > 0 {
@@ -1110,12 +1192,12 @@ func decodeDNSHeader(transp transport, rawData []byte) (dns *mkdns.Msg, err erro
 }
 
 //Capture case Format Error
-func handleErrorMsg(srcIP, dstIP string, transp transport, rawData []byte, tuple common.IPPortTuple) {
+func (dns *dnsPlugin) handleErrorMsg(srcIP, dstIP string, transp transport, rawData []byte, tuple common.IPPortTuple) {
 	dnsHdr, _ := decodeDNSHeader(transp, rawData)
 	if dnsHdr.Response {
-		statsdns.HandleResponseDecodeErr(dstIP, srcIP, dnsResponseCodeToString(dnsHdr.MsgHdr.Rcode))
+		dns.statistics.HandleResponseDecodeErr(dstIP, srcIP, dnsResponseCodeToString(dnsHdr.MsgHdr.Rcode))
 	} else {
 		// statsdns.CreateCounterMetric(srcIP, dstIP)
-		statsdns.HandleRequestDecodeErr(srcIP, dstIP)
+		dns.statistics.HandleRequestDecodeErr(srcIP, dstIP)
 	}
 }
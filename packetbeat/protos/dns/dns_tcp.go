@@ -140,7 +140,7 @@ func (dns *dnsPlugin) doParse(conn *dnsConnectionData, pkt *protos.Packet, tcpTu
 
 		//[Bluecat]
 		// Need to update the metric for the client
-		handleErrorMsg(pkt.Tuple.SrcIP.String(), pkt.Tuple.DstIP.String(), transportTCP, pkt.Payload, pkt.Tuple)
+		dns.handleErrorMsg(pkt.Tuple.SrcIP.String(), pkt.Tuple.DstIP.String(), transportTCP, pkt.Payload, pkt.Tuple)
 		// debugf("%s addresses %s, length %d", err.Error(),
 		// 	tcpTuple.String(), len(stream.rawData))
 
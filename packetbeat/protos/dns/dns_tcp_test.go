@@ -30,6 +30,7 @@ import (
 	"math/rand"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/elastic/beats/packetbeat/protos"
 	"github.com/elastic/beats/packetbeat/protos/tcp"
@@ -359,6 +360,9 @@ func TestParseTcp_errorDuplicateRequests(t *testing.T) {
 	dns.Parse(packet, tcptuple, tcp.TCPDirectionOriginal, private)
 	assert.Equal(t, 1, dns.transactions.Size(), "There should be one transaction.")
 
+	// [Bluecat] A query with the same timestamp is the same packet captured twice, it is ignored
+	packet = newPacket(forward, q.request)
+	packet.Ts = packet.Ts.Add(time.Millisecond)
 	dns.Parse(packet, tcptuple, tcp.TCPDirectionOriginal, private)
 	// The first request is published and this one becomes a transaction
 	assert.Equal(t, 1, dns.transactions.Size(), "There should be one transaction.")
@@ -386,6 +390,9 @@ func TestParseTcp_errorDuplicateRequestsOneStream(t *testing.T) {
 	private = dns.Parse(packet, tcptuple, tcp.TCPDirectionOriginal, private)
 	assert.Equal(t, 1, dns.transactions.Size(), "There should be one transaction.")
 
+	// [Bluecat] A query with the same timestamp is the same packet captured twice, it is ignored
+	packet = newPacket(forward, q.request)
+	packet.Ts = packet.Ts.Add(time.Millisecond)
 	dns.Parse(packet, tcptuple, tcp.TCPDirectionOriginal, private)
 	// The first query is published and this one becomes a transaction
 	assert.Equal(t, 1, dns.transactions.Size(), "There should be one transaction.")
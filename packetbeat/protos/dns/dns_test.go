@@ -102,11 +102,12 @@ func newDNS(store *eventStore, verbose bool) *dnsPlugin {
 	}
 
 	cfg, _ := common.NewConfigFrom(map[string]interface{}{
-		"ports":               []int{serverPort},
-		"include_authorities": true,
-		"include_additionals": true,
-		"send_request":        true,
-		"send_response":       true,
+		"ports":                []int{serverPort},
+		"include_authorities":  true,
+		"include_additionals":  true,
+		"send_request":         true,
+		"send_response":        true,
+		"publish_transactions": true,
 	})
 	dns, err := New(false, callback, cfg)
 	if err != nil {
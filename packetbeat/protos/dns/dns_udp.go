@@ -87,7 +87,7 @@ func (dns *dnsPlugin) ParseUDP(pkt *protos.Packet) {
 	if err != nil {
 		//Bluecat
 		// Need to update the metric for the client
-		handleErrorMsg(pkt.Tuple.SrcIP.String(), pkt.Tuple.DstIP.String(), transportUDP, pkt.Payload, pkt.Tuple)
+		dns.handleErrorMsg(pkt.Tuple.SrcIP.String(), pkt.Tuple.DstIP.String(), transportUDP, pkt.Payload, pkt.Tuple)
 		// This means that malformed requests or responses are being sent or
 		// that someone is attempting to the DNS port for non-DNS traffic. Both
 		// are issues that a monitoring system should report.
@@ -265,7 +265,8 @@ func TestParseUdp_emptyPacket(t *testing.T) {
 // Verify that a malformed packet is safely handled (no panics).
 func TestParseUdp_malformedPacket(t *testing.T) {
 	dns := newDNS(nil, testing.Verbose())
-	garbage := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}
+	// [Bluecat] Without the TC bit: a truncated message is kept even if it can't be unpacked
+	garbage := []byte{0, 1, 0, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}
 	packet := newPacket(forward, garbage)
 	dns.ParseUDP(packet)
 	assert.Empty(t, dns.transactions.Size(), "There should be no transactions.")
@@ -374,7 +375,7 @@ func TestPublishTransaction_emptyDnsRequest(t *testing.T) {
 	trans.request = &dnsMessage{
 		data: &mkdns.Msg{},
 	}
-	dns.publishTransaction(trans)
+	dns.publishTransaction(trans, false)
 
 	m := expectResult(t, results)
 	assert.Equal(t, common.ERROR_STATUS, mapValue(t, m, "status"))
@@ -389,7 +390,7 @@ func TestPublishTransaction_emptyDnsResponse(t *testing.T) {
 	trans.response = &dnsMessage{
 		data: &mkdns.Msg{},
 	}
-	dns.publishTransaction(trans)
+	dns.publishTransaction(trans, false)
 
 	m := expectResult(t, results)
 	assert.Equal(t, common.ERROR_STATUS, mapValue(t, m, "status"))
@@ -0,0 +1,96 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dns
+
+import (
+	"net"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+	"github.com/elastic/beats/packetbeat/dnstap"
+)
+
+var dnstapIgnoredMessages = monitoring.NewInt(nil, "dns.dnstap.ignored_messages")
+
+// [Bluecat] Process a message of the dnstap input like a captured packet. The queries received and
+// sent by the name server and their responses make the same transactions and feed the statistics
+// engine the same way as the sniffed ones, the other message types are ignored.
+func (dns *dnsPlugin) ProcessDnstap(message *dnstap.Message) {
+	defer logp.Recover("Dns ProcessDnstap")
+	switch message.Type {
+	case dnstap.CLIENT_QUERY, dnstap.CLIENT_RESPONSE,
+		dnstap.RESOLVER_QUERY, dnstap.RESOLVER_RESPONSE,
+		dnstap.FORWARDER_QUERY, dnstap.FORWARDER_RESPONSE:
+	default:
+		dnstapIgnoredMessages.Inc()
+		return
+	}
+
+	// The query goes from the query address to the response address, the response the other way
+	queryIP, queryLength := dnstapIP(message.QueryAddress)
+	responseIP, responseLength := dnstapIP(message.ResponseAddress)
+	payload, ts := message.QueryMessage, message.QueryTime
+	tuple := common.NewIPPortTuple(queryLength, queryIP, message.QueryPort, responseIP, message.ResponsePort)
+	if !message.Type.IsQuery() {
+		payload, ts = message.ResponseMessage, message.ResponseTime
+		tuple = common.NewIPPortTuple(responseLength, responseIP, message.ResponsePort, queryIP, message.QueryPort)
+	}
+	if queryIP == nil || responseIP == nil || queryLength != responseLength || len(payload) == 0 {
+		dnstapIgnoredMessages.Inc()
+		return
+	}
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	transp := transport(transportTCP)
+	if message.SocketProtocol.IsUDP() {
+		transp = transportUDP
+	}
+
+	// The dnstap messages have no TCP length prefix
+	dnsPkt, err := decodeDNSData(transportUDP, payload)
+	if err != nil {
+		dns.handleErrorMsg(tuple.SrcIP.String(), tuple.DstIP.String(), transportUDP, payload, tuple)
+		debugf("%s", err.Error())
+		return
+	}
+	dnsTuple := dnsTupleFromIPPort(&tuple, transp, dnsPkt.Id)
+	dnsMsg := &dnsMessage{
+		ts:           ts,
+		tuple:        tuple,
+		cmdlineTuple: &common.CmdlineTuple{},
+		data:         dnsPkt,
+		length:       len(payload),
+	}
+	if dnsMsg.data.Response {
+		dns.receivedDNSResponse(&dnsTuple, dnsMsg)
+	} else {
+		dns.receivedDNSRequest(&dnsTuple, dnsMsg)
+	}
+}
+
+// Copy of the address of a dnstap message, which only lives as long as its frame, and its length.
+// The IPv4 addresses are 4 bytes long like the captured ones.
+func dnstapIP(ip net.IP) (net.IP, int) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return append(net.IP(nil), ip4...), net.IPv4len
+	}
+	if len(ip) == net.IPv6len {
+		return append(net.IP(nil), ip...), net.IPv6len
+	}
+	return nil, 0
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/dnstap"
+)
+
+// dnstap message of the elasticA query or response, sent from the source of forward
+func newDnstapMessage(messageType dnstap.MessageType, protocol dnstap.SocketProtocol, queryTime time.Time) *dnstap.Message {
+	return &dnstap.Message{
+		Type:            messageType,
+		SocketProtocol:  protocol,
+		QueryAddress:    forward.SrcIP,
+		QueryPort:       forward.SrcPort,
+		ResponseAddress: forward.DstIP,
+		ResponsePort:    forward.DstPort,
+		QueryTime:       queryTime,
+		ResponseTime:    queryTime.Add(2 * time.Millisecond),
+		QueryMessage:    elasticA.request,
+		ResponseMessage: elasticA.response,
+	}
+}
+
+func TestProcessDnstapTransaction(t *testing.T) {
+	store := &eventStore{}
+	dns := newDNS(store, testing.Verbose())
+	queryTime := time.Now()
+	dns.ProcessDnstap(newDnstapMessage(dnstap.CLIENT_QUERY, dnstap.PROTOCOL_UDP, queryTime))
+	assert.Equal(t, 1, dns.transactions.Size())
+	dns.ProcessDnstap(newDnstapMessage(dnstap.CLIENT_RESPONSE, dnstap.PROTOCOL_UDP, queryTime))
+	assert.Equal(t, 0, dns.transactions.Size())
+
+	if !assert.Len(t, store.events, 1) {
+		return
+	}
+	assert.Equal(t, queryTime, store.events[0].Timestamp)
+	m := expectResult(t, store)
+	assertRequest(t, m, elasticA)
+	assert.Equal(t, "udp", mapValue(t, m, "transport"))
+	assert.Equal(t, len(elasticA.request), mapValue(t, m, "bytes_in"))
+	assert.Equal(t, len(elasticA.response), mapValue(t, m, "bytes_out"))
+	assert.Equal(t, int32(2), mapValue(t, m, "responsetime"))
+}
+
+func TestProcessDnstapTransports(t *testing.T) {
+	store := &eventStore{}
+	dns := newDNS(store, testing.Verbose())
+	queryTime := time.Now()
+	dns.ProcessDnstap(newDnstapMessage(dnstap.RESOLVER_QUERY, dnstap.PROTOCOL_DOT, queryTime))
+	dns.ProcessDnstap(newDnstapMessage(dnstap.RESOLVER_RESPONSE, dnstap.PROTOCOL_DOT, queryTime))
+	assert.Equal(t, "tcp", mapValue(t, expectResult(t, store), "transport"))
+}
+
+func TestProcessDnstapIgnoredMessages(t *testing.T) {
+	store := &eventStore{}
+	dns := newDNS(store, testing.Verbose())
+	ignored := dnstapIgnoredMessages.Get()
+
+	dns.ProcessDnstap(newDnstapMessage(dnstap.AUTH_QUERY, dnstap.PROTOCOL_UDP, time.Now()))
+	noAddress := newDnstapMessage(dnstap.CLIENT_QUERY, dnstap.PROTOCOL_UDP, time.Now())
+	noAddress.QueryAddress = nil
+	dns.ProcessDnstap(noAddress)
+	noMessage := newDnstapMessage(dnstap.CLIENT_RESPONSE, dnstap.PROTOCOL_UDP, time.Now())
+	noMessage.ResponseMessage = nil
+	dns.ProcessDnstap(noMessage)
+
+	assert.Equal(t, ignored+3, dnstapIgnoredMessages.Get())
+	assert.Equal(t, 0, dns.transactions.Size())
+	assert.True(t, store.empty())
+}
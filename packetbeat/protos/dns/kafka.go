@@ -0,0 +1,219 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dns
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+	"github.com/elastic/beats/packetbeat/model"
+	"github.com/elastic/beats/packetbeat/outstats"
+)
+
+var (
+	kafkaPublishedIntervals = monitoring.NewInt(nil, "dns.kafka.published_intervals")
+	kafkaPublishedRecords   = monitoring.NewInt(nil, "dns.kafka.published_records")
+	kafkaDroppedRecords     = monitoring.NewInt(nil, "dns.kafka.dropped_records")
+	kafkaErrors             = monitoring.NewInt(nil, "dns.kafka.errors")
+)
+
+// Kafka producer of the DNS analyzer: it publishes the statistics of each interval (JSON of
+// StatisticsService keyed by host name) to the topic and, if transactions_topic is set,
+// the record of each transaction. It is an exporter of the statistics engine.
+// The messages are sent asynchronously, the failed deliveries are logged and counted in dns.kafka.errors.
+type kafkaProducer struct {
+	hosts             []string
+	topic             string
+	transactionsTopic string
+	key               sarama.Encoder
+	producer          sarama.AsyncProducer
+	// Longest wait for room in the buffer of the producer before an interval is retried
+	timeout time.Duration
+
+	// The records are published by the analyzer until the producer is closed
+	mutex  sync.RWMutex
+	closed bool
+	done   chan struct{}
+	// Closed first by Close, it stops the exports waiting for room in the buffer
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func newKafkaProducer(config *KafkaConfig) (*kafkaProducer, error) {
+	saramaConfig, err := newSaramaConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := sarama.NewAsyncProducer(config.Hosts, saramaConfig)
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		producer.Close()
+		return nil, err
+	}
+	p := &kafkaProducer{
+		hosts:             config.Hosts,
+		topic:             config.Topic,
+		transactionsTopic: config.TransactionsTopic,
+		key:               sarama.StringEncoder(host),
+		producer:          producer,
+		timeout:           config.Timeout,
+		done:              make(chan struct{}),
+		closing:           make(chan struct{}),
+	}
+	go p.logErrors()
+	return p, nil
+}
+
+func newSaramaConfig(config *KafkaConfig) (*sarama.Config, error) {
+	k := sarama.NewConfig()
+	k.Net.DialTimeout = config.Timeout
+	k.Net.ReadTimeout = config.Timeout
+	k.Net.WriteTimeout = config.Timeout
+	k.Net.KeepAlive = config.KeepAlive
+	if config.Username != "" {
+		k.Net.SASL.Enable = true
+		k.Net.SASL.User = config.Username
+		k.Net.SASL.Password = config.Password
+	}
+
+	k.Producer.Timeout = config.BrokerTimeout
+	if config.RequiredACKs != nil {
+		k.Producer.RequiredAcks = sarama.RequiredAcks(*config.RequiredACKs)
+	}
+	compression := strings.ToLower(config.Compression)
+	if compression == "" {
+		compression = "none"
+	}
+	compressionMode, ok := compressionModes[compression]
+	if !ok {
+		return nil, fmt.Errorf("Unknown compression mode: '%v'", config.Compression)
+	}
+	k.Producer.Compression = compressionMode
+	k.Producer.CompressionLevel = config.CompressionLevel
+	if config.MaxMessageBytes > 0 {
+		k.Producer.MaxMessageBytes = config.MaxMessageBytes
+	}
+	k.Producer.Retry.Max = config.MaxRetries
+	if config.RetryBackoffDuration > 0 {
+		k.Producer.Retry.Backoff = config.RetryBackoffDuration
+	}
+	k.Producer.Flush.Frequency = config.FlushFrequency
+	k.Producer.Flush.Bytes = config.FlushMaxBytes
+	k.Producer.Flush.Messages = config.Messages
+	k.Producer.Flush.MaxMessages = config.MaxMessages
+	if k.Producer.Flush.MaxMessages == 0 {
+		k.Producer.Flush.MaxMessages = config.BulkMaxSize
+	}
+	k.Producer.Return.Successes = false
+	k.Producer.Return.Errors = true
+
+	if config.ChanBufferSize > 0 {
+		k.ChannelBufferSize = config.ChanBufferSize
+	}
+	if config.ClientID != "" {
+		k.ClientID = config.ClientID
+	}
+	version, ok := config.Version.Get()
+	if !ok {
+		return nil, fmt.Errorf("Unknown/unsupported kafka version: %v", config.Version)
+	}
+	k.Version = version
+
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (p *kafkaProducer) logErrors() {
+	defer close(p.done)
+	for err := range p.producer.Errors() {
+		kafkaErrors.Inc()
+		logp.Err("Kafka couldn't publish to %v: %v", err.Msg.Topic, err.Err)
+	}
+}
+
+func (p *kafkaProducer) Name() string {
+	return fmt.Sprintf("kafka %v topic %v", strings.Join(p.hosts, ","), p.topic)
+}
+
+// Publish the JSON statistics of an interval. When the brokers are unreachable and the buffer of the producer
+// is full, the interval is retried after timeout, and it is given up once the producer is closing.
+func (p *kafkaProducer) Export(interval outstats.Interval) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		return outstats.PermanentError{Err: fmt.Errorf("kafka producer closed")}
+	}
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case p.producer.Input() <- &sarama.ProducerMessage{
+		Topic:     p.topic,
+		Key:       p.key,
+		Value:     sarama.ByteEncoder(interval.Data),
+		Timestamp: interval.End,
+	}:
+		kafkaPublishedIntervals.Inc()
+		return nil
+	case <-p.closing:
+		return outstats.PermanentError{Err: fmt.Errorf("kafka producer closed")}
+	case <-timer.C:
+		return fmt.Errorf("kafka producer buffer full for %v", p.timeout)
+	}
+}
+
+// Publish the record of a transaction if transactions_topic is set.
+// The analyzer isn't slowed down by Kafka: the record is dropped when the buffer of the producer is full.
+func (p *kafkaProducer) publishRecord(record *model.Record) {
+	if p == nil || p.transactionsTopic == "" {
+		return
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.producer.Input() <- &sarama.ProducerMessage{Topic: p.transactionsTopic, Value: model.NewRecordEncoder(record)}:
+		kafkaPublishedRecords.Inc()
+	default:
+		kafkaDroppedRecords.Inc()
+	}
+}
+
+// Flush the buffered messages and close the producer
+func (p *kafkaProducer) Close() error {
+	p.closeOnce.Do(func() {
+		// The export waiting for room in the buffer releases its lock
+		close(p.closing)
+		p.mutex.Lock()
+		p.closed = true
+		p.mutex.Unlock()
+		p.producer.AsyncClose()
+		<-p.done
+	})
+	return nil
+}
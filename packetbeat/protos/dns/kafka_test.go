@@ -0,0 +1,200 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	mkdns "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/model"
+	"github.com/elastic/beats/packetbeat/outstats"
+)
+
+// Single broker leading the partition 0 of the statistics and transactions topics,
+// it answers the produce requests of Kafka 1.0 (version 3)
+func newTestKafkaBroker(t *testing.T) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("dns-statistics", 0, broker.BrokerID()).
+			SetLeader("dns-transactions", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+	return broker
+}
+
+func produceRequests(broker *sarama.MockBroker) int {
+	requests := 0
+	for _, requestResponse := range broker.History() {
+		if _, ok := requestResponse.Request.(*sarama.ProduceRequest); ok {
+			requests++
+		}
+	}
+	return requests
+}
+
+func TestKafkaProducerPublishes(t *testing.T) {
+	broker := newTestKafkaBroker(t)
+	defer broker.Close()
+
+	config := defaultConfig.Kafka
+	config.Hosts = []string{broker.Addr()}
+	config.Topic = "dns-statistics"
+	config.TransactionsTopic = "dns-transactions"
+	config.Compression = "snappy"
+	producer, err := newKafkaProducer(&config)
+	if !assert.NoError(t, err) {
+		return
+	}
+	intervals, records, errors := kafkaPublishedIntervals.Get(), kafkaPublishedRecords.Get(), kafkaErrors.Get()
+
+	assert.NoError(t, producer.Export(outstats.Interval{End: time.Now(), Data: []byte(`{"stats_map":{}}`)}))
+	producer.publishRecord(&model.Record{Type: "dns", Query: "class IN, type A, example.com."})
+	assert.NoError(t, producer.Close())
+
+	assert.Equal(t, intervals+1, kafkaPublishedIntervals.Get())
+	assert.Equal(t, records+1, kafkaPublishedRecords.Get())
+	assert.Equal(t, errors, kafkaErrors.Get())
+	assert.True(t, produceRequests(broker) > 0)
+
+	// Nothing is published once the producer is closed
+	assert.True(t, outstats.IsPermanentError(producer.Export(outstats.Interval{Data: []byte(`{}`)})))
+	producer.publishRecord(&model.Record{Type: "dns"})
+	assert.Equal(t, records+1, kafkaPublishedRecords.Get())
+}
+
+func TestKafkaProducerWithoutTransactionsTopic(t *testing.T) {
+	broker := newTestKafkaBroker(t)
+	defer broker.Close()
+
+	config := defaultConfig.Kafka
+	config.Hosts = []string{broker.Addr()}
+	config.Topic = "dns-statistics"
+	producer, err := newKafkaProducer(&config)
+	if !assert.NoError(t, err) {
+		return
+	}
+	records := kafkaPublishedRecords.Get()
+	producer.publishRecord(&model.Record{Type: "dns"})
+	assert.NoError(t, producer.Close())
+	assert.Equal(t, records, kafkaPublishedRecords.Get())
+
+	// The analyzers without Kafka have no producer
+	var none *kafkaProducer
+	none.publishRecord(&model.Record{Type: "dns"})
+}
+
+// Producer whose brokers are unreachable: its buffer is always full
+type blockedAsyncProducer struct {
+	sarama.AsyncProducer
+	input  chan *sarama.ProducerMessage
+	errors chan *sarama.ProducerError
+}
+
+func (p *blockedAsyncProducer) AsyncClose()                               { close(p.errors) }
+func (p *blockedAsyncProducer) Close() error                              { p.AsyncClose(); return nil }
+func (p *blockedAsyncProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *blockedAsyncProducer) Successes() <-chan *sarama.ProducerMessage { return nil }
+func (p *blockedAsyncProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+
+func TestKafkaProducerBufferFull(t *testing.T) {
+	producer := &kafkaProducer{
+		topic:    "dns-statistics",
+		producer: &blockedAsyncProducer{input: make(chan *sarama.ProducerMessage), errors: make(chan *sarama.ProducerError)},
+		timeout:  10 * time.Millisecond,
+		done:     make(chan struct{}),
+		closing:  make(chan struct{}),
+	}
+	go producer.logErrors()
+
+	// The interval is retried later rather than blocking the exporter
+	err := producer.Export(outstats.Interval{Data: []byte(`{}`)})
+	assert.Error(t, err)
+	assert.False(t, outstats.IsPermanentError(err))
+
+	// Closing doesn't wait for the pending export
+	producer.timeout = time.Hour
+	exported := make(chan error, 1)
+	go func() {
+		exported <- producer.Export(outstats.Interval{Data: []byte(`{}`)})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() {
+		closed <- producer.Close()
+	}()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+		assert.True(t, outstats.IsPermanentError(<-exported))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked by the pending export")
+	}
+}
+
+// The transactions without response are published like the pipeline events
+func TestKafkaProducerUnansweredTransaction(t *testing.T) {
+	input := make(chan *sarama.ProducerMessage, 1)
+	results := &eventStore{}
+	dns := newDNS(results, testing.Verbose())
+	dns.kafka = &kafkaProducer{
+		transactionsTopic: "dns-transactions",
+		producer:          &blockedAsyncProducer{input: input},
+	}
+
+	trans := newTransaction(time.Now(), dnsTuple{}, common.CmdlineTuple{})
+	trans.request = &dnsMessage{
+		data: &mkdns.Msg{
+			Question: []mkdns.Question{{}},
+		},
+	}
+	dns.expireTransaction(trans)
+
+	assert.Len(t, results.events, 1)
+	if assert.Len(t, input, 1) {
+		message := <-input
+		assert.Equal(t, "dns-transactions", message.Topic)
+		value, err := message.Value.Encode()
+		assert.NoError(t, err)
+		assert.Contains(t, string(value), noResponse.Error())
+	}
+}
+
+func TestKafkaSaramaConfig(t *testing.T) {
+	config := defaultConfig.Kafka
+	saramaConfig, err := newSaramaConfig(&config)
+	if assert.NoError(t, err) {
+		assert.Equal(t, sarama.CompressionGZIP, saramaConfig.Producer.Compression)
+		assert.Equal(t, 30*time.Second, saramaConfig.Net.DialTimeout)
+		assert.Equal(t, sarama.V1_0_0_0, saramaConfig.Version)
+	}
+
+	for _, compression := range []string{"none", "off", "LZ4", "snappy"} {
+		config.Compression = compression
+		_, err = newSaramaConfig(&config)
+		assert.NoError(t, err, compression)
+	}
+	config.Compression = "brotli"
+	_, err = newSaramaConfig(&config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dns
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/monitoring"
+	"github.com/elastic/beats/packetbeat/model"
+	"github.com/elastic/beats/packetbeat/outstats"
+	"github.com/elastic/beats/packetbeat/protos"
+)
+
+const STATISTICS_EVENT_TYPE = "dns_statistics"
+
+var (
+	publishedTransactions = monitoring.NewInt(nil, "dns.pipeline.published_transactions")
+	publishedStatistics   = monitoring.NewInt(nil, "dns.pipeline.published_statistics")
+)
+
+type (
+	// Exporter of the intervals to the libbeat pipeline: one event per client, server, view and zone
+	// of the interval, so that the outputs and processors of packetbeat.yml get the statistics
+	statisticsReporter struct {
+		results protos.Reporter
+	}
+
+	// Interval as encoded by the statistics engine, the metrics are kept as they are
+	pipelineInterval struct {
+		Start    time.Time                `json:"start"`
+		End      time.Time                `json:"end"`
+		StatsMap map[string]pipelineEntry `json:"stats_map"`
+	}
+
+	pipelineEntry struct {
+		Type       string        `json:"type"`
+		DNSMetrics common.MapStr `json:"dnsmetrics"`
+	}
+)
+
+// Publish the event of a transaction with the fields of the upstream DNS events
+func (dns *dnsPlugin) publishEvent(t *dnsTransaction, record *model.Record) {
+	if !dns.publishTransactions || dns.results == nil {
+		return
+	}
+	fields := common.MapStr{
+		"type":      record.Type,
+		"transport": record.Transport,
+		"src":       record.Src,
+		"dst":       record.Dst,
+		"status":    record.Status,
+	}
+	if record.Notes != "" {
+		fields["notes"] = record.Notes
+	}
+	if t.request != nil {
+		fields["bytes_in"] = record.BytesIn
+	}
+	if t.response != nil {
+		fields["bytes_out"] = record.BytesOut
+	}
+	if t.request != nil && t.response != nil {
+		fields["responsetime"] = int32(record.ResponseTime)
+	}
+	if record.Method != "" {
+		fields["method"] = record.Method
+	}
+	if record.Query != "" {
+		fields["query"] = record.Query
+		fields["resource"] = record.Resource
+	}
+	if dns.sendRequest && t.request != nil {
+		fields["request"] = dnsToString(t.request.data)
+	}
+	if dns.sendResponse && t.response != nil {
+		fields["response"] = dnsToString(t.response.data)
+	}
+	if record.DNS != nil {
+		fields["dns"] = dnsToMapStr(record.DNS)
+	}
+	dns.results(beat.Event{Timestamp: t.ts, Fields: fields})
+	publishedTransactions.Inc()
+}
+
+// Return the dns fields of an event, the answers, authorities and additionals are present if there are any
+func dnsToMapStr(record *model.DNS) common.MapStr {
+	fields := common.MapStr{
+		"id":                record.ID,
+		"op_code":           record.OpCode,
+		"response_code":     record.ResponseCode,
+		"answers_count":     record.AnswersCount,
+		"authorities_count": record.AuthoritiesCount,
+		"additionals_count": record.AdditionalsCount,
+	}
+	if flags := record.Flags; flags != nil {
+		fields["flags"] = common.MapStr{
+			"authentic_data":      flags.AuthenticData,
+			"authoritative":       flags.Authoritative,
+			"checking_disabled":   flags.CheckingDisabled,
+			"recursion_available": flags.RecursionAvailable,
+			"recursion_desired":   flags.RecursionDesired,
+			"truncated_response":  flags.TruncatedResponse,
+		}
+	}
+	if record.Question != nil {
+		fields["question"] = structToMapStr(record.Question)
+	}
+	if record.Opt != nil {
+		if opt := structToMapStr(record.Opt); len(opt) > 0 {
+			fields["opt"] = opt
+		}
+	}
+	for name, answers := range map[string][]*model.Answer{
+		"answers":     record.Answers,
+		"authorities": record.Authorities,
+		"additionals": record.Additionals,
+	} {
+		if len(answers) == 0 {
+			continue
+		}
+		values := make([]common.MapStr, 0, len(answers))
+		for _, answer := range answers {
+			values = append(values, structToMapStr(answer))
+		}
+		fields[name] = values
+	}
+	return fields
+}
+
+// Return the non-zero fields of a model struct, named as in its JSON
+func structToMapStr(v interface{}) common.MapStr {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	fields := common.MapStr{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
+			continue
+		}
+		name := strings.TrimSpace(strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0])
+		fields[name] = field.Interface()
+	}
+	return fields
+}
+
+func (reporter *statisticsReporter) Name() string {
+	return "libbeat pipeline"
+}
+
+// Publish an event per entry of the interval, timestamped with the end of the interval
+func (reporter *statisticsReporter) Export(interval outstats.Interval) error {
+	stats := &pipelineInterval{}
+	if err := json.Unmarshal(interval.Data, stats); err != nil {
+		return outstats.PermanentError{Err: err}
+	}
+	for key, entry := range stats.StatsMap {
+		reporter.results(beat.Event{
+			Timestamp: stats.End,
+			Fields: common.MapStr{
+				"type": STATISTICS_EVENT_TYPE,
+				STATISTICS_EVENT_TYPE: common.MapStr{
+					"entry_type":                entry.Type,
+					outstats.KeyTag(entry.Type): key,
+					"interval":                  common.MapStr{"start": stats.Start, "end": stats.End},
+					"dnsmetrics":                entry.DNSMetrics,
+				},
+			},
+		})
+		publishedStatistics.Inc()
+	}
+	return nil
+}
+
+func (reporter *statisticsReporter) Close() error {
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package dns
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/outstats"
+)
+
+func TestStatisticsReporterPublishesEntries(t *testing.T) {
+	store := &eventStore{}
+	reporter := &statisticsReporter{results: store.publish}
+	data := []byte(`{"start":"2020-01-01T00:00:00Z","end":"2020-01-01T00:01:00Z","stats_map":{
+"10.0.0.1":{"type":"perClient","dnsmetrics":{"total_queries":3,"average_time":1.5}},
+"192.168.0.1":{"type":"perServer","dnsmetrics":{"total_queries":2}},
+"internal":{"type":"perView","dnsmetrics":{"total_queries":5}}}}`)
+	assert.NoError(t, reporter.Export(outstats.Interval{Data: data}))
+
+	end := time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC)
+	keys := []string{}
+	for _, event := range store.events {
+		assert.Equal(t, end, event.Timestamp)
+		assert.Equal(t, STATISTICS_EVENT_TYPE, mapValue(t, event.Fields, "type"))
+		assert.Equal(t, end, mapValue(t, event.Fields, "dns_statistics.interval.end"))
+		statistics := event.Fields[STATISTICS_EVENT_TYPE].(common.MapStr)
+		switch statistics["entry_type"] {
+		case "perClient":
+			assert.Equal(t, "10.0.0.1", statistics["client"])
+			assert.Equal(t, 1.5, mapValue(t, event.Fields, "dns_statistics.dnsmetrics.average_time"))
+			keys = append(keys, "client")
+		case "perServer":
+			assert.Equal(t, "192.168.0.1", statistics["server"])
+			keys = append(keys, "server")
+		case "perView":
+			assert.Equal(t, "internal", statistics["view"])
+			assert.Equal(t, float64(5), mapValue(t, event.Fields, "dns_statistics.dnsmetrics.total_queries"))
+			keys = append(keys, "view")
+		}
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"client", "server", "view"}, keys)
+
+	assert.True(t, outstats.IsPermanentError(reporter.Export(outstats.Interval{Data: []byte("{")})))
+}
+
+func TestPublishTransactionDisabled(t *testing.T) {
+	store := &eventStore{}
+	dns := newDNS(store, testing.Verbose())
+	dns.publishTransactions = false
+	dns.ParseUDP(newPacket(forward, elasticA.request))
+	dns.ParseUDP(newPacket(reverse, elasticA.response))
+	assert.True(t, store.empty())
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querylog
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Major types of CBOR (RFC 7049), only the ones of the query log entries are encoded
+const (
+	CBOR_UNSIGNED = 0
+	CBOR_NEGATIVE = 1
+	CBOR_TEXT     = 3
+	CBOR_MAP      = 5
+
+	CBOR_FALSE   = 0xf4
+	CBOR_TRUE    = 0xf5
+	CBOR_NULL    = 0xf6
+	CBOR_FLOAT64 = 0xfb
+)
+
+// Append the head of a data item: its major type and its argument, in the shortest form
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, major|25, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(n))
+		return b
+	case n <= math.MaxUint32:
+		b = append(b, major|26, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], uint32(n))
+		return b
+	default:
+		b = append(b, major|27, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(b[len(b)-8:], n)
+		return b
+	}
+}
+
+func appendCBORInt(b []byte, v int64) []byte {
+	if v < 0 {
+		return appendCBORHead(b, CBOR_NEGATIVE, uint64(-1-v))
+	}
+	return appendCBORHead(b, CBOR_UNSIGNED, uint64(v))
+}
+
+func appendCBORString(b []byte, s string) []byte {
+	b = appendCBORHead(b, CBOR_TEXT, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendCBORValue(b []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return appendCBORString(b, v)
+	case int:
+		return appendCBORInt(b, int64(v))
+	case int64:
+		return appendCBORInt(b, v)
+	case uint16:
+		return appendCBORHead(b, CBOR_UNSIGNED, uint64(v))
+	case float64:
+		b = append(b, CBOR_FLOAT64, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(b[len(b)-8:], math.Float64bits(v))
+		return b
+	case bool:
+		if v {
+			return append(b, CBOR_TRUE)
+		}
+		return append(b, CBOR_FALSE)
+	default:
+		return append(b, CBOR_NULL)
+	}
+}
+
+// Append an entry as a map of definite length, the files are CBOR sequences (RFC 8742)
+func appendCBOREntry(b []byte, fields []field) []byte {
+	b = appendCBORHead(b, CBOR_MAP, uint64(len(fields)))
+	for _, f := range fields {
+		b = appendCBORString(b, f.name)
+		b = appendCBORValue(b, f.value)
+	}
+	return b
+}
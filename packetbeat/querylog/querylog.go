@@ -0,0 +1,344 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querylog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+	"github.com/elastic/beats/packetbeat/model"
+)
+
+const (
+	FORMAT_NDJSON = "ndjson"
+	FORMAT_CBOR   = "cbor"
+
+	DEFAULT_MAX_SIZE    = 100 * 1024 * 1024
+	DEFAULT_MAX_BACKUPS = 10
+	DEFAULT_QUEUE_SIZE  = 10000
+	FLUSH_PERIOD        = time.Second
+)
+
+var (
+	writtenEntries  = monitoring.NewInt(nil, "querylog.written")
+	droppedEntries  = monitoring.NewInt(nil, "querylog.dropped")
+	filteredEntries = monitoring.NewInt(nil, "querylog.filtered")
+	writeErrors     = monitoring.NewInt(nil, "querylog.errors")
+)
+
+type (
+	// Audit trail of the DNS transactions: one entry per transaction, in NDJSON or as a CBOR sequence.
+	// The records are queued by Log and written by a single goroutine, so that a slow disk never
+	// slows down the statistics: the records are dropped and counted in querylog.dropped when the queue is full.
+	QueryLog struct {
+		format string
+		views  map[string]bool
+		ipNets []*net.IPNet
+		ips    []string
+		viewOf func(record *model.Record) string
+		file   *rotatingFile
+
+		records   chan *model.Record
+		buffer    []byte
+		failing   bool
+		done      chan struct{}
+		stopped   chan struct{}
+		closeOnce sync.Once
+	}
+
+	Config struct {
+		Path string
+		// ndjson or cbor, ndjson if empty
+		Format string
+		// Rotation: maximum size in bytes, number of rotated files kept, maximum age of the file (0: by size only)
+		MaxSize        int64
+		MaxBackups     int
+		RotateInterval time.Duration
+		// Gzip the rotated files
+		Compress bool
+		// Only the transactions of these views and of these clients (IPs or CIDRs) are logged, all if empty
+		Views   []string
+		Clients []string
+		// Number of records waiting to be written
+		QueueSize int
+		// View of the query of a transaction, no view is logged if nil
+		ViewOf func(record *model.Record) string
+	}
+
+	field struct {
+		name  string
+		value interface{}
+	}
+)
+
+func New(config Config) (*QueryLog, error) {
+	q, err := newQueryLog(config)
+	if err != nil {
+		return nil, err
+	}
+	go q.run()
+	return q, nil
+}
+
+func newQueryLog(config Config) (*QueryLog, error) {
+	format := strings.ToLower(config.Format)
+	if format == "" {
+		format = FORMAT_NDJSON
+	}
+	if format != FORMAT_NDJSON && format != FORMAT_CBOR {
+		return nil, fmt.Errorf("unknown query log format '%v'", config.Format)
+	}
+	q := &QueryLog{
+		format:  format,
+		viewOf:  config.ViewOf,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if len(config.Views) > 0 {
+		q.views = make(map[string]bool, len(config.Views))
+		for _, view := range config.Views {
+			q.views[view] = true
+		}
+	}
+	for _, client := range config.Clients {
+		if strings.Contains(client, "/") {
+			_, ipNet, err := net.ParseCIDR(client)
+			if err != nil {
+				return nil, err
+			}
+			q.ipNets = append(q.ipNets, ipNet)
+		} else if ip := net.ParseIP(client); ip != nil {
+			q.ips = append(q.ips, ip.String())
+		} else {
+			return nil, fmt.Errorf("invalid query log client '%v'", client)
+		}
+	}
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = DEFAULT_QUEUE_SIZE
+	}
+	q.records = make(chan *model.Record, queueSize)
+
+	maxSize, maxBackups := config.MaxSize, config.MaxBackups
+	if maxSize <= 0 {
+		maxSize = DEFAULT_MAX_SIZE
+	}
+	if maxBackups <= 0 {
+		maxBackups = DEFAULT_MAX_BACKUPS
+	}
+	file, err := newRotatingFile(config.Path, maxSize, maxBackups, config.RotateInterval, config.Compress)
+	if err != nil {
+		return nil, err
+	}
+	q.file = file
+	return q, nil
+}
+
+// Queue the record of a transaction, it is dropped if the queue is full or if its client isn't logged
+func (q *QueryLog) Log(record *model.Record) {
+	if q == nil {
+		return
+	}
+	if !q.clientLogged(record) {
+		filteredEntries.Inc()
+		return
+	}
+	select {
+	case <-q.done:
+		return
+	default:
+	}
+	select {
+	case q.records <- record:
+	default:
+		droppedEntries.Inc()
+	}
+}
+
+func (q *QueryLog) clientLogged(record *model.Record) bool {
+	if len(q.ipNets) == 0 && len(q.ips) == 0 {
+		return true
+	}
+	if record.Src == nil {
+		return false
+	}
+	ip := net.ParseIP(record.Src.IP)
+	for _, ipNet := range q.ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	for _, logged := range q.ips {
+		if logged == record.Src.IP {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *QueryLog) run() {
+	defer close(q.stopped)
+	ticker := time.NewTicker(FLUSH_PERIOD)
+	defer ticker.Stop()
+	for {
+		select {
+		case record := <-q.records:
+			q.write(record)
+		case <-ticker.C:
+			q.checkError(q.file.Flush())
+		case <-q.done:
+			// Write the queued records before closing the file
+			for {
+				select {
+				case record := <-q.records:
+					q.write(record)
+				default:
+					q.checkError(q.file.Close())
+					return
+				}
+			}
+		}
+	}
+}
+
+func (q *QueryLog) write(record *model.Record) {
+	view := ""
+	if q.viewOf != nil {
+		view = q.viewOf(record)
+	}
+	if q.views != nil && !q.views[view] {
+		filteredEntries.Inc()
+		return
+	}
+	fields := entryFields(record, view)
+	if q.format == FORMAT_CBOR {
+		q.buffer = appendCBOREntry(q.buffer[:0], fields)
+	} else {
+		q.buffer = appendJSONEntry(q.buffer[:0], fields)
+	}
+	if q.checkError(q.file.Write(q.buffer)) {
+		writtenEntries.Inc()
+	}
+}
+
+// Count and log a write error, only the first error of a series is logged
+func (q *QueryLog) checkError(err error) bool {
+	if err == nil {
+		if q.failing {
+			logp.Info("Query log %v written again", q.file.path)
+			q.failing = false
+		}
+		return true
+	}
+	writeErrors.Inc()
+	if !q.failing {
+		logp.Err("Query log couldn't write %v: %v", q.file.path, err)
+		q.failing = true
+	}
+	return false
+}
+
+// Write the queued records and close the file
+func (q *QueryLog) Close() {
+	if q == nil {
+		return
+	}
+	q.closeOnce.Do(func() {
+		close(q.done)
+		<-q.stopped
+	})
+}
+
+// Fields of the entry of a transaction, the empty ones are left out
+func entryFields(record *model.Record, view string) []field {
+	fields := make([]field, 0, 20)
+	add := func(name string, value interface{}) {
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				return
+			}
+		case int:
+			if v == 0 {
+				return
+			}
+		case float64:
+			if v == 0 {
+				return
+			}
+		}
+		fields = append(fields, field{name, value})
+	}
+	add("ts", record.Timestamp)
+	add("transport", record.Transport)
+	if record.Src != nil {
+		add("client", record.Src.IP)
+		add("client_port", int(record.Src.Port))
+	}
+	if record.Dst != nil {
+		add("server", record.Dst.IP)
+		add("server_port", int(record.Dst.Port))
+	}
+	add("view", view)
+	if dns := record.DNS; dns != nil {
+		fields = append(fields, field{"id", dns.ID})
+		if question := dns.Question; question != nil {
+			add("qname", question.Name)
+			add("qclass", question.Class)
+			add("qtype", question.Type)
+		}
+		add("rcode", dns.ResponseCode)
+		add("answers", dns.AnswersCount)
+	}
+	add("status", record.Status)
+	add("response_time", record.ResponseTime)
+	add("bytes_in", record.BytesIn)
+	add("bytes_out", record.BytesOut)
+	add("notes", record.Notes)
+	return fields
+}
+
+// Append an entry as a JSON object on a line, the fields keep their order
+func appendJSONEntry(b []byte, fields []field) []byte {
+	b = append(b, '{')
+	for i, f := range fields {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendQuote(b, f.name)
+		b = append(b, ':')
+		switch v := f.value.(type) {
+		case string:
+			encoded, _ := json.Marshal(v)
+			b = append(b, encoded...)
+		case int:
+			b = strconv.AppendInt(b, int64(v), 10)
+		case uint16:
+			b = strconv.AppendUint(b, uint64(v), 10)
+		case float64:
+			b = strconv.AppendFloat(b, v, 'f', -1, 64)
+		default:
+			b = append(b, "null"...)
+		}
+	}
+	return append(b, '}', '\n')
+}
@@ -0,0 +1,211 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package querylog
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/model"
+)
+
+func newTestRecord(client string) *model.Record {
+	return &model.Record{
+		Timestamp:      "2020-01-01T00:00:00Z",
+		Transport:      "udp",
+		Status:         "OK",
+		BytesIn:      29,
+		BytesOut:     45,
+		ResponseTime: 1.5,
+		Src:          &common.Endpoint{IP: client, Port: 53000},
+		Dst:          &common.Endpoint{IP:"192.168.0.1", Port: 53},
+		DNS: &model.DNS{
+			ID:           7,
+			ResponseCode:  "NOERROR",
+			AnswersCount: 1,
+			Question:     &model.Question{Name:"example.com.", Class: "IN", Type: "A"},
+		},
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestQueryLogNDJSON(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log", "queries.log")
+
+	q, err := New(Config{
+		Path:    path,
+		Clients: []string{"10.0.0.0/24", "172.16.0.1"},
+		Views:   []string{"internal"},
+		ViewOf: func(record *model.Record) string {
+			if clientIP := record.Src.IP; clientIP == "10.0.0.1" || clientIP == "172.16.0.1" {
+				return "internal"
+			}
+			return "external"
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	filtered := filteredEntries.Get()
+	q.Log(newTestRecord("10.0.0.1"))
+	q.Log(newTestRecord("10.0.0.2"))   // external view
+	q.Log(newTestRecord("10.0.1.1"))   // client not logged
+	q.Log(newTestRecord("172.16.0.1")) // logged client
+	q.Close()
+	q.Log(newTestRecord("10.0.0.1"))
+	assert.Equal(t, filtered+2, filteredEntries.Get())
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ts":"2020-01-01T00:00:00Z","transport":"udp","client":"10.0.0.1","client_port":53000,`+
+		`"server":"192.168.0.1","server_port":53,"view":"internal","id":7,"qname":"example.com.","qclass":"IN",`+
+		`"qtype":"A","rcode":"NOERROR","answers":1,"status":"OK","response_time":1.5,"bytes_in":29,"bytes_out":45}
+{"ts":"2020-01-01T00:00:00Z","transport":"udp","client":"172.16.0.1","client_port":53000,`+
+		`"server":"192.168.0.1","server_port":53,"view":"internal","id":7,"qname":"example.com.","qclass":"IN",`+
+		`"qtype":"A","rcode":"NOERROR","answers":1,"status":"OK","response_time":1.5,"bytes_in":29,"bytes_out":45}
+`, string(content))
+
+	_, err = New(Config{Path: path, Format: "xml"})
+	assert.Error(t, err)
+	_, err = New(Config{Path: path, Clients: []string{"example.com"}})
+	assert.Error(t, err)
+}
+
+func TestQueryLogDropsWhenFull(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// The writer isn't started, the queue stays full
+	q, err := newQueryLog(Config{Path: filepath.Join(dir, "queries.log"), QueueSize: 1})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer q.file.Close()
+	dropped := droppedEntries.Get()
+	q.Log(newTestRecord("10.0.0.1"))
+	q.Log(newTestRecord("10.0.0.1"))
+	assert.Equal(t, dropped+1, droppedEntries.Get())
+}
+
+func TestCBOREncoding(t *testing.T) {
+	// Examples of RFC 7049 appendix A
+	for value, expected := range map[interface{}]string{
+		0:              "00",
+		23:             "17",
+		24:             "1818",
+		1000:           "1903e8",
+		1000000:        "1a000f4240",
+		int64(1) << 40: "1b0000010000000000",
+		-1:             "20",
+		-1000:          "3903e7",
+		1.1:            "fb3ff199999999999a",
+		true:           "f5",
+		"":             "60",
+		"IETF":         "6449455446",
+		uint16(65535):  "19ffff",
+		"a":            "6161",
+	} {
+		assert.Equal(t, expected, hex.EncodeToString(appendCBORValue(nil, value)), "%v", value)
+	}
+	assert.Equal(t, "a2616101616202", hex.EncodeToString(appendCBOREntry(nil, []field{{"a", 1}, {"b", 2}})))
+}
+
+func TestQueryLogCBOR(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queries.cbor")
+
+	q, err := New(Config{Path: path, Format: "CBOR"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	record := newTestRecord("10.0.0.1")
+	q.Log(record)
+	q.Log(record)
+	q.Close()
+
+	entry := appendCBOREntry(nil, entryFields(record, ""))
+	assert.Equal(t, byte(CBOR_MAP<<5|16), entry[0])
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, append(append([]byte{}, entry...), entry...), content)
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queries.log")
+
+	file, err := newRotatingFile(path, 20, 2, 0, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, file.Write([]byte("0123456789abcde\n")))
+		// The rotated files are named after the millisecond of their rotation
+		time.Sleep(2 * time.Millisecond)
+	}
+	assert.NoError(t, file.Close())
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789abcde\n", string(content))
+	backups, _ := filepath.Glob(filepath.Join(dir, "queries-*.log.gz"))
+	others, _ := filepath.Glob(filepath.Join(dir, "queries-*.log"))
+	assert.Len(t, backups, 2)
+	assert.Len(t, others, 0)
+	for _, backup := range backups {
+		compressed, err := os.Open(backup)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		reader, err := gzip.NewReader(compressed)
+		if assert.NoError(t, err) {
+			content, _ := ioutil.ReadAll(reader)
+			assert.Equal(t, "0123456789abcde\n", string(content))
+		}
+		compressed.Close()
+	}
+
+	// Rotation by age
+	file, err = newRotatingFile(path, 0, 10, time.Millisecond, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	time.Sleep(2 * time.Millisecond)
+	assert.NoError(t, file.Flush())
+	assert.NoError(t, file.Close())
+	others, _ = filepath.Glob(filepath.Join(dir, "queries-*.log"))
+	assert.Len(t, others, 1)
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querylog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const (
+	BACKUP_TIME_FORMAT = "20060102T150405.000"
+	GZIP_EXTENSION     = ".gz"
+	WRITE_BUFFER_SIZE  = 64 * 1024
+)
+
+// File rotated by size and by age. The rotated files are renamed <name>-<UTC time><extension>,
+// gzipped in the background if compress is set, and only the last maxBackups are kept.
+// It is written by a single goroutine.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	interval   time.Duration
+	compress   bool
+
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	opened time.Time
+
+	// Compressions of the rotated files, the pruning waits for them
+	backups      sync.WaitGroup
+	pruningMutex sync.Mutex
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int, interval time.Duration, compress bool) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		interval:   interval,
+		compress:   compress,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Open the file for appending, the entries of a previous run are kept
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.writer = bufio.NewWriterSize(file, WRITE_BUFFER_SIZE)
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+func (f *rotatingFile) expired(now time.Time) bool {
+	return f.interval > 0 && now.Sub(f.opened) >= f.interval
+}
+
+// Write an entry, the file is rotated first if the entry would exceed the maximum size
+func (f *rotatingFile) Write(entry []byte) error {
+	if f.file == nil {
+		// The file couldn't be reopened after the last rotation
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.size > 0 && (f.maxSize > 0 && f.size+int64(len(entry)) > f.maxSize || f.expired(time.Now())) {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.writer.Write(entry)
+	f.size += int64(n)
+	return err
+}
+
+// Write the buffered entries to the file, and rotate it if it is too old
+func (f *rotatingFile) Flush() error {
+	if f.file == nil {
+		return nil
+	}
+	if f.size > 0 && f.expired(time.Now()) {
+		return f.rotate()
+	}
+	return f.writer.Flush()
+}
+
+func (f *rotatingFile) rotate() error {
+	err := f.closeFile()
+	ext := filepath.Ext(f.path)
+	backup := strings.TrimSuffix(f.path, ext) + "-" + time.Now().UTC().Format(BACKUP_TIME_FORMAT) + ext
+	if renameErr := os.Rename(f.path, backup); renameErr != nil {
+		if err == nil {
+			err = renameErr
+		}
+	} else if f.compress {
+		f.backups.Add(1)
+		go func() {
+			defer f.backups.Done()
+			if err := compressFile(backup); err != nil {
+				logp.Err("Query log couldn't compress %v: %v", backup, err)
+			}
+			f.prune()
+		}()
+	} else {
+		f.prune()
+	}
+	if openErr := f.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+func (f *rotatingFile) closeFile() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.writer.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file = nil
+	return err
+}
+
+// Remove the oldest rotated files beyond maxBackups, their names sort by rotation time
+func (f *rotatingFile) prune() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	f.pruningMutex.Lock()
+	defer f.pruningMutex.Unlock()
+	ext := filepath.Ext(f.path)
+	pattern := strings.TrimSuffix(f.path, ext) + "-*" + ext
+	backups, _ := filepath.Glob(pattern)
+	compressed, _ := filepath.Glob(pattern + GZIP_EXTENSION)
+	backups = append(backups, compressed...)
+	sort.Strings(backups)
+	for len(backups) > f.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			logp.Err("Query log couldn't remove %v: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// Close the file and wait for the compressions of the rotated files
+func (f *rotatingFile) Close() error {
+	err := f.closeFile()
+	f.backups.Wait()
+	return err
+}
+
+// Replace a file with its gzipped copy
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + GZIP_EXTENSION + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(dst)
+	_, err = io.Copy(writer, src)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+GZIP_EXTENSION)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
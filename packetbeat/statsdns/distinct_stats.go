@@ -0,0 +1,115 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type (
+	// Estimated numbers of distinct clients, queried names and upstream servers of the interval,
+	// for the whole server and per view. They aren't limited by MaximumClients.
+	DistinctCounters struct {
+		clients *HyperLogLog
+		qnames  *HyperLogLog
+		servers *HyperLogLog
+		perView map[string]*distinctViewCounters
+	}
+
+	// The upstream servers can't be related to a view, only the clients and the names are counted
+	distinctViewCounters struct {
+		clients *HyperLogLog
+		qnames  *HyperLogLog
+	}
+
+	distinctSummary struct {
+		Clients int64                          `json:"clients"`
+		QNames  int64                          `json:"qnames"`
+		Servers int64                          `json:"servers"`
+		PerView map[string]distinctViewSummary `json:"per_view"`
+	}
+
+	distinctViewSummary struct {
+		Clients int64 `json:"clients"`
+		QNames  int64 `json:"qnames"`
+	}
+)
+
+func NewDistinctCounters() *DistinctCounters {
+	return &DistinctCounters{
+		clients: NewHyperLogLog(),
+		qnames:  NewHyperLogLog(),
+		servers: NewHyperLogLog(),
+		perView: make(map[string]*distinctViewCounters),
+	}
+}
+
+// Count the client and the name of a client query. viewName is empty if the client isn't in any view.
+func (d *DistinctCounters) AddClientQuery(clientIp string, queryName string, viewName string) {
+	if d == nil {
+		return
+	}
+	queryName = strings.ToLower(queryName)
+	d.clients.Add(clientIp)
+	if queryName != "" {
+		d.qnames.Add(queryName)
+	}
+	if viewName == "" {
+		return
+	}
+	perView, exist := d.perView[viewName]
+	if !exist {
+		perView = &distinctViewCounters{clients: NewHyperLogLog(), qnames: NewHyperLogLog()}
+		d.perView[viewName] = perView
+	}
+	perView.clients.Add(clientIp)
+	if queryName != "" {
+		perView.qnames.Add(queryName)
+	}
+}
+
+// Count the upstream server of a query sent by this DNS server
+func (d *DistinctCounters) AddServer(serverIp string) {
+	if d == nil {
+		return
+	}
+	d.servers.Add(serverIp)
+}
+
+func (d *DistinctCounters) MarshalJSON() ([]byte, error) {
+	summary := distinctSummary{
+		Clients: d.clients.Count(),
+		QNames:  d.qnames.Count(),
+		Servers: d.servers.Count(),
+		PerView: make(map[string]distinctViewSummary, len(d.perView)),
+	}
+	for viewName, perView := range d.perView {
+		summary.PerView[viewName] = distinctViewSummary{Clients: perView.clients.Count(), QNames: perView.qnames.Count()}
+	}
+	return json.Marshal(summary)
+}
+
+// Count the client, the queried name or the upstream server of a query
+func (s *StatisticsService) IncreaseDistinctCounter(srcIp string, dstIp string, queryName string, viewName string) {
+	if s.engine.IsInternalCall(srcIp, dstIp) {
+		return
+	}
+	if s.engine.IsLocalIP(dstIp) {
+		s.Distinct.AddClientQuery(srcIp, queryName, viewName)
+	} else if s.engine.IsLocalIP(srcIp) {
+		s.Distinct.AddServer(dstIp)
+	}
+}
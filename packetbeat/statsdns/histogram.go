@@ -0,0 +1,159 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+var (
+	// Upper bounds (milliseconds) of the latency histogram buckets.
+	// Every histogram has one more bucket for the values above the last bound.
+	LatencyBucketBounds = []float64{0.5, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+	// Upper bounds (bytes) of the request and response size histogram buckets
+	SizeBucketBounds = []float64{64, 128, 256, 512, 1024, 1232, 1500, 4096, 16384}
+)
+
+type (
+	// Fixed-bucket histogram of response times in milliseconds or message sizes in bytes.
+	// Histograms with the same bounds can be merged.
+	Histogram struct {
+		Buckets []int64
+		Count   int64
+		Sum     float64
+		Max     float64
+		bounds  []float64
+	}
+
+	histogramBucket struct {
+		Le    string `json:"le"`
+		Count int64  `json:"count"`
+	}
+
+	histogramSummary struct {
+		Count   int64             `json:"count"`
+		P50     float64           `json:"p50"`
+		P90     float64           `json:"p90"`
+		P99     float64           `json:"p99"`
+		Max     float64           `json:"max"`
+		Buckets []histogramBucket `json:"buckets"`
+	}
+)
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{Buckets: make([]int64, len(bounds)+1), bounds: bounds}
+}
+
+func NewLatencyHistogram() *Histogram {
+	return NewHistogram(LatencyBucketBounds)
+}
+
+func NewSizeHistogram() *Histogram {
+	return NewHistogram(SizeBucketBounds)
+}
+
+// Record a value, a response time in milliseconds or a size in bytes
+func (h *Histogram) Observe(value float64) {
+	if value < 0 {
+		value = 0
+	}
+	index := len(h.bounds)
+	for i, bound := range h.bounds {
+		if value <= bound {
+			index = i
+			break
+		}
+	}
+	h.Buckets[index]++
+	h.Count++
+	h.Sum += value
+	if value > h.Max {
+		h.Max = value
+	}
+}
+
+// Add all the samples of other into h, both must have the same bounds
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil {
+		return
+	}
+	for i := range h.Buckets {
+		h.Buckets[i] += other.Buckets[i]
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+	if other.Max > h.Max {
+		h.Max = other.Max
+	}
+}
+
+func (h *Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Count)
+}
+
+// Estimate the q-quantile (0 < q <= 1) by interpolating linearly inside the bucket holding it.
+// The estimate never exceeds the largest observed value.
+func (h *Histogram) Percentile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := q * float64(h.Count)
+	cumulative := int64(0)
+	for i, count := range h.Buckets {
+		if count == 0 {
+			continue
+		}
+		if float64(cumulative+count) < rank {
+			cumulative += count
+			continue
+		}
+		lower := float64(0)
+		if i > 0 {
+			lower = h.bounds[i-1]
+		}
+		upper := h.Max
+		if i < len(h.bounds) && h.bounds[i] < upper {
+			upper = h.bounds[i]
+		}
+		if upper < lower {
+			return upper
+		}
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(count)
+	}
+	return h.Max
+}
+
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	summary := histogramSummary{
+		Count:   h.Count,
+		P50:     h.Percentile(0.50),
+		P90:     h.Percentile(0.90),
+		P99:     h.Percentile(0.99),
+		Max:     h.Max,
+		Buckets: make([]histogramBucket, 0, len(h.Buckets)),
+	}
+	for i, count := range h.Buckets {
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'f', -1, 64)
+		}
+		summary.Buckets = append(summary.Buckets, histogramBucket{Le: le, Count: count})
+	}
+	return json.Marshal(summary)
+}
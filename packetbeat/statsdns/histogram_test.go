@@ -0,0 +1,94 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogramPercentiles(t *testing.T) {
+	h := NewLatencyHistogram()
+	for i := 0; i < 98; i++ {
+		h.Observe(0.8)
+	}
+	h.Observe(150)
+	h.Observe(4000)
+
+	assert.Equal(t, int64(100), h.Count)
+	assert.Equal(t, float64(4000), h.Max)
+	assert.InDelta(t, (98*0.8+150+4000)/100, h.Mean(), 1e-9)
+
+	p50 := h.Percentile(0.50)
+	assert.True(t, p50 > 0.5 && p50 <= 0.8, "p50=%v", p50)
+	p99 := h.Percentile(0.99)
+	assert.True(t, p99 > 100 && p99 <= 200, "p99=%v", p99)
+	assert.Equal(t, float64(4000), h.Percentile(1))
+}
+
+func TestLatencyHistogramEmpty(t *testing.T) {
+	h := NewLatencyHistogram()
+	assert.Equal(t, float64(0), h.Percentile(0.99))
+	assert.Equal(t, float64(0), h.Mean())
+}
+
+func TestLatencyHistogramMerge(t *testing.T) {
+	a := NewLatencyHistogram()
+	b := NewLatencyHistogram()
+	a.Observe(1)
+	b.Observe(7000)
+	a.Merge(b)
+
+	assert.Equal(t, int64(2), a.Count)
+	assert.Equal(t, float64(7000), a.Max)
+	assert.Equal(t, int64(1), a.Buckets[1])
+	assert.Equal(t, int64(1), a.Buckets[len(LatencyBucketBounds)])
+}
+
+func TestLatencyHistogramJSON(t *testing.T) {
+	h := NewLatencyHistogram()
+	h.Observe(3)
+
+	b, err := json.Marshal(h)
+	assert.NoError(t, err)
+
+	var summary map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &summary))
+	assert.Equal(t, float64(1), summary["count"])
+	assert.Equal(t, float64(3), summary["max"])
+	assert.Len(t, summary["buckets"], len(LatencyBucketBounds)+1)
+}
+
+func TestSizeHistogram(t *testing.T) {
+	h := NewSizeHistogram()
+	h.Observe(40)
+	h.Observe(1232)
+	h.Observe(3000)
+
+	assert.Equal(t, int64(1), h.Buckets[0])
+	assert.Equal(t, int64(1), h.Buckets[5])
+	assert.Equal(t, int64(1), h.Buckets[7])
+	assert.Equal(t, float64(3000), h.Percentile(1))
+
+	b, err := json.Marshal(h)
+	assert.NoError(t, err)
+	var summary map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &summary))
+	assert.Len(t, summary["buckets"], len(SizeBucketBounds)+1)
+}
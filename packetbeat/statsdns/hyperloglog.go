@@ -0,0 +1,91 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Number of index bits of the HyperLogLog registers: 2^12 registers (4 KB), standard error ~1.6%
+const HLL_PRECISION = 12
+
+type (
+	// HyperLogLog estimator of the number of distinct values (Flajolet et al.)
+	// with the linear counting correction for the small cardinalities.
+	HyperLogLog struct {
+		registers []uint8
+	}
+)
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, 1<<HLL_PRECISION)}
+}
+
+// 64 bits hash of a value, FNV-1a followed by the splitmix64 finalizer to spread the bits
+func hllHash(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (h *HyperLogLog) Add(value string) {
+	x := hllHash(value)
+	index := x >> (64 - HLL_PRECISION)
+	// Position of the first 1 bit of the remaining bits
+	rank := uint8(1)
+	for w := x << HLL_PRECISION; rank <= 64-HLL_PRECISION && w&(1<<63) == 0; w <<= 1 {
+		rank++
+	}
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// Add all the values of other into h
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+}
+
+// Estimate the number of distinct values added, 0 for a nil estimator
+func (h *HyperLogLog) Count() int64 {
+	if h == nil {
+		return 0
+	}
+	m := float64(len(h.registers))
+	sum := float64(0)
+	zeros := 0
+	for _, rank := range h.registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
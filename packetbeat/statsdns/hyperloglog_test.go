@@ -0,0 +1,72 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLogSmallCardinality(t *testing.T) {
+	h := NewHyperLogLog()
+	assert.Equal(t, int64(0), h.Count())
+	for i := 0; i < 3; i++ {
+		h.Add("10.0.0.1")
+		h.Add("10.0.0.2")
+	}
+	assert.Equal(t, int64(2), h.Count())
+}
+
+func TestHyperLogLogLargeCardinality(t *testing.T) {
+	h := NewHyperLogLog()
+	for i := 0; i < 100000; i++ {
+		h.Add(fmt.Sprintf("host-%d.example.com.", i))
+	}
+	assert.InEpsilon(t, 100000, h.Count(), 0.05)
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a := NewHyperLogLog()
+	b := NewHyperLogLog()
+	for i := 0; i < 1000; i++ {
+		a.Add(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		b.Add(fmt.Sprintf("10.0.%d.%d", (i+500)/256, (i+500)%256))
+	}
+	a.Merge(b)
+	assert.InEpsilon(t, 1500, a.Count(), 0.05)
+}
+
+func TestDistinctCountersJSON(t *testing.T) {
+	d := NewDistinctCounters()
+	d.AddClientQuery("10.0.0.1", "www.example.com.", "internal")
+	d.AddClientQuery("10.0.0.2", "WWW.example.com.", "")
+	d.AddServer("192.0.2.1")
+
+	summary := distinctSummary{}
+	b, err := d.MarshalJSON()
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(b, &summary))
+	assert.Equal(t, distinctSummary{
+		Clients: 2,
+		QNames:  1,
+		Servers: 1,
+		PerView: map[string]distinctViewSummary{"internal": {Clients: 1, QNames: 1}},
+	}, summary)
+}
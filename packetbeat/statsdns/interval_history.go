@@ -0,0 +1,73 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"sync"
+)
+
+// Ring of the last completed intervals. The stored statistics aren't changed anymore,
+// they can be read without lock once they are returned.
+type IntervalHistory struct {
+	mutex     sync.RWMutex
+	intervals []*StatisticsService
+	next      int
+	count     int
+}
+
+func NewIntervalHistory(size int) *IntervalHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &IntervalHistory{intervals: make([]*StatisticsService, size)}
+}
+
+// Store a completed interval, the oldest one is dropped when the ring is full
+func (h *IntervalHistory) Add(stats *StatisticsService) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.intervals[h.next] = stats
+	h.next = (h.next + 1) % len(h.intervals)
+	if h.count < len(h.intervals) {
+		h.count++
+	}
+}
+
+// Return the interval completed index intervals ago, 0 is the last one. nil if it isn't kept.
+func (h *IntervalHistory) Get(index int) *StatisticsService {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if index < 0 || index >= h.count {
+		return nil
+	}
+	return h.intervals[(h.next-1-index+2*len(h.intervals))%len(h.intervals)]
+}
+
+// Return the n last completed intervals, the last one first. n < 0 returns all the kept intervals.
+func (h *IntervalHistory) Last(n int) []*StatisticsService {
+	h.mutex.RLock()
+	count := h.count
+	h.mutex.RUnlock()
+	if n < 0 || n > count {
+		n = count
+	}
+	result := make([]*StatisticsService, 0, n)
+	for i := 0; i < n; i++ {
+		if stats := h.Get(i); stats != nil {
+			result = append(result, stats)
+		}
+	}
+	return result
+}
@@ -0,0 +1,390 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+const (
+	URL_METRICS              = "/metrics"
+	METRICS_PREFIX           = "bcn_dns_"
+	CONTENT_TYPE_PROMETHEUS  = "text/plain; version=0.0.4; charset=utf-8"
+	CONTENT_TYPE_OPENMETRICS = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+	INTERVAL_CURRENT = "current"
+	INTERVAL_LAST    = "last"
+
+	METRIC_GAUGE     = "gauge"
+	METRIC_COUNTER   = "counter"
+	METRIC_HISTOGRAM = "histogram"
+)
+
+var (
+	// Unit suffix of the DNSMetrics fields which have one
+	metricUnits = map[string]string{
+		"average_time":  "_milliseconds",
+		"latency":       "_milliseconds",
+		"request_size":  "_bytes",
+		"response_size": "_bytes",
+	}
+	// DNSMetrics fields exported to Prometheus, in the order of the struct
+	dnsMetricsFields  = collectMetricsFields()
+	invalidMetricName = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	processStartTime  = time.Now()
+)
+
+type (
+	// A DNSMetrics field exported as a metric family
+	metricsField struct {
+		jsonName string
+		name     string
+		index    int
+	}
+
+	// A StatsMap entry of an interval with the labels of its series
+	metricsEntry struct {
+		labels  string
+		metrics *DNSMetrics
+	}
+
+	// Prometheus text or OpenMetrics exposition
+	metricsWriter struct {
+		buf         bytes.Buffer
+		openMetrics bool
+	}
+)
+
+func collectMetricsFields() []metricsField {
+	fields := make([]metricsField, 0)
+	metricsType := reflect.TypeOf(DNSMetrics{})
+	for i := 0; i < metricsType.NumField(); i++ {
+		name := strings.Split(metricsType.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, metricsField{jsonName: name, name: METRICS_PREFIX + name + metricUnits[name], index: i})
+	}
+	return fields
+}
+
+// Serve the current and the last completed intervals in the Prometheus text format,
+// or in the OpenMetrics format if the scraper accepts it
+func (e *StatisticsEngine) reqMetrics(w http.ResponseWriter, req *http.Request) {
+	writer := &metricsWriter{openMetrics: strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")}
+	intervals := map[string]*StatisticsService{INTERVAL_CURRENT: e.CurrentInterval()}
+	if last := e.LastInterval(); last != nil {
+		intervals[INTERVAL_LAST] = last
+	}
+	e.writeMetrics(writer, intervals)
+	if writer.openMetrics {
+		w.Header().Set("Content-Type", CONTENT_TYPE_OPENMETRICS)
+	} else {
+		w.Header().Set("Content-Type", CONTENT_TYPE_PROMETHEUS)
+	}
+	if _, err := w.Write(writer.buf.Bytes()); err != nil {
+		logp.Debug("reqMetrics", "%v", err)
+	}
+}
+
+func (e *StatisticsEngine) writeMetrics(writer *metricsWriter, intervals map[string]*StatisticsService) {
+	intervalNames := make([]string, 0, len(intervals))
+	for intervalName := range intervals {
+		intervalNames = append(intervalNames, intervalName)
+	}
+	sort.Strings(intervalNames)
+	entries := make(map[string][]metricsEntry, len(intervals))
+	for _, intervalName := range intervalNames {
+		entries[intervalName] = e.metricsEntries(intervalName, intervals[intervalName])
+	}
+
+	writer.family(METRICS_PREFIX+"interval_start_seconds", METRIC_GAUGE)
+	for _, intervalName := range intervalNames {
+		writer.sample(METRICS_PREFIX+"interval_start_seconds", labels("interval", intervalName), unixSeconds(intervals[intervalName].Start))
+	}
+	writer.family(METRICS_PREFIX+"interval_end_seconds", METRIC_GAUGE)
+	for _, intervalName := range intervalNames {
+		writer.sample(METRICS_PREFIX+"interval_end_seconds", labels("interval", intervalName), unixSeconds(intervals[intervalName].End))
+	}
+
+	for _, field := range dnsMetricsFields {
+		e.writeField(writer, field, intervalNames, entries)
+	}
+
+	writer.family(METRICS_PREFIX+"folded_clients", METRIC_GAUGE)
+	for _, intervalName := range intervalNames {
+		writer.sample(METRICS_PREFIX+"folded_clients", labels("interval", intervalName), float64(intervals[intervalName].FoldedClients))
+	}
+	writer.family(METRICS_PREFIX+"folded_servers", METRIC_GAUGE)
+	for _, intervalName := range intervalNames {
+		writer.sample(METRICS_PREFIX+"folded_servers", labels("interval", intervalName), float64(intervals[intervalName].FoldedServers))
+	}
+	for _, distinct := range []string{"clients", "qnames", "servers"} {
+		name := METRICS_PREFIX + "distinct_" + distinct
+		writer.family(name, METRIC_GAUGE)
+		for _, intervalName := range intervalNames {
+			writeDistinct(writer, name, distinct, intervalName, intervals[intervalName].Distinct)
+		}
+	}
+
+	e.writeEngineMetrics(writer)
+	writeProcessMetrics(writer)
+	writeBeatMetrics(writer)
+	if writer.openMetrics {
+		writer.buf.WriteString("# EOF\n")
+	}
+}
+
+// Return the entries of an interval sorted by key, with their type, ip, view and zone labels
+func (e *StatisticsEngine) metricsEntries(intervalName string, stats *StatisticsService) []metricsEntry {
+	keys := make([]string, 0, len(stats.StatsMap))
+	for key := range stats.StatsMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]metricsEntry, 0, len(keys))
+	for _, key := range keys {
+		statsDNS := stats.StatsMap[key]
+		ip, viewName, zoneName := "", "", ""
+		switch statsDNS.Type {
+		case CLIENT:
+			ip = key
+			viewName = e.FindClientInView(key)
+		case AUTHSERVER:
+			ip = key
+		case VIEW:
+			viewName = key
+		case ZONE:
+			zoneName = key
+		}
+		entries = append(entries, metricsEntry{
+			labels:  labels("interval", intervalName, "type", statsDNS.Type, "ip", ip, "view", viewName, "zone", zoneName),
+			metrics: statsDNS.DNSMetrics,
+		})
+	}
+	return entries
+}
+
+func (e *StatisticsEngine) writeField(writer *metricsWriter, field metricsField, intervalNames []string, entries map[string][]metricsEntry) {
+	fieldType := reflect.TypeOf(DNSMetrics{}).Field(field.index).Type
+	switch fieldType {
+	case reflect.TypeOf(&Histogram{}):
+		writer.family(field.name, METRIC_HISTOGRAM)
+	default:
+		writer.family(field.name, METRIC_GAUGE)
+	}
+	for _, intervalName := range intervalNames {
+		for _, entry := range entries[intervalName] {
+			value := reflect.ValueOf(entry.metrics).Elem().Field(field.index).Interface()
+			switch value := value.(type) {
+			case int64:
+				writer.sample(field.name, entry.labels, float64(value))
+			case *float64:
+				if value != nil {
+					writer.sample(field.name, entry.labels, *value)
+				}
+			case *Histogram:
+				writer.histogram(field.name, entry.labels, value)
+			case map[string]int64:
+				for _, key := range sortedKeys(value) {
+					writer.sample(field.name, joinLabels(entry.labels, labels("bucket", key)), float64(value[key]))
+				}
+			case map[string]map[string]int64:
+				queryTypes := make([]string, 0, len(value))
+				for queryType := range value {
+					queryTypes = append(queryTypes, queryType)
+				}
+				sort.Strings(queryTypes)
+				for _, queryType := range queryTypes {
+					for _, outcome := range sortedKeys(value[queryType]) {
+						writer.sample(field.name, joinLabels(entry.labels, labels("qtype", queryType, "outcome", outcome)), float64(value[queryType][outcome]))
+					}
+				}
+			}
+		}
+	}
+}
+
+func writeDistinct(writer *metricsWriter, name string, distinct string, intervalName string, counters *DistinctCounters) {
+	if counters == nil {
+		return
+	}
+	count := map[string]*HyperLogLog{"clients": counters.clients, "qnames": counters.qnames, "servers": counters.servers}
+	writer.sample(name, labels("interval", intervalName, "view", ""), float64(count[distinct].Count()))
+	if distinct == "servers" {
+		return
+	}
+	viewNames := make([]string, 0, len(counters.perView))
+	for viewName := range counters.perView {
+		viewNames = append(viewNames, viewName)
+	}
+	sort.Strings(viewNames)
+	for _, viewName := range viewNames {
+		perView := counters.perView[viewName]
+		value := perView.clients
+		if distinct == "qnames" {
+			value = perView.qnames
+		}
+		writer.sample(name, labels("interval", intervalName, "view", viewName), float64(value.Count()))
+	}
+}
+
+// Counters of the statistics engine
+func (e *StatisticsEngine) writeEngineMetrics(writer *metricsWriter) {
+	writer.family(METRICS_PREFIX+"engine_shards", METRIC_GAUGE)
+	writer.sample(METRICS_PREFIX+"engine_shards", "", float64(len(e.shards)))
+	writer.family(METRICS_PREFIX+"engine_intervals_total", METRIC_COUNTER)
+	writer.sample(METRICS_PREFIX+"engine_intervals_total", "", float64(atomic.LoadInt64(&e.events.intervals)))
+	writer.family(METRICS_PREFIX+"engine_events_total", METRIC_COUNTER)
+	for _, event := range []struct {
+		kind  string
+		count *int64
+	}{
+		{"query", &e.events.queries},
+		{"response", &e.events.records},
+		{"recursion", &e.events.recursives},
+		{"timeout", &e.events.timeouts},
+		{"orphan", &e.events.orphans},
+		{"decode_error", &e.events.decodeErrs},
+	} {
+		writer.sample(METRICS_PREFIX+"engine_events_total", labels("kind", event.kind), float64(atomic.LoadInt64(event.count)))
+	}
+}
+
+func writeProcessMetrics(writer *metricsWriter) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	writer.family("process_start_time_seconds", METRIC_GAUGE)
+	writer.sample("process_start_time_seconds", "", unixSeconds(processStartTime))
+	writer.family("process_pid", METRIC_GAUGE)
+	writer.sample("process_pid", "", float64(os.Getpid()))
+	writer.family("go_goroutines", METRIC_GAUGE)
+	writer.sample("go_goroutines", "", float64(runtime.NumGoroutine()))
+	writer.family("go_memstats_alloc_bytes", METRIC_GAUGE)
+	writer.sample("go_memstats_alloc_bytes", "", float64(memStats.Alloc))
+	writer.family("go_memstats_sys_bytes", METRIC_GAUGE)
+	writer.sample("go_memstats_sys_bytes", "", float64(memStats.Sys))
+	writer.family("go_memstats_gc_cycles_total", METRIC_COUNTER)
+	writer.sample("go_memstats_gc_cycles_total", "", float64(memStats.NumGC))
+}
+
+// Counters of the beat registry: libbeat pipeline, outputs and the protocol analyzers
+func writeBeatMetrics(writer *metricsWriter) {
+	snapshot := monitoring.CollectFlatSnapshot(monitoring.Default, monitoring.Full, false)
+	values := make(map[string]float64, len(snapshot.Ints)+len(snapshot.Floats))
+	for name, value := range snapshot.Ints {
+		values[name] = float64(value)
+	}
+	for name, value := range snapshot.Floats {
+		values[name] = value
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metricName := "beat_" + invalidMetricName.ReplaceAllString(name, "_")
+		writer.family(metricName, METRIC_GAUGE)
+		writer.sample(metricName, "", values[name])
+	}
+}
+
+func (writer *metricsWriter) family(name string, metricType string) {
+	// OpenMetrics names the counter family without the _total suffix of its samples
+	if writer.openMetrics && metricType == METRIC_COUNTER {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(&writer.buf, "# TYPE %s %s\n", name, metricType)
+}
+
+func (writer *metricsWriter) sample(name string, labels string, value float64) {
+	writer.buf.WriteString(name)
+	if labels != "" {
+		writer.buf.WriteString("{" + labels + "}")
+	}
+	writer.buf.WriteString(" " + formatMetricValue(value) + "\n")
+}
+
+func (writer *metricsWriter) histogram(name string, entryLabels string, h *Histogram) {
+	if h == nil {
+		return
+	}
+	cumulative := int64(0)
+	for i, count := range h.Buckets {
+		cumulative += count
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'f', -1, 64)
+		}
+		writer.sample(name+"_bucket", joinLabels(entryLabels, labels("le", le)), float64(cumulative))
+	}
+	writer.sample(name+"_sum", entryLabels, h.Sum)
+	writer.sample(name+"_count", entryLabels, float64(h.Count))
+}
+
+// Format label pairs: labels("type", "perClient", "ip", "10.0.0.1") is type="perClient",ip="10.0.0.1"
+func labels(pairs ...string) string {
+	result := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result = append(result, pairs[i]+`="`+escapeLabelValue(pairs[i+1])+`"`)
+	}
+	return strings.Join(result, ",")
+}
+
+func joinLabels(first string, second string) string {
+	if first == "" {
+		return second
+	}
+	return first + "," + second
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+func sortedKeys(values map[string]int64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
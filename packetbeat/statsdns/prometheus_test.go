@@ -0,0 +1,83 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	e := newTestStatisticsEngine(2)
+	defer e.Stop()
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "www.example.com.", false).WithTransport(TRANSPORT_UDP, nil))
+	e.history.Add(e.swapIntervals())
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "www.example.com.", false))
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "www.example.com.", false))
+
+	recorder := httptest.NewRecorder()
+	e.reqMetrics(recorder, httptest.NewRequest(http.MethodGet, URL_METRICS, nil))
+	body := recorder.Body.String()
+
+	assert.Equal(t, CONTENT_TYPE_PROMETHEUS, recorder.Header().Get("Content-Type"))
+	assert.Contains(t, body, "# TYPE bcn_dns_total_queries gauge\n")
+	assert.Contains(t, body, `bcn_dns_total_queries{interval="current",type="perClient",ip="10.0.0.1",view="internal",zone=""} 2`+"\n")
+	assert.Contains(t, body, `bcn_dns_total_queries{interval="last",type="perClient",ip="10.0.0.1",view="internal",zone=""} 1`+"\n")
+	assert.Contains(t, body, `bcn_dns_total_queries{interval="current",type="perView",ip="",view="internal",zone=""} 2`+"\n")
+	assert.Contains(t, body, `bcn_dns_total_queries{interval="current",type="perZone",ip="",view="",zone="example.com."} 2`+"\n")
+	assert.Contains(t, body, `bcn_dns_udp_queries{interval="last",type="perClient",ip="10.0.0.1",view="internal",zone=""} 1`+"\n")
+	assert.Contains(t, body, "# TYPE bcn_dns_latency_milliseconds histogram\n")
+	assert.Contains(t, body, `bcn_dns_latency_milliseconds_bucket{interval="current",type="perClient",ip="10.0.0.1",view="internal",zone="",le="+Inf"} 0`+"\n")
+	assert.Contains(t, body, `bcn_dns_engine_events_total{kind="query"} 3`+"\n")
+	assert.Contains(t, body, "go_goroutines ")
+	assert.NotContains(t, body, "# EOF")
+
+	// Every family is declared once
+	families := map[string]bool{}
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			name := strings.Fields(line)[2]
+			assert.False(t, families[name], name)
+			families[name] = true
+		}
+	}
+}
+
+func TestMetricsOpenMetrics(t *testing.T) {
+	e := newTestStatisticsEngine(1)
+	defer e.Stop()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, URL_METRICS, nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	e.reqMetrics(recorder, req)
+	body := recorder.Body.String()
+
+	assert.Equal(t, CONTENT_TYPE_OPENMETRICS, recorder.Header().Get("Content-Type"))
+	assert.Contains(t, body, "# TYPE bcn_dns_engine_events counter\n")
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+	// No completed interval yet
+	assert.NotContains(t, body, `interval="last"`)
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert.Equal(t, `view="a\"b\\c\nd"`, labels("view", "a\"b\\c\nd"))
+}
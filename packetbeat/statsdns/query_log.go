@@ -0,0 +1,55 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/config_statistics"
+	"github.com/elastic/beats/packetbeat/querylog"
+)
+
+// Create the log of the transactions if it is enabled, the entries carry the view of their client
+func (e *StatisticsEngine) newQueryLog(config config_statistics.QueryLogConfig) {
+	if !config.Enabled {
+		return
+	}
+	path := config.Path
+	if path == "" {
+		path = "querylog/queries.log"
+		if strings.EqualFold(config.Format, querylog.FORMAT_CBOR) {
+			path = "querylog/queries.cbor"
+		}
+	}
+	queryLog, err := querylog.New(querylog.Config{
+		Path:           config_statistics.ResolvePath(path),
+		Format:         config.Format,
+		MaxSize:        int64(config.MaxSize) * 1024 * 1024,
+		MaxBackups:     config.MaxBackups,
+		RotateInterval: time.Duration(config.RotateInterval) * time.Second,
+		Compress:       config.Compress,
+		Views:          config.Views,
+		Clients:        config.Clients,
+		QueueSize:      config.QueueSize,
+		ViewOf:         e.viewOfRecord,
+	})
+	if err != nil {
+		logp.Err("Query log disabled: %v", err)
+		return
+	}
+	e.queryLog = queryLog
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"strings"
+)
+
+const (
+	QTYPE_OTHER = "OTHER"
+
+	// Response outcomes of the query type breakdown, same names as the DNSMetrics counters
+	OUTCOME_SUCCESSFUL  = "successful"
+	OUTCOME_REFERRAL    = "referral"
+	OUTCOME_NXRRSET     = "nx_rrset"
+	OUTCOME_NXDOMAIN    = "nx_domain"
+	OUTCOME_SERVFAIL    = "server_fail"
+	OUTCOME_REFUSED     = "refused"
+	OUTCOME_FORMERR     = "format_error"
+	OUTCOME_OTHER_RCODE = "other_rcode"
+)
+
+var (
+	DefaultQueryTypes = []string{"A", "AAAA", "PTR", "MX", "TXT", "HTTPS", "SVCB", "ANY"}
+	// Types that older miekg/dns releases only know by their number
+	queryTypeNumbers = map[string]string{"64": "SVCB", "65": "HTTPS"}
+)
+
+// Query types which have their own row in the breakdown, the others are counted as OTHER
+func makeQueryTypesAllowed(queryTypes []string) map[string]bool {
+	allowed := make(map[string]bool, len(queryTypes))
+	for _, queryType := range queryTypes {
+		allowed[strings.ToUpper(strings.TrimSpace(queryType))] = true
+	}
+	return allowed
+}
+
+func (e *StatisticsEngine) queryTypeKey(queryType string) string {
+	if name, exist := queryTypeNumbers[queryType]; exist {
+		queryType = name
+	}
+	if e.queryTypesAllowed[queryType] {
+		return queryType
+	}
+	return QTYPE_OTHER
+}
+
+func (s *StatisticsService) increaseQueryTypeOutcome(metrics *DNSMetrics, queryType string, outcome string) {
+	key := s.engine.queryTypeKey(queryType)
+	outcomes, exist := metrics.QueryTypes[key]
+	if !exist {
+		outcomes = make(map[string]int64)
+		metrics.QueryTypes[key] = outcomes
+	}
+	outcomes[outcome]++
+}
+
+func (s *StatisticsService) IncrDNSStatsQueryType(clientIp string, queryType string, outcome string) {
+	if _, exist := s.StatsMap[clientIp]; exist {
+		s.increaseQueryTypeOutcome(s.StatsMap[clientIp].DNSMetrics, queryType, outcome)
+	}
+}
+
+func (s *StatisticsService) IncrDNSStatsQueryTypeForPerView(viewName string, queryType string, outcome string, metricType string) {
+	if metricType == CLIENT {
+		if s.newStats(viewName, VIEW) {
+			s.increaseQueryTypeOutcome(s.StatsMap[viewName].DNSMetrics, queryType, outcome)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+func TestQueryTypeKey(t *testing.T) {
+	config := config_statistics.DefaultConfigStat
+	config.QueryTypes = []string{"a", " AAAA ", "HTTPS"}
+	e := NewStatisticsEngine(config)
+
+	assert.Equal(t, "A", e.queryTypeKey("A"))
+	assert.Equal(t, "AAAA", e.queryTypeKey("AAAA"))
+	assert.Equal(t, "HTTPS", e.queryTypeKey("65"))
+	assert.Equal(t, QTYPE_OTHER, e.queryTypeKey("MX"))
+	assert.Equal(t, QTYPE_OTHER, e.queryTypeKey("64"))
+}
+
+func TestIncreaseQueryTypeOutcome(t *testing.T) {
+	s := NewStatisticsEngine(config_statistics.DefaultConfigStat).newStatisticsService()
+	metrics := &DNSMetrics{QueryTypes: make(map[string]map[string]int64)}
+	s.increaseQueryTypeOutcome(metrics, "AAAA", OUTCOME_NXRRSET)
+	s.increaseQueryTypeOutcome(metrics, "AAAA", OUTCOME_NXRRSET)
+	s.increaseQueryTypeOutcome(metrics, "AAAA", OUTCOME_SUCCESSFUL)
+	s.increaseQueryTypeOutcome(metrics, "NAPTR", OUTCOME_SERVFAIL)
+
+	assert.Equal(t, map[string]map[string]int64{
+		"AAAA":      {OUTCOME_NXRRSET: 2, OUTCOME_SUCCESSFUL: 1},
+		QTYPE_OTHER: {OUTCOME_SERVFAIL: 1},
+	}, metrics.QueryTypes)
+}
@@ -15,8 +15,6 @@
 package statsdns
 
 import (
-	"time"
-
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/packetbeat/model"
 )
@@ -26,31 +24,74 @@ type (
 	QueryDNS struct {
 		srcIP        string
 		dstIP        string
+		queryName    string
 		isDuplicated bool
+		transport    string
+		// EDNS OPT record of the query, nil without EDNS
+		opt *model.Opt
+		// RD bit and TSIG key name of the query, choosing its view with its addresses
+		recursionDesired bool
+		tsigKey          string
+		// View of the query, set when it is counted
+		view string
 	}
 	RecursiveDNS struct {
 		IP        string
 		isSuccess bool
+		// Address, RD bit and TSIG key name of the client query, choosing its view with IP
+		dstIP            string
+		recursionDesired bool
+		tsigKey          string
+	}
+	// Message which couldn't be decoded
+	DecodeErrDNS struct {
+		clientIP   string
+		srvIP      string
+		isResponse bool
+		rcode      string
 	}
 
+	// Shard of the statistics engine: the statistics of its clients are only updated by PopStatDNS.
+	// stats is the double buffer of the interval statistics, stats[active] is the current interval.
 	QueueStatDNS struct {
-		isActive   bool
-		isPopWait  bool
+		engine     *StatisticsEngine
 		queries    chan *QueryDNS
 		recursives chan *RecursiveDNS
 		records    chan *model.Record
+		timeouts   chan *QueryDNS
+		orphans    chan *QueryDNS
+		decodeErrs chan *DecodeErrDNS
+		swap       chan chan *StatisticsService
+		snapshot   chan chan *StatisticsService
+		stats      [2]*StatisticsService
+		active     int
 	}
 )
 
-func NewQueryDNS(srcIP, dstIP string, isDuplicated bool) (queryDNS *QueryDNS) {
+func NewQueryDNS(srcIP, dstIP, queryName string, isDuplicated bool) (queryDNS *QueryDNS) {
 	queryDNS = &QueryDNS{
 		srcIP:        srcIP,
 		dstIP:        dstIP,
+		queryName:    queryName,
 		isDuplicated: isDuplicated,
 	}
 	return
 }
 
+// Set the transport and the EDNS OPT record of the query
+func (queryDNS *QueryDNS) WithTransport(transport string, opt *model.Opt) *QueryDNS {
+	queryDNS.transport = transport
+	queryDNS.opt = opt
+	return queryDNS
+}
+
+// Set the attributes of the query the name server chooses its view with
+func (queryDNS *QueryDNS) WithViewSelection(recursionDesired bool, tsigKey string) *QueryDNS {
+	queryDNS.recursionDesired = recursionDesired
+	queryDNS.tsigKey = tsigKey
+	return queryDNS
+}
+
 func NewRecursiveDNS(IP string, isSuccess bool) (recursiveDNS *RecursiveDNS) {
 	recursiveDNS = &RecursiveDNS{
 		IP:        IP,
@@ -59,78 +100,172 @@ func NewRecursiveDNS(IP string, isSuccess bool) (recursiveDNS *RecursiveDNS) {
 	return
 }
 
-func NewQueueStatDNS() (queue *QueueStatDNS) {
+// Set the attributes of the client query the name server chooses its view with
+func (recursiveDNS *RecursiveDNS) WithViewSelection(dstIP string, recursionDesired bool, tsigKey string) *RecursiveDNS {
+	recursiveDNS.dstIP = dstIP
+	recursiveDNS.recursionDesired = recursionDesired
+	recursiveDNS.tsigKey = tsigKey
+	return recursiveDNS
+}
+
+func NewQueueStatDNS(engine *StatisticsEngine) (queue *QueueStatDNS) {
 	queue = &QueueStatDNS{
+		engine:     engine,
 		queries:    make(chan *QueryDNS),
 		recursives: make(chan *RecursiveDNS),
 		records:    make(chan *model.Record),
-		isActive:   false,
-		isPopWait:  true,
+		timeouts:   make(chan *QueryDNS),
+		orphans:    make(chan *QueryDNS),
+		decodeErrs: make(chan *DecodeErrDNS),
+		swap:       make(chan chan *StatisticsService),
+		snapshot:   make(chan chan *StatisticsService),
 	}
+	queue.stats[0] = queue.newInterval()
+	queue.stats[1] = queue.newInterval()
 	return
 }
 
+// Create the statistics of a new interval with the counters of the views
+func (queue *QueueStatDNS) newInterval() *StatisticsService {
+	stats := queue.engine.newStatisticsService()
+	stats.CreateCounterMetricPerView(queue.engine.namedData().mapViewIPs)
+	return stats
+}
+
 func (queue *QueueStatDNS) PushQueryDNS(queryDNS *QueryDNS) {
-	if !queue.isActive {
-		return
+	select {
+	case queue.queries <- queryDNS:
+	case <-queue.engine.done:
 	}
-	queue.queries <- queryDNS
 }
 
 func (queue *QueueStatDNS) PushRecordDNS(record *model.Record) {
-	if !queue.isActive {
-		return
+	select {
+	case queue.records <- record:
+	case <-queue.engine.done:
 	}
-	queue.records <- record
 }
 
 func (queue *QueueStatDNS) PushRecursiveDNS(recursiveDNS *RecursiveDNS) {
-	if !queue.isActive {
-		return
+	select {
+	case queue.recursives <- recursiveDNS:
+	case <-queue.engine.done:
+	}
+}
+
+// Query which has expired without any response
+func (queue *QueueStatDNS) PushTimeoutDNS(queryDNS *QueryDNS) {
+	select {
+	case queue.timeouts <- queryDNS:
+	case <-queue.engine.done:
+	}
+}
+
+// Response which doesn't match any query
+func (queue *QueueStatDNS) PushOrphanedDNS(queryDNS *QueryDNS) {
+	select {
+	case queue.orphans <- queryDNS:
+	case <-queue.engine.done:
+	}
+}
+
+func (queue *QueueStatDNS) PushDecodeErrDNS(decodeErr *DecodeErrDNS) {
+	select {
+	case queue.decodeErrs <- decodeErr:
+	case <-queue.engine.done:
+	}
+}
+
+// Start a new interval and return the statistics of the finished one.
+// The finished statistics aren't changed until the next swap.
+func (queue *QueueStatDNS) swapInterval() *StatisticsService {
+	reply := make(chan *StatisticsService, 1)
+	select {
+	case queue.swap <- reply:
+		return <-reply
+	case <-queue.engine.done:
+		return queue.engine.newStatisticsService()
+	}
+}
+
+// Return a copy of the statistics of the current interval
+func (queue *QueueStatDNS) snapshotInterval() *StatisticsService {
+	reply := make(chan *StatisticsService, 1)
+	select {
+	case queue.snapshot <- reply:
+		return <-reply
+	case <-queue.engine.done:
+		return queue.engine.newStatisticsService()
 	}
-	queue.recursives <- recursiveDNS
 }
 
 func (queue *QueueStatDNS) PopStatDNS() {
-	for queue.isActive {
-		if queue.isPopWait {
-			time.Sleep(100 * time.Microsecond)
-			continue
-		}
+	// The views are known once named.conf is read
+	queue.stats[queue.active] = queue.newInterval()
+	for {
+		stats := queue.stats[queue.active]
 		select {
+		case <-queue.engine.done:
+			logp.Info("QueueStatDNS Stop")
+			return
+		case reply := <-queue.swap:
+			queue.active = 1 - queue.active
+			queue.stats[queue.active] = queue.newInterval()
+			reply <- stats
+		case reply := <-queue.snapshot:
+			// Merging into new statistics copies them
+			snapshot := queue.engine.newStatisticsService()
+			snapshot.Merge(stats)
+			reply <- snapshot
 		case query := <-queue.queries:
 			if query == nil {
 				continue
 			}
-			IncreaseQueryCounter(query.srcIP, query.dstIP, QUERY)
-			IncreaseQueryCounterForPerView(query.srcIP, query.dstIP, QUERY)
+			query.view = queue.engine.viewOfQuery(query)
+			stats.IncreaseQueryCounter(query.srcIP, query.dstIP, QUERY)
+			stats.IncreaseQueryCounterForPerView(query.view, QUERY)
+			stats.IncreaseQueryCounterForPerZone(query.srcIP, query.dstIP, query.queryName)
+			stats.IncreaseTransportCounter(query)
+			stats.IncreaseDistinctCounter(query.srcIP, query.dstIP, query.queryName, query.view)
 			if query.isDuplicated {
-				IncrDNSStatsDuplicated(query.srcIP)
-				IncrDNSStatsDuplicatedForPerView(query.srcIP)
+				stats.IncrDNSStatsDuplicated(query.srcIP)
+				stats.IncrDNSStatsDuplicatedForPerView(query.view)
 			}
 		case recursive := <-queue.recursives:
 			if recursive == nil {
 				continue
 			}
-			IncrDNSStatsRecursive(recursive.IP)
-			IncrDNSStatsRecursiveForPerView(recursive.IP)
+			stats.IncrDNSStatsRecursive(recursive.IP)
+			viewName := queue.engine.viewOfRecursive(recursive)
+			stats.IncrDNSStatsRecursiveForPerView(viewName)
 			if recursive.isSuccess {
-				IncrDNSStatsSuccessfulRecursive(recursive.IP)
-				IncrDNSStatsSuccessfulRecursiveForPerView(recursive.IP)
+				stats.IncrDNSStatsSuccessfulRecursive(recursive.IP)
+				stats.IncrDNSStatsSuccessfulRecursiveForPerView(viewName)
 			}
 		case record := <-queue.records:
 			if record == nil {
 				continue
 			}
-			ReceivedMessage(record)
+			stats.ReceivedMessage(record)
+		case timeout := <-queue.timeouts:
+			if timeout == nil {
+				continue
+			}
+			stats.IncreaseTimeoutCounter(timeout.srcIP, timeout.dstIP, timeout.queryName, queue.engine.viewOfQuery(timeout))
+		case orphan := <-queue.orphans:
+			if orphan == nil {
+				continue
+			}
+			stats.IncreaseOrphanedCounter(orphan.srcIP, orphan.dstIP)
+		case decodeErr := <-queue.decodeErrs:
+			if decodeErr == nil {
+				continue
+			}
+			if decodeErr.isResponse {
+				stats.HandleResponseDecodeErr(decodeErr.clientIP, decodeErr.srvIP, decodeErr.rcode)
+			} else {
+				stats.HandleRequestDecodeErr(decodeErr.clientIP, decodeErr.srvIP)
+			}
 		}
 	}
 }
-
-func (queue *QueueStatDNS) Stop() {
-	logp.Info("QueueStatDNS Stop")
-	queue.isActive = false
-	close(queue.queries)
-	close(queue.recursives)
-	close(queue.records)
-}
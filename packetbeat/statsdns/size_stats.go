@@ -0,0 +1,47 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"sync/atomic"
+)
+
+// Count the sizes of a query and its response in bytes.
+// The query of a client is received and the response sent, the query to an AS is sent and the response received.
+func increaseSizeCounters(metrics *DNSMetrics, requestSize int, responseSize int, metricType string) {
+	if metricType == AUTHSERVER {
+		atomic.AddInt64(&metrics.BytesSent, int64(requestSize))
+		atomic.AddInt64(&metrics.BytesReceived, int64(responseSize))
+	} else {
+		atomic.AddInt64(&metrics.BytesReceived, int64(requestSize))
+		atomic.AddInt64(&metrics.BytesSent, int64(responseSize))
+	}
+	metrics.RequestSize.Observe(float64(requestSize))
+	metrics.ResponseSize.Observe(float64(responseSize))
+}
+
+func (s *StatisticsService) IncrDNSStatsSizes(clientIp string, requestSize int, responseSize int, metricType string) {
+	if _, exist := s.StatsMap[clientIp]; exist {
+		increaseSizeCounters(s.StatsMap[clientIp].DNSMetrics, requestSize, responseSize, metricType)
+	}
+}
+
+func (s *StatisticsService) IncrDNSStatsSizesForPerView(viewName string, requestSize int, responseSize int, metricType string) {
+	if metricType == CLIENT {
+		if s.newStats(viewName, VIEW) {
+			increaseSizeCounters(s.StatsMap[viewName].DNSMetrics, requestSize, responseSize, metricType)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncreaseSizeCounters(t *testing.T) {
+	client := &DNSMetrics{RequestSize: NewSizeHistogram(), ResponseSize: NewSizeHistogram()}
+	increaseSizeCounters(client, 40, 3000, CLIENT)
+	assert.Equal(t, int64(40), client.BytesReceived)
+	assert.Equal(t, int64(3000), client.BytesSent)
+	assert.Equal(t, float64(3000), client.ResponseSize.Max)
+
+	server := &DNSMetrics{RequestSize: NewSizeHistogram(), ResponseSize: NewSizeHistogram()}
+	increaseSizeCounters(server, 40, 3000, AUTHSERVER)
+	assert.Equal(t, int64(40), server.BytesSent)
+	assert.Equal(t, int64(3000), server.BytesReceived)
+	assert.Equal(t, float64(40), server.RequestSize.Max)
+}
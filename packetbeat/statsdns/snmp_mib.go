@@ -0,0 +1,222 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/agentx"
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+// BCN-DNS-AGENT-MIB
+const (
+	// bcnDnsStatAgent, { bcnContrib 2 }
+	DEFAULT_BCN_DNS_STAT_AGENT_OID = "1.3.6.1.4.1.13315.100.2"
+	// bcnDnsAgentStatistics, { bcnDnsStatAgent 2 }
+	MIB_AGENT_STATISTICS = 2
+	// Groups of bcnDnsAgentStatistics
+	MIB_PER_CLIENT_IP = 1
+	MIB_PER_SERVER_IP = 2
+	MIB_PER_VIEW      = 4
+	// Tables of a group, their entry is 1
+	MIB_STAT_TABLE     = 1
+	MIB_AVG_TIME_TABLE = 2
+	MIB_ENTRY          = 1
+	// Columns of the stat tables: IP or view, query type and value
+	MIB_STAT_KEY_COLUMN   = 1
+	MIB_STAT_QTYPE_COLUMN = 2
+	MIB_STAT_VALUE_COLUMN = 3
+	// Columns of the average time tables: IP or view and average time in microseconds
+	MIB_AVG_TIME_KEY_COLUMN   = 1
+	MIB_AVG_TIME_VALUE_COLUMN = 2
+)
+
+type (
+	// BcnDnsStatAgentQryTypes of a DNSMetrics counter
+	mibCounter struct {
+		qryType int
+		value   func(metrics *DNSMetrics) int64
+	}
+
+	mibRowKey struct {
+		metricType string
+		key        string
+	}
+
+	// Tables of BCN-DNS-AGENT-MIB: the counters are the totals since the start or the last deployment from BAM,
+	// the average times are the ones of the last interval with responses
+	bcnMIB struct {
+		root         agentx.OID
+		mutex        sync.Mutex
+		counters     map[mibRowKey][]uint64
+		averageTimes map[mibRowKey]int32
+	}
+)
+
+var (
+	mibCounters = []mibCounter{
+		{1, func(m *DNSMetrics) int64 { return m.TotalQueries }},
+		{2, func(m *DNSMetrics) int64 { return m.TotalResponses }},
+		{3, func(m *DNSMetrics) int64 { return m.Referral }},
+		{4, func(m *DNSMetrics) int64 { return m.NXRRSet }},
+		{5, func(m *DNSMetrics) int64 { return m.NXDomain }},
+		{6, func(m *DNSMetrics) int64 { return m.Recursive }},
+		{7, func(m *DNSMetrics) int64 { return m.Successful }},
+		{8, func(m *DNSMetrics) int64 { return m.FormatError }},
+		{9, func(m *DNSMetrics) int64 { return m.ServerFail }},
+		{10, func(m *DNSMetrics) int64 { return m.Duplicated }},
+		{11, func(m *DNSMetrics) int64 { return m.Refused }},
+		{12, func(m *DNSMetrics) int64 { return m.OtherRcode }},
+		{13, func(m *DNSMetrics) int64 { return m.SuccessfulRecursive }},
+		{14, func(m *DNSMetrics) int64 { return m.SuccessfulNoAuthAns }},
+		{15, func(m *DNSMetrics) int64 { return m.SuccessfulAuthAns }},
+		{16, func(m *DNSMetrics) int64 { return m.Timeouts }},
+		{17, func(m *DNSMetrics) int64 { return m.OrphanedResponses }},
+	}
+	mibGroups = map[string]uint32{CLIENT: MIB_PER_CLIENT_IP, AUTHSERVER: MIB_PER_SERVER_IP, VIEW: MIB_PER_VIEW}
+)
+
+func newBCNMIB(rootOID string) (*bcnMIB, error) {
+	if rootOID == "" {
+		rootOID = DEFAULT_BCN_DNS_STAT_AGENT_OID
+	}
+	root, err := agentx.ParseOID(rootOID)
+	if err != nil {
+		return nil, err
+	}
+	mib := &bcnMIB{root: root}
+	mib.reset(&namedData{})
+	return mib, nil
+}
+
+// Registered subtree: bcnDnsAgentStatistics
+func (mib *bcnMIB) subtree() agentx.OID {
+	return mib.root.Append(MIB_AGENT_STATISTICS)
+}
+
+// Clear the tables and create the zero rows of the clients and servers in the ACLs and of the views
+func (mib *bcnMIB) reset(named *namedData) {
+	mib.mutex.Lock()
+	defer mib.mutex.Unlock()
+	mib.counters = make(map[mibRowKey][]uint64)
+	mib.averageTimes = make(map[mibRowKey]int32)
+	for _, ip := range named.ipsClient {
+		mib.row(mibRowKey{CLIENT, ip})
+	}
+	for _, ip := range named.ipsServer {
+		mib.row(mibRowKey{AUTHSERVER, ip})
+	}
+	for _, views := range named.mapViewIPs {
+		for view := range views {
+			mib.row(mibRowKey{VIEW, view})
+		}
+	}
+}
+
+// Called with the mutex locked
+func (mib *bcnMIB) row(key mibRowKey) []uint64 {
+	counters, exist := mib.counters[key]
+	if !exist {
+		counters = make([]uint64, len(mibCounters))
+		mib.counters[key] = counters
+		mib.averageTimes[key] = 0
+	}
+	return counters
+}
+
+// Add the counters of a completed interval
+func (mib *bcnMIB) add(stats *StatisticsService) {
+	mib.mutex.Lock()
+	defer mib.mutex.Unlock()
+	for key, statsDNS := range stats.StatsMap {
+		if _, exist := mibGroups[statsDNS.Type]; !exist || key == OTHER_CLIENTS || key == OTHER_SERVERS {
+			continue
+		}
+		rowKey := mibRowKey{statsDNS.Type, key}
+		counters := mib.row(rowKey)
+		for i, counter := range mibCounters {
+			counters[i] += uint64(counter.value(statsDNS.DNSMetrics))
+		}
+		if averageTime := statsDNS.DNSMetrics.AverageTime; averageTime != nil && *averageTime != 0 {
+			mib.averageTimes[rowKey] = int32(*averageTime * float64(time.Millisecond/time.Microsecond))
+		}
+	}
+}
+
+// Return the cells of the tables
+func (mib *bcnMIB) varBinds() []agentx.VarBind {
+	mib.mutex.Lock()
+	defer mib.mutex.Unlock()
+	varBinds := make([]agentx.VarBind, 0, len(mib.counters)*(3*len(mibCounters)+2))
+	statistics := mib.subtree()
+	for rowKey, counters := range mib.counters {
+		group := statistics.Append(mibGroups[rowKey.metricType])
+		stat := group.Append(MIB_STAT_TABLE, MIB_ENTRY)
+		for i, counter := range mibCounters {
+			index := agentx.OID{}.AppendString(rowKey.key).Append(uint32(counter.qryType))
+			varBinds = append(varBinds,
+				agentx.OctetString(stat.Append(MIB_STAT_KEY_COLUMN).Append(index...), rowKey.key),
+				agentx.Integer(stat.Append(MIB_STAT_QTYPE_COLUMN).Append(index...), int32(counter.qryType)),
+				agentx.Counter64(stat.Append(MIB_STAT_VALUE_COLUMN).Append(index...), counters[i]))
+		}
+		avgTime := group.Append(MIB_AVG_TIME_TABLE, MIB_ENTRY)
+		index := agentx.OID{}.AppendString(rowKey.key)
+		varBinds = append(varBinds,
+			agentx.OctetString(avgTime.Append(MIB_AVG_TIME_KEY_COLUMN).Append(index...), rowKey.key),
+			agentx.Integer(avgTime.Append(MIB_AVG_TIME_VALUE_COLUMN).Append(index...), mib.averageTimes[rowKey]))
+	}
+	return varBinds
+}
+
+// Create the sub-agent serving BCN-DNS-AGENT-MIB if it is enabled
+func (e *StatisticsEngine) newSubAgent(config config_statistics.AgentXConfig) {
+	if !config.Enabled {
+		return
+	}
+	mib, err := newBCNMIB(config.RootOID)
+	if err != nil {
+		logp.Err("AgentX sub-agent disabled: %v", err)
+		return
+	}
+	e.mib = mib
+	e.subAgent = agentx.NewSubAgent(agentx.SubAgentConfig{
+		Master:      config.Master,
+		Subtree:     mib.subtree(),
+		Description: "BlueCat DNS traffic statistics",
+		Timeout:     time.Duration(config.Timeout) * time.Second,
+		Reconnect:   time.Duration(config.Reconnect) * time.Second,
+	})
+}
+
+// Update the MIB tables with a completed interval
+func (e *StatisticsEngine) updateMIB(stats *StatisticsService) {
+	if e.mib == nil {
+		return
+	}
+	e.mib.add(stats)
+	e.subAgent.Update(e.mib.varBinds())
+}
+
+// Clear the MIB tables after the deployment of named.conf, like the SNMP sub-agent does
+func (e *StatisticsEngine) resetMIB() {
+	if e.mib == nil {
+		return
+	}
+	e.mib.reset(e.namedData())
+	e.subAgent.Update(e.mib.varBinds())
+}
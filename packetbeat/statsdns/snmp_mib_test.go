@@ -0,0 +1,70 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/agentx"
+)
+
+func TestBCNMIBTables(t *testing.T) {
+	mib, err := newBCNMIB("")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.3.6.1.4.1.13315.100.2.2", mib.subtree().String())
+	mib.reset(&namedData{ipsClient: []string{"10.0.0.9"}, mapViewIPs: map[int]map[string][]string{0: {"internal": {"10.0.0.0/24"}}}})
+
+	averageTime := 1.5
+	stats := &StatisticsService{StatsMap: map[string]*StatisticsDNS{
+		"10.0.0.1":     {Type: CLIENT, DNSMetrics: &DNSMetrics{TotalQueries: 3, OrphanedResponses: 1, AverageTime: &averageTime}},
+		"internal":     {Type: VIEW, DNSMetrics: &DNSMetrics{TotalQueries: 3}},
+		OTHER_CLIENTS:  {Type: CLIENT, DNSMetrics: &DNSMetrics{TotalQueries: 100}},
+		"example.com.": {Type: ZONE, DNSMetrics: &DNSMetrics{TotalQueries: 3}},
+	}}
+	mib.add(stats)
+	// The counters are totals, the average time is kept without response in the interval
+	stats.StatsMap["10.0.0.1"].DNSMetrics.AverageTime = nil
+	mib.add(stats)
+
+	values := make(map[string]interface{})
+	for _, varBind := range mib.varBinds() {
+		values[varBind.Name.String()] = varBind.Value
+	}
+	// Rows of 10.0.0.1, 10.0.0.9 and internal
+	assert.Len(t, values, 3*(3*len(mibCounters)+2))
+
+	client := agentx.OID{}.AppendString("10.0.0.1")
+	perClient := mib.subtree().Append(MIB_PER_CLIENT_IP)
+	assert.Equal(t, "10.0.0.1", values[perClient.Append(MIB_STAT_TABLE, MIB_ENTRY, MIB_STAT_KEY_COLUMN).Append(client...).Append(1).String()])
+	assert.Equal(t, int32(1), values[perClient.Append(MIB_STAT_TABLE, MIB_ENTRY, MIB_STAT_QTYPE_COLUMN).Append(client...).Append(1).String()])
+	assert.Equal(t, uint64(6), values[perClient.Append(MIB_STAT_TABLE, MIB_ENTRY, MIB_STAT_VALUE_COLUMN).Append(client...).Append(1).String()])
+	assert.Equal(t, uint64(2), values[perClient.Append(MIB_STAT_TABLE, MIB_ENTRY, MIB_STAT_VALUE_COLUMN).Append(client...).Append(17).String()])
+	assert.Equal(t, int32(1500), values[perClient.Append(MIB_AVG_TIME_TABLE, MIB_ENTRY, MIB_AVG_TIME_VALUE_COLUMN).Append(client...).String()])
+
+	defaultClient := agentx.OID{}.AppendString("10.0.0.9")
+	assert.Equal(t, uint64(0), values[perClient.Append(MIB_STAT_TABLE, MIB_ENTRY, MIB_STAT_VALUE_COLUMN).Append(defaultClient...).Append(1).String()])
+
+	view := agentx.OID{}.AppendString("internal")
+	perView := mib.subtree().Append(MIB_PER_VIEW)
+	assert.Equal(t, uint64(6), values[perView.Append(MIB_STAT_TABLE, MIB_ENTRY, MIB_STAT_VALUE_COLUMN).Append(view...).Append(1).String()])
+
+	// A deployment clears the tables
+	mib.reset(&namedData{})
+	assert.Empty(t, mib.varBinds())
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"container/heap"
+	"sort"
+)
+
+type (
+	// Space-Saving heavy hitter counter (Metwally et al.).
+	// It keeps at most capacity names, when it is full the least counted name is replaced by the new one,
+	// so the memory stays the same whatever the number of distinct names.
+	SpaceSaving struct {
+		capacity int
+		entries  map[string]*HeavyHitter
+		minHeap  heavyHitterHeap
+	}
+
+	// A counted name. Count may overestimate the real count by at most Error.
+	HeavyHitter struct {
+		Name  string `json:"name"`
+		Count int64  `json:"count"`
+		Error int64  `json:"error"`
+		index int
+	}
+
+	heavyHitterHeap []*HeavyHitter
+)
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &SpaceSaving{
+		capacity: capacity,
+		entries:  make(map[string]*HeavyHitter, capacity),
+		minHeap:  make(heavyHitterHeap, 0, capacity),
+	}
+}
+
+func (s *SpaceSaving) Offer(name string) {
+	if entry, exist := s.entries[name]; exist {
+		entry.Count++
+		heap.Fix(&s.minHeap, entry.index)
+		return
+	}
+	if len(s.minHeap) < s.capacity {
+		entry := &HeavyHitter{Name: name, Count: 1}
+		s.entries[name] = entry
+		heap.Push(&s.minHeap, entry)
+		return
+	}
+	// Replace the least counted name
+	entry := s.minHeap[0]
+	delete(s.entries, entry.Name)
+	entry.Name = name
+	entry.Error = entry.Count
+	entry.Count++
+	s.entries[name] = entry
+	heap.Fix(&s.minHeap, 0)
+}
+
+// Return the n most counted names, the most counted first
+func (s *SpaceSaving) Top(n int) []HeavyHitter {
+	top := make([]HeavyHitter, 0, len(s.minHeap))
+	for _, entry := range s.minHeap {
+		top = append(top, *entry)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Name < top[j].Name
+	})
+	if n >= 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Add the names counted by other into s (Agarwal et al. mergeable summaries).
+// A name missing from a full counter may have been counted up to its least count,
+// which is added to the count and the error of the name.
+func (s *SpaceSaving) Merge(other *SpaceSaving) {
+	if other == nil || len(other.minHeap) == 0 {
+		return
+	}
+	minCount, otherMinCount := s.minCount(), other.minCount()
+	merged := make([]HeavyHitter, 0, len(s.minHeap)+len(other.minHeap))
+	for _, entry := range s.minHeap {
+		result := *entry
+		if otherEntry, exist := other.entries[entry.Name]; exist {
+			result.Count += otherEntry.Count
+			result.Error += otherEntry.Error
+		} else {
+			result.Count += otherMinCount
+			result.Error += otherMinCount
+		}
+		merged = append(merged, result)
+	}
+	for _, otherEntry := range other.minHeap {
+		if _, exist := s.entries[otherEntry.Name]; !exist {
+			result := *otherEntry
+			result.Count += minCount
+			result.Error += minCount
+			merged = append(merged, result)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Count != merged[j].Count {
+			return merged[i].Count > merged[j].Count
+		}
+		return merged[i].Name < merged[j].Name
+	})
+	if len(merged) > s.capacity {
+		merged = merged[:s.capacity]
+	}
+
+	s.entries = make(map[string]*HeavyHitter, s.capacity)
+	s.minHeap = make(heavyHitterHeap, 0, s.capacity)
+	for i := range merged {
+		entry := &merged[i]
+		s.entries[entry.Name] = entry
+		heap.Push(&s.minHeap, entry)
+	}
+}
+
+// Least count of a full counter, 0 while every name is counted exactly
+func (s *SpaceSaving) minCount() int64 {
+	if len(s.minHeap) < s.capacity {
+		return 0
+	}
+	return s.minHeap[0].Count
+}
+
+func (h heavyHitterHeap) Len() int { return len(h) }
+
+func (h heavyHitterHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+
+func (h heavyHitterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *heavyHitterHeap) Push(x interface{}) {
+	entry := x.(*HeavyHitter)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *heavyHitterHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
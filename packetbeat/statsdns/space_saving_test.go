@@ -0,0 +1,93 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpaceSavingKeepsHeavyHitters(t *testing.T) {
+	s := NewSpaceSaving(20)
+	for i := 0; i < 1000; i++ {
+		s.Offer("popular.example.com.")
+		if i%2 == 0 {
+			s.Offer("second.example.com.")
+		}
+		// A long tail of names seen once
+		s.Offer(fmt.Sprintf("random-%d.example.com.", i))
+	}
+
+	assert.Len(t, s.entries, 20)
+	top := s.Top(2)
+	assert.Len(t, top, 2)
+	assert.Equal(t, "popular.example.com.", top[0].Name)
+	assert.Equal(t, "second.example.com.", top[1].Name)
+	assert.True(t, top[0].Count-top[0].Error <= 1000 && top[0].Count >= 1000)
+	assert.True(t, top[1].Count-top[1].Error <= 500 && top[1].Count >= 500)
+}
+
+func TestSpaceSavingExactBelowCapacity(t *testing.T) {
+	s := NewSpaceSaving(10)
+	s.Offer("a.")
+	s.Offer("b.")
+	s.Offer("a.")
+
+	assert.Equal(t, []HeavyHitter{
+		{Name: "a.", Count: 2, index: s.entries["a."].index},
+		{Name: "b.", Count: 1, index: s.entries["b."].index},
+	}, s.Top(10))
+}
+
+func TestTopNames(t *testing.T) {
+	top := NewTopNames(1)
+	top.Offer("WWW.Example.com.", "internal", NOERROR)
+	top.Offer("www.example.com.", "", NOERROR)
+	top.Offer("missing.example.com.", "internal", NXDOMAIN)
+
+	summary := topNamesSummary{}
+	b, err := top.MarshalJSON()
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(b, &summary))
+
+	assert.Equal(t, []HeavyHitter{{Name: "www.example.com.", Count: 2}}, summary.All)
+	assert.Equal(t, []HeavyHitter{{Name: "missing.example.com.", Count: 1}}, summary.NXDomain)
+	assert.Len(t, summary.PerView["internal"], 1)
+}
+
+func TestSpaceSavingMerge(t *testing.T) {
+	first := NewSpaceSaving(2)
+	second := NewSpaceSaving(2)
+	for i := 0; i < 5; i++ {
+		first.Offer("a.")
+		second.Offer("a.")
+	}
+	first.Offer("b.")
+	second.Offer("c.")
+	second.Offer("c.")
+
+	first.Merge(second)
+	top := first.Top(10)
+	assert.Len(t, top, 2)
+	assert.Equal(t, HeavyHitter{Name: "a.", Count: 10, index: top[0].index}, top[0])
+	// A name missing from the other full counter may have been counted as often as its least counted name:
+	// b. and c. may both have been counted 3 times, the first by name is kept
+	assert.Equal(t, HeavyHitter{Name: "b.", Count: 3, Error: 2, index: top[1].index}, top[1])
+}
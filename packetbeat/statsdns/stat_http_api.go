@@ -0,0 +1,303 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// Read-only query API of the statistics HTTP server
+const (
+	URL_API_INTERVALS         = "/api/v1/intervals"
+	URL_API_CURRENT_INTERVAL  = "/api/v1/intervals/current"
+	URL_API_ENTRIES           = "/api/v1/entries"
+	URL_API_ENTRY             = "/api/v1/entries/"
+	DEFAULT_API_ENTRIES_LIMIT = 20
+)
+
+type (
+	// A StatsMap entry of an interval
+	intervalEntry struct {
+		Key        string      `json:"key"`
+		Type       string      `json:"type"`
+		View       string      `json:"view,omitempty"`
+		DNSMetrics *DNSMetrics `json:"dnsmetrics"`
+	}
+
+	entryResponse struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+		intervalEntry
+	}
+
+	entriesResponse struct {
+		Start   time.Time       `json:"start"`
+		End     time.Time       `json:"end"`
+		Sort    string          `json:"sort,omitempty"`
+		Total   int             `json:"total"`
+		Entries []intervalEntry `json:"entries"`
+	}
+
+	apiError struct {
+		Error string `json:"error"`
+	}
+)
+
+func (e *StatisticsEngine) onLoadAPI() {
+	e.mux.HandleFunc(URL_API_INTERVALS, e.reqIntervals)
+	e.mux.HandleFunc(URL_API_CURRENT_INTERVAL, e.reqCurrentInterval)
+	e.mux.HandleFunc(URL_API_ENTRIES, e.reqEntries)
+	e.mux.HandleFunc(URL_API_ENTRY, e.reqEntry)
+}
+
+// GET /api/v1/intervals/current: the interval in progress
+func (e *StatisticsEngine) reqCurrentInterval(w http.ResponseWriter, req *http.Request) {
+	if !checkMethod(w, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, e.CurrentInterval())
+}
+
+// GET /api/v1/intervals?last=N: the N last completed intervals, the last one first
+func (e *StatisticsEngine) reqIntervals(w http.ResponseWriter, req *http.Request) {
+	if !checkMethod(w, req) {
+		return
+	}
+	last := -1
+	if value := req.URL.Query().Get("last"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid last: %s", value)})
+			return
+		}
+		last = number
+	}
+	writeJSON(w, http.StatusOK, e.LastIntervals(last))
+}
+
+// GET /api/v1/entries/<key>?interval=current|last|<index>: the entry of a client, a server, a view or a zone
+func (e *StatisticsEngine) reqEntry(w http.ResponseWriter, req *http.Request) {
+	if !checkMethod(w, req) {
+		return
+	}
+	stats, err := e.selectInterval(req.URL.Query().Get("interval"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	key := strings.TrimPrefix(req.URL.Path, URL_API_ENTRY)
+	if stats == nil || stats.StatsMap[key] == nil {
+		writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("no statistics for %s", key)})
+		return
+	}
+	writeJSON(w, http.StatusOK, entryResponse{Start: stats.Start, End: stats.End, intervalEntry: e.newIntervalEntry(key, stats.StatsMap[key])})
+}
+
+// GET /api/v1/entries: the entries of an interval, filtered and sorted by a counter.
+// Parameters: interval (current, last or the index of a completed interval, 0 is the last one),
+// type (perClient, perServer, perView, perZone), view, ip (address or CIDR),
+// sort (dnsmetrics counter, e.g. server_fail), min (minimum of the sort counter) and limit.
+func (e *StatisticsEngine) reqEntries(w http.ResponseWriter, req *http.Request) {
+	if !checkMethod(w, req) {
+		return
+	}
+	query := req.URL.Query()
+	stats, err := e.selectInterval(query.Get("interval"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	filter, err := newEntriesFilter(query.Get("type"), query.Get("view"), query.Get("ip"), query.Get("sort"), query.Get("min"), query.Get("limit"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	response := entriesResponse{Sort: filter.sort, Entries: make([]intervalEntry, 0)}
+	if stats != nil {
+		response.Start, response.End = stats.Start, stats.End
+		response.Entries = e.filterEntries(stats, filter)
+		response.Total = len(response.Entries)
+		if filter.limit > 0 && len(response.Entries) > filter.limit {
+			response.Entries = response.Entries[:filter.limit]
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Return the interval in progress for "current" or empty, else a completed interval (nil if it isn't kept anymore)
+func (e *StatisticsEngine) selectInterval(interval string) (*StatisticsService, error) {
+	switch interval {
+	case "", INTERVAL_CURRENT:
+		return e.CurrentInterval(), nil
+	case INTERVAL_LAST:
+		return e.LastInterval(), nil
+	}
+	index, err := strconv.Atoi(interval)
+	if err != nil || index < 0 {
+		return nil, fmt.Errorf("invalid interval: %s", interval)
+	}
+	return e.history.Get(index), nil
+}
+
+func (e *StatisticsEngine) newIntervalEntry(key string, stats *StatisticsDNS) intervalEntry {
+	entry := intervalEntry{Key: key, Type: stats.Type, DNSMetrics: stats.DNSMetrics}
+	switch stats.Type {
+	case CLIENT:
+		entry.View = e.FindClientInView(key)
+	case VIEW:
+		entry.View = key
+	}
+	return entry
+}
+
+type entriesFilter struct {
+	metricType string
+	view       string
+	ipNet      *net.IPNet
+	ip         string
+	sort       string
+	sortIndex  int
+	min        float64
+	limit      int
+}
+
+func newEntriesFilter(metricType, view, ip, sortName, min, limit string) (*entriesFilter, error) {
+	filter := &entriesFilter{metricType: metricType, view: view, sort: sortName, sortIndex: -1, limit: DEFAULT_API_ENTRIES_LIMIT}
+	switch metricType {
+	case "", CLIENT, AUTHSERVER, VIEW, ZONE:
+	default:
+		return nil, fmt.Errorf("invalid type: %s", metricType)
+	}
+	if strings.Contains(ip, "/") {
+		_, ipNet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip: %s", ip)
+		}
+		filter.ipNet = ipNet
+	} else {
+		filter.ip = ip
+	}
+	if sortName != "" {
+		for _, field := range dnsMetricsFields {
+			if field.jsonName == sortName && isScalarMetric(field.index) {
+				filter.sortIndex = field.index
+			}
+		}
+		if filter.sortIndex < 0 {
+			return nil, fmt.Errorf("invalid sort: %s", sortName)
+		}
+	}
+	if min != "" {
+		value, err := strconv.ParseFloat(min, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min: %s", min)
+		}
+		filter.min = value
+	}
+	if limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid limit: %s", limit)
+		}
+		// 0 for no limit
+		filter.limit = value
+	}
+	return filter, nil
+}
+
+// Return the entries matching the filter, sorted by the sort counter (the highest first) or by key
+func (e *StatisticsEngine) filterEntries(stats *StatisticsService, filter *entriesFilter) []intervalEntry {
+	entries := make([]intervalEntry, 0)
+	for key, statsDNS := range stats.StatsMap {
+		if filter.metricType != "" && statsDNS.Type != filter.metricType {
+			continue
+		}
+		if filter.ip != "" && key != filter.ip {
+			continue
+		}
+		if filter.ipNet != nil && (statsDNS.Type == VIEW || statsDNS.Type == ZONE || !filter.ipNet.Contains(net.ParseIP(key))) {
+			continue
+		}
+		entry := e.newIntervalEntry(key, statsDNS)
+		if filter.view != "" && entry.View != filter.view {
+			continue
+		}
+		if filter.sortIndex >= 0 && scalarMetric(entry.DNSMetrics, filter.sortIndex) < filter.min {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if filter.sortIndex >= 0 {
+			first, second := scalarMetric(entries[i].DNSMetrics, filter.sortIndex), scalarMetric(entries[j].DNSMetrics, filter.sortIndex)
+			if first != second {
+				return first > second
+			}
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// Check if a DNSMetrics field is a counter or the average time, the fields which can sort the entries
+func isScalarMetric(index int) bool {
+	fieldType := reflect.TypeOf(DNSMetrics{}).Field(index).Type
+	return fieldType.Kind() == reflect.Int64 || fieldType == reflect.TypeOf((*float64)(nil))
+}
+
+func scalarMetric(metrics *DNSMetrics, index int) float64 {
+	switch value := reflect.ValueOf(metrics).Elem().Field(index).Interface().(type) {
+	case int64:
+		return float64(value)
+	case *float64:
+		if value != nil {
+			return *value
+		}
+	}
+	return 0
+}
+
+func checkMethod(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "the statistics API is read-only"})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		logp.Error(err)
+		status = http.StatusInternalServerError
+		b, _ = json.Marshal(apiError{Error: err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		logp.Debug("writeJSON", "%v", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntervalHistoryRing(t *testing.T) {
+	history := NewIntervalHistory(3)
+	assert.Nil(t, history.Get(0))
+	assert.Empty(t, history.Last(-1))
+
+	intervals := make([]*StatisticsService, 5)
+	for i := range intervals {
+		intervals[i] = &StatisticsService{}
+		history.Add(intervals[i])
+	}
+	assert.Equal(t, intervals[4], history.Get(0))
+	assert.Equal(t, intervals[2], history.Get(2))
+	assert.Nil(t, history.Get(3))
+	assert.Equal(t, []*StatisticsService{intervals[4], intervals[3]}, history.Last(2))
+	assert.Equal(t, []*StatisticsService{intervals[4], intervals[3], intervals[2]}, history.Last(-1))
+}
+
+// Entries decoded without the histograms, which are only encoded
+type testAPIEntry struct {
+	Key        string                 `json:"key"`
+	Type       string                 `json:"type"`
+	View       string                 `json:"view"`
+	DNSMetrics map[string]interface{} `json:"dnsmetrics"`
+}
+
+type testAPIEntries struct {
+	Total   int            `json:"total"`
+	Entries []testAPIEntry `json:"entries"`
+}
+
+func serveAPI(e *StatisticsEngine, method string, url string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	e.mux = mux
+	e.onLoadAPI()
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(method, url, nil))
+	return recorder
+}
+
+func TestAPIEntries(t *testing.T) {
+	e := newTestStatisticsEngine(2)
+	defer e.Stop()
+	for _, client := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.2", "10.0.1.3", "10.0.1.3", "10.0.1.3"} {
+		e.PushQueryDNS(NewQueryDNS(client, "192.0.2.53", "www.example.com.", false))
+	}
+	e.history.Add(e.swapIntervals())
+	e.PushQueryDNS(NewQueryDNS("10.0.0.9", "192.0.2.53", "www.example.com.", false))
+
+	response := testAPIEntries{}
+	recorder := serveAPI(e, http.MethodGet, "/api/v1/entries?interval=last&type=perClient&sort=total_queries&limit=2")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, 3, response.Total)
+	assert.Len(t, response.Entries, 2)
+	assert.Equal(t, "10.0.1.3", response.Entries[0].Key)
+	assert.Equal(t, float64(3), response.Entries[0].DNSMetrics["total_queries"])
+	assert.Equal(t, "10.0.0.2", response.Entries[1].Key)
+	assert.Equal(t, "internal", response.Entries[1].View)
+
+	response = testAPIEntries{}
+	recorder = serveAPI(e, http.MethodGet, "/api/v1/entries?interval=0&view=internal&ip=10.0.0.0/24&sort=total_queries&min=2")
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Len(t, response.Entries, 1)
+	assert.Equal(t, "10.0.0.2", response.Entries[0].Key)
+
+	// The interval in progress by default
+	response = testAPIEntries{}
+	recorder = serveAPI(e, http.MethodGet, "/api/v1/entries?type=perClient")
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Len(t, response.Entries, 1)
+	assert.Equal(t, "10.0.0.9", response.Entries[0].Key)
+
+	assert.Equal(t, http.StatusBadRequest, serveAPI(e, http.MethodGet, "/api/v1/entries?sort=latency").Code)
+	assert.Equal(t, http.StatusBadRequest, serveAPI(e, http.MethodGet, "/api/v1/entries?type=perHost").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serveAPI(e, http.MethodPost, "/api/v1/entries").Code)
+}
+
+func TestAPIEntryAndIntervals(t *testing.T) {
+	e := newTestStatisticsEngine(1)
+	defer e.Stop()
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "", false))
+	e.history.Add(e.swapIntervals())
+	e.history.Add(e.swapIntervals())
+
+	entry := testAPIEntry{}
+	recorder := serveAPI(e, http.MethodGet, "/api/v1/entries/10.0.0.1?interval=1")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &entry))
+	assert.Equal(t, CLIENT, entry.Type)
+	assert.Equal(t, float64(1), entry.DNSMetrics["total_queries"])
+	assert.Equal(t, http.StatusNotFound, serveAPI(e, http.MethodGet, "/api/v1/entries/10.0.0.1?interval=last").Code)
+
+	intervals := []json.RawMessage{}
+	recorder = serveAPI(e, http.MethodGet, "/api/v1/intervals?last=5")
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &intervals))
+	assert.Len(t, intervals, 2)
+	assert.Equal(t, http.StatusOK, serveAPI(e, http.MethodGet, "/api/v1/intervals/current").Code)
+	assert.Equal(t, http.StatusBadRequest, serveAPI(e, http.MethodGet, "/api/v1/intervals?last=x").Code)
+}
@@ -15,42 +15,32 @@
 package statsdns
 
 import (
-	"fmt"
-	"os"
-	"time"
 	"context"
-	"syscall"
+	"fmt"
 	"net/http"
-	"os/signal"
+	"time"
 
 	"github.com/elastic/beats/libbeat/logp"
 )
 
-func reqAnnouncementDeployFromBam(w http.ResponseWriter, req *http.Request) {
+func (e *StatisticsEngine) reqAnnouncementDeployFromBam(w http.ResponseWriter, req *http.Request) {
 	logp.Debug("HTTP server", "Receive AnnouncementDeployFromBam request")
-	ReloadNamedData(true)
+	e.ReloadNamedData()
 }
 
-func onLoadHTTPServer() {
-	uriAnnouncementFromBam := fmt.Sprintf("/%v", UrlAnnouncementDeployFromBam)
+func (e *StatisticsEngine) onLoadHTTPServer() {
+	uriAnnouncementFromBam := fmt.Sprintf("/%v", e.config.UrlAnnouncementDeployFromBam)
 	logp.Debug("onLoadHTTPServer", "Start Statistic HTTP server")
 	// Receive request when postDeploy send request AnnouncementDeployFromBam
-	http.HandleFunc(uriAnnouncementFromBam, reqAnnouncementDeployFromBam)
-	s := &http.Server{Addr: StatHTTPServerAddr, Handler: nil}
-	go start(s)
-	stopCh, closeChFunc := createChannel()
-	defer closeChFunc()
-	<-stopCh
-	shutdown(context.Background(), s)
-}
-
-func createChannel() (chan os.Signal, func()) {
-	stopCh := make(chan os.Signal, 1)
-	signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
-
-	return stopCh, func() {
-		close(stopCh)
-	}
+	e.mux.HandleFunc(uriAnnouncementFromBam, e.reqAnnouncementDeployFromBam)
+	// Prometheus exposition of the current and the last completed intervals
+	e.mux.HandleFunc(URL_METRICS, e.reqMetrics)
+	// Read-only JSON query API of the current and the completed intervals
+	e.onLoadAPI()
+	e.httpServer = &http.Server{Addr: e.config.StatHTTPServerAddr, Handler: e.mux}
+	go start(e.httpServer)
+	<-e.done
+	shutdown(context.Background(), e.httpServer)
 }
 
 func start(server *http.Server) {
@@ -15,23 +15,18 @@
 package statsdns
 
 import (
-	"encoding/json"
 	"fmt"
 	"net"
 	"os"
-	"strings"
-	"sync"
+	"sort"
 	"sync/atomic"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
-
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
-	"github.com/elastic/beats/packetbeat/config_statistics"
 	"github.com/elastic/beats/packetbeat/model"
+	"github.com/elastic/beats/packetbeat/namedconf"
 
-	"github.com/elastic/beats/packetbeat/outstats"
 	"github.com/elastic/beats/packetbeat/utils"
 	mkdns "github.com/miekg/dns"
 )
@@ -40,6 +35,7 @@ const (
 	CLIENT     = "perClient"
 	AUTHSERVER = "perServer"
 	VIEW       = "perView"
+	ZONE       = "perZone"
 	NOERROR    = "NOERROR"
 	NXDOMAIN   = "NXDOMAIN"
 	SERVFAIL   = "SERVFAIL"
@@ -53,6 +49,10 @@ const (
 	RQ_ERR_MAP = "Formerr"
 	NXRRSET    = "NXRRSET"
 	DAEMONS_PATH = "/etc/quagga/daemons"
+
+	// Aggregate entries of the clients and servers beyond MaximumClients
+	OTHER_CLIENTS = "__other__"
+	OTHER_SERVERS = "__other_servers__"
 )
 
 var (
@@ -65,6 +65,21 @@ type (
 		Start    time.Time                 `json:"start"`
 		End      time.Time                 `json:"end"`
 		StatsMap map[string]*StatisticsDNS `json:"stats_map"`
+		TopNames *TopNames                 `json:"top_names"`
+		Distinct *DistinctCounters         `json:"distinct"`
+		// Estimated numbers of clients and servers folded into the __other__ entries
+		FoldedClients int64 `json:"folded_clients"`
+		FoldedServers int64 `json:"folded_servers"`
+		foldedClients *HyperLogLog
+		foldedServers *HyperLogLog
+		// Number of perClient, perServer and perZone entries in StatsMap
+		clientCount int
+		serverCount int
+		zoneCount   int
+		// Engine of the statistics and the limits of this shard
+		engine         *StatisticsEngine
+		maximumClients int
+		maximumZones   int
 	}
 
 	// Statistics for a client or an AS.
@@ -75,22 +90,39 @@ type (
 
 	// Statistics details
 	DNSMetrics struct {
-		TotalQueries        int64    `json:"total_queries"`
-		TotalResponses      int64    `json:"total_responses"`
-		Recursive           int64    `json:"recursive"`
-		SuccessfulRecursive int64    `json:"successful_recursive"`
-		SuccessfulNoAuthAns int64    `json:"successful_noauthans"`
-		SuccessfulAuthAns   int64    `json:"successful_authans"`
-		Duplicated          int64    `json:"duplicated"`
-		AverageTime         *float64 `json:"average_time"`
-		Successful          int64    `json:"successful"`
-		ServerFail          int64    `json:"server_fail"`
-		NXDomain            int64    `json:"nx_domain"`
-		FormatError         int64    `json:"format_error"`
-		NXRRSet             int64    `json:"nx_rrset"`
-		Referral            int64    `json:"referral"`
-		Refused             int64    `json:"refused"`
-		OtherRcode          int64    `json:"other_rcode"`
+		TotalQueries        int64      `json:"total_queries"`
+		TotalResponses      int64      `json:"total_responses"`
+		Recursive           int64      `json:"recursive"`
+		SuccessfulRecursive int64      `json:"successful_recursive"`
+		SuccessfulNoAuthAns int64      `json:"successful_noauthans"`
+		SuccessfulAuthAns   int64      `json:"successful_authans"`
+		Duplicated          int64      `json:"duplicated"`
+		AverageTime         *float64   `json:"average_time"`
+		Successful          int64      `json:"successful"`
+		ServerFail          int64      `json:"server_fail"`
+		NXDomain            int64      `json:"nx_domain"`
+		FormatError         int64      `json:"format_error"`
+		NXRRSet             int64      `json:"nx_rrset"`
+		Referral            int64      `json:"referral"`
+		Refused             int64      `json:"refused"`
+		OtherRcode          int64      `json:"other_rcode"`
+		Latency             *Histogram `json:"latency"`
+		Timeouts            int64      `json:"timeouts"`
+		OrphanedResponses   int64      `json:"orphaned_responses"`
+		// Number of responses per query type and outcome
+		QueryTypes    map[string]map[string]int64 `json:"qtype_outcome"`
+		UDPQueries    int64                       `json:"udp_queries"`
+		TCPQueries    int64                       `json:"tcp_queries"`
+		EDNSQueries   int64                       `json:"edns_queries"`
+		NoEDNSQueries int64                       `json:"no_edns_queries"`
+		DOQueries     int64                       `json:"do_queries"`
+		// Number of EDNS queries per advertised UDP buffer size bucket
+		UDPSizes           map[string]int64 `json:"udp_size_buckets"`
+		TruncatedResponses int64            `json:"truncated_responses"`
+		BytesReceived      int64            `json:"bytes_received"`
+		BytesSent          int64            `json:"bytes_sent"`
+		RequestSize        *Histogram       `json:"request_size"`
+		ResponseSize       *Histogram       `json:"response_size"`
 	}
 
 	// Query map for recursion counting
@@ -100,140 +132,9 @@ type (
 )
 
 var (
-	StatSrv                      *StatisticsService
-	ReqMaps                      []*RequestMap
-	mutex                        = &sync.RWMutex{}
-	StatInterval                 = time.Duration(30)
-	MaximumClients               = 200
-	MaximumReqMap                = 2
-	IpNetsClient                 []*net.IPNet
-	IpNetsServer                 []*net.IPNet
-	IpsClient                    []string
-	IpsServer                    []string
-	UrlAnnouncementDeployFromBam string
-	MapViewIPs                   map[int]map[string][]string
-	QStatDNS                     *QueueStatDNS
-	IsActive                     bool
-	StatHTTPServerAddr           string
-	LocalAddrs                   []net.Addr
+	MaximumReqMap = 2
 )
 
-func InitStatisticsDNS() {
-	// Get data from statistics_config.json
-	GetConfigDNSStatistics()
-	// Update ACL client, server and MapViewIPs
-	ReloadNamedData(false)
-	// Start HTTP server
-	go onLoadHTTPServer()
-	// Create chan for management Statistic DNS counter
-	QStatDNS = NewQueueStatDNS()
-	QStatDNS.isPopWait = true
-	IsActive = true
-
-	LocalAddrs, _ = net.InterfaceAddrs()
-	go func() {
-		// check anycast service
-		watcher, err := fsnotify.NewWatcher()
-		if err != nil {
-			logp.Error(err)
-		}
-		defer watcher.Close()
-		
-		go func() {
-			for {
-				select {
-				case event, ok := <-watcher.Events:
-					if !ok {
-						return
-					}
-					if event.Op&fsnotify.Write == fsnotify.Write {
-						currentLocalAddrs, _ := net.InterfaceAddrs()
-						if !Equal(currentLocalAddrs, LocalAddrs) {
-							logp.Info("Local Addresses: %v ", LocalAddrs)
-							LocalAddrs = currentLocalAddrs
-							return
-						}
-
-						number := 1
-						for Equal(currentLocalAddrs, LocalAddrs) {
-							if number > 60 {
-								break;
-							}
-
-							time.Sleep(1 * time.Second)
-							currentLocalAddrs, _ = net.InterfaceAddrs()
-							if !Equal(currentLocalAddrs, LocalAddrs) {
-								logp.Info("Local Addresses: %v ", currentLocalAddrs)
-								LocalAddrs = currentLocalAddrs
-								break
-							}
-						}
-					}
-				case err, ok := <-watcher.Errors:
-					if !ok {
-						return
-					}
-					logp.Error(err)
-				}
-			}
-		}()
-
-		err = watcher.Add(DAEMONS_PATH)
-		if err != nil {
-			logp.Error(err)
-		}
-
-		ticker := time.NewTicker(StatInterval * time.Second)
-		defer ticker.Stop()
-		go func() {
-			QStatDNS.isActive = IsActive
-			QStatDNS.PopStatDNS()
-		}()
-
-		for IsActive {
-			StatSrv = &StatisticsService{StatsMap: make(map[string]*StatisticsDNS, MaximumClients)}
-			onLoadReqMaps()
-			CreateCounterMetricPerView(MapViewIPs)
-
-			// Active flag sub for counter
-			QStatDNS.isPopWait = false
-
-			timeEnd := <-ticker.C
-			mutex.Lock()
-			QStatDNS.isPopWait = true
-			StatSrv.Start = timeEnd.Add((-StatInterval) * time.Second)
-			StatSrv.End = timeEnd
-			b, err := json.Marshal(StatSrv)
-			if err != nil {
-				logp.Error(err)
-				continue
-			}
-
-			logp.Info("DNS_Statistics: %s", b)
-			// open new thread to call the API
-			go func() {
-				outstats.PublishToSNMPAgent(string(b))
-			}()
-			mutex.Unlock()
-		}
-	}()
-}
-
-func Stop() {
-	IsActive = false
-	QStatDNS.Stop()
-}
-
-// Check if the IP Address is the local IP Address
-func IsLocalIP(ip string) bool {
-	for _, addr := range LocalAddrs {
-		if strings.SplitN(addr.String(), "/", 2)[0] == ip {
-			return true
-		}
-	}
-	return false
-}
-
 func Equal(first, second []net.Addr) bool {
 	if len(first) != len(second) {
 		return false
@@ -250,61 +151,152 @@ func Equal(first, second []net.Addr) bool {
 	return true
 }
 
-
-// Check if both of source and destination IP Address are the local IP Address
-func IsInternalCall(srcIp string, dstIp string) bool {
-	return IsLocalIP(srcIp) && IsLocalIP(dstIp)
-}
-
-
-func onLoadReqMaps() {
-	// Load default RequestMap in ReqMaps array
-	// Lenght of ReqMaps is equal MaximumReqMap
-	reqMap := &RequestMap{RequestMessage: make(map[string]map[string]string, MaximumClients)}
+func (e *StatisticsEngine) rotateReqMaps() {
+	// Load default RequestMap in reqMaps array
+	// Lenght of reqMaps is equal MaximumReqMap
+	reqMap := &RequestMap{RequestMessage: make(map[string]map[string]string, e.config.MaximumClients)}
 	reqMap.RequestMessage[RQ_C_MAP] = make(map[string]string)
 	reqMap.RequestMessage[RQ_S_MAP] = make(map[string]string)
-	if len(ReqMaps) < MaximumReqMap {
-		ReqMaps = append(ReqMaps, reqMap)
+	e.reqMutex.Lock()
+	defer e.reqMutex.Unlock()
+	if len(e.reqMaps) < MaximumReqMap {
+		e.reqMaps = append(e.reqMaps, reqMap)
 	} else {
-		ReqMaps = ReqMaps[1:]
-		ReqMaps = append(ReqMaps, reqMap)
+		e.reqMaps = e.reqMaps[1:]
+		e.reqMaps = append(e.reqMaps, reqMap)
 	}
 }
 
-func IsValidInACL(statIP string, metricType string) bool {
+func (e *StatisticsEngine) IsValidInACL(statIP string, metricType string) bool {
+	named := e.namedData()
 	switch metricType {
 	case CLIENT:
-		if EnablePerClient() && utils.CheckIPInRanges(statIP, IpNetsClient, IpsClient) {
+		if EnablePerClient() && utils.CheckIPInRanges(statIP, named.ipNetsClient, named.ipsClient) {
 			return true
 		}
 	case AUTHSERVER:
-		if EnablePerClient() && utils.CheckIPInRanges(statIP, IpNetsServer, IpsServer) {
+		if EnablePerClient() && utils.CheckIPInRanges(statIP, named.ipNetsServer, named.ipsServer) {
 			return true
 		}
-	case VIEW:
+	case VIEW, ZONE:
 		return true
 	}
 	return false
 }
 
-// Create statistics for perClient, perServer and perView.
+// Create statistics for perClient, perServer, perView and perZone.
 // Note metricType="perView" => (key of map statistic clientIP = key viewName)
-func newStats(clientIp string, metricType string) bool {
+// and metricType="perZone" => (key of map statistic clientIP = zone name with the trailing dot)
+func (s *StatisticsService) newStats(clientIp string, metricType string) bool {
+	return s.newStatsKey(clientIp, metricType) != ""
+}
+
+// Create the statistics like newStats and return their key in StatsMap, empty if the IP isn't in the ACL.
+// Once MaximumClients clients (or servers) are counted, the new ones are folded into the __other__ entry of their type.
+func (s *StatisticsService) newStatsKey(clientIp string, metricType string) string {
+	if clientIp == "" {
+		return ""
+	}
+	if clientIp == OTHER_CLIENTS || clientIp == OTHER_SERVERS {
+		if _, exist := s.StatsMap[clientIp]; exist {
+			return clientIp
+		}
+		return ""
+	}
 	// Don't want to be calculating the internal messages or ip that doesn't in range in config statistics_config.json
-	if !IsValidInACL(clientIp, metricType) {
-		return false
+	if !s.engine.IsValidInACL(clientIp, metricType) {
+		return ""
 	}
-	if _, exist := StatSrv.StatsMap[clientIp]; !exist {
-		averagetime := float64(0)
-		stats := &StatisticsDNS{
-			Type: metricType,
-			DNSMetrics: &DNSMetrics{
-				AverageTime: &averagetime,
-			},
+	if _, exist := s.StatsMap[clientIp]; exist {
+		return clientIp
+	}
+	key := clientIp
+	switch metricType {
+	case CLIENT:
+		if s.maximumClients > 0 && s.clientCount >= s.maximumClients {
+			if s.foldedClients == nil {
+				s.foldedClients = NewHyperLogLog()
+			}
+			s.foldedClients.Add(clientIp)
+			key = OTHER_CLIENTS
+		} else {
+			s.clientCount++
+		}
+	case AUTHSERVER:
+		if s.maximumClients > 0 && s.serverCount >= s.maximumClients {
+			if s.foldedServers == nil {
+				s.foldedServers = NewHyperLogLog()
+			}
+			s.foldedServers.Add(clientIp)
+			key = OTHER_SERVERS
+		} else {
+			s.serverCount++
 		}
-		StatSrv.StatsMap[clientIp] = stats
 	}
-	return true
+	if _, exist := s.StatsMap[key]; !exist {
+		s.StatsMap[key] = newStatisticsDNS(metricType)
+	}
+	return key
+}
+
+// Keep the maximumClients clients and servers with the most queries and fold the others into their __other__
+// entry. Each shard counts up to maximumClients clients and servers and their union is limited once merged,
+// so that the limit doesn't depend on how the clients are split between the shards.
+func (s *StatisticsService) limitClients() {
+	s.clientCount, s.foldedClients = s.foldEntries(CLIENT, OTHER_CLIENTS, s.foldedClients)
+	s.serverCount, s.foldedServers = s.foldEntries(AUTHSERVER, OTHER_SERVERS, s.foldedServers)
+	s.FoldedClients = s.foldedClients.Count()
+	s.FoldedServers = s.foldedServers.Count()
+}
+
+// Fold the entries of a type beyond maximumClients into its aggregate entry, return the number of entries kept
+// and the estimator of the folded ones
+func (s *StatisticsService) foldEntries(metricType string, otherKey string, folded *HyperLogLog) (int, *HyperLogLog) {
+	keys := []string{}
+	for key, stats := range s.StatsMap {
+		if stats.Type == metricType && key != otherKey {
+			keys = append(keys, key)
+		}
+	}
+	if s.maximumClients <= 0 || len(keys) <= s.maximumClients {
+		return len(keys), folded
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		first, second := s.StatsMap[keys[i]].DNSMetrics, s.StatsMap[keys[j]].DNSMetrics
+		if first.TotalQueries != second.TotalQueries {
+			return first.TotalQueries > second.TotalQueries
+		}
+		return keys[i] < keys[j]
+	})
+	other, exist := s.StatsMap[otherKey]
+	if !exist {
+		other = newStatisticsDNS(metricType)
+		s.StatsMap[otherKey] = other
+	}
+	if folded == nil {
+		folded = NewHyperLogLog()
+	}
+	for _, key := range keys[s.maximumClients:] {
+		other.DNSMetrics.Merge(s.StatsMap[key].DNSMetrics)
+		folded.Add(key)
+		delete(s.StatsMap, key)
+	}
+	return s.maximumClients, folded
+}
+
+func newStatisticsDNS(metricType string) *StatisticsDNS {
+	averagetime := float64(0)
+	return &StatisticsDNS{
+		Type: metricType,
+		DNSMetrics: &DNSMetrics{
+			AverageTime:  &averagetime,
+			Latency:      NewLatencyHistogram(),
+			QueryTypes:   make(map[string]map[string]int64),
+			UDPSizes:     make(map[string]int64),
+			RequestSize:  NewSizeHistogram(),
+			ResponseSize: NewSizeHistogram(),
+		},
+	}
 }
 
 func EnablePerClient() bool {
@@ -315,18 +307,19 @@ func EnablePerClient() bool {
 }
 
 
-func ReceivedMessage(msg *model.Record) {
-	mutex.Lock()
-	defer mutex.Unlock()
+func (s *StatisticsService) ReceivedMessage(msg *model.Record) {
 	// Don't want to be calculating the internal messages
-	if IsInternalCall(msg.Src.IP, msg.Dst.IP) {
+	if s.engine.IsInternalCall(msg.Src.IP, msg.Dst.IP) {
 		return
 	}
 	metricType := CLIENT
 	clientIP := msg.Src.IP
-	if IsLocalIP(clientIP) {
+	viewName := ""
+	if s.engine.IsLocalIP(clientIP) {
 		metricType = AUTHSERVER
 		clientIP = msg.Dst.IP
+	} else {
+		viewName = s.engine.viewOfRecord(msg)
 	}
 
 	answersCount := msg.DNS.AnswersCount
@@ -335,22 +328,22 @@ func ReceivedMessage(msg *model.Record) {
 	responseCode := msg.DNS.ResponseCode
 	authoritiesCount := msg.DNS.AuthoritiesCount
 	responseStatus := msg.Status
+	outcome := ""
 
-	// First message for this client/AS
-	newStats(clientIP, metricType)
+	// First message for this client/AS, statIP is the key of its statistics
+	statIP := s.newStatsKey(clientIP, metricType)
 
 	defer func() {
 		if err := recover(); err != nil {
-			QStatDNS.PushRecordDNS(msg)
 			logp.Debug("statsdns.ReceivedMessage", " %s", err)
 			return
 		}
 	}()
 
 	// Increase TotalResponse
-	IncrDNSStatsTotalResponses(clientIP)
+	s.IncrDNSStatsTotalResponses(statIP)
 	if metricType != AUTHSERVER {
-        ResponseForPerView(clientIP)
+        s.ResponseForPerView(viewName)
     }
 
 	debugf("[ReceivedMessage] ID: %s - transp: %s - responseCode: %s - answersCount: %s", msg.DNS.ID,  msg.Transport, responseCode, answersCount)
@@ -358,15 +351,16 @@ func ReceivedMessage(msg *model.Record) {
 		debugf("[ReceivedMessage] isTruncated: %s", isTruncated)
 		if answersCount > 0 || isTruncated {
 			// Successful case
-			IncrDNSStatsSuccessful(clientIP)
-			IncrDNSStatsSuccessfulForPerView(clientIP, metricType)
+			s.IncrDNSStatsSuccessful(statIP)
+			s.IncrDNSStatsSuccessfulForPerView(viewName, metricType)
+			outcome = OUTCOME_SUCCESSFUL
 
             debugf("[ReceivedMessage] msg.DNS.Flags.Authoritative: %s ", msg.DNS.Flags.Authoritative)
 			if !msg.DNS.Flags.Authoritative {
-				IncrDNSStatsSuccessfulNoAuthAns(clientIP)
-				IncrDNSStatsSuccessfulNoAuthAnsForPerView(clientIP)
+				s.IncrDNSStatsSuccessfulNoAuthAns(statIP)
+				s.IncrDNSStatsSuccessfulNoAuthAnsForPerView(viewName)
 			} else {
-			     IncrDNSStatsSuccessfulAuthAnsForPerView(clientIP, metricType)
+			     s.IncrDNSStatsSuccessfulAuthAnsForPerView(viewName, metricType)
 			}
 		} else {
 			// Referral: NOERROR, no answer and NS records in Authority
@@ -382,43 +376,69 @@ func ReceivedMessage(msg *model.Record) {
 			}
 
 			if foundNS {
-				IncrDNSStatsReferral(clientIP)
-				IncrDNSStatsReferralForPerView(clientIP, metricType)
+				s.IncrDNSStatsReferral(statIP)
+				s.IncrDNSStatsReferralForPerView(viewName, metricType)
+				outcome = OUTCOME_REFERRAL
 			} else {
 				// NXRRSet: NOERROR and no answer
-				IncrDNSStatsNXRRSet(clientIP)
-				IncrDNSStatsNXRRSetForPerView(clientIP, metricType)
+				s.IncrDNSStatsNXRRSet(statIP)
+				s.IncrDNSStatsNXRRSetForPerView(viewName, metricType)
+				outcome = OUTCOME_NXRRSET
 			}
 		}
 	} else if responseCode == NXRRSET {
 		// RRCode == 8 and answersCount == 0
-		IncrDNSStatsNXRRSet(clientIP)
-		IncrDNSStatsNXRRSetForPerView(clientIP, metricType)
+		s.IncrDNSStatsNXRRSet(statIP)
+		s.IncrDNSStatsNXRRSetForPerView(viewName, metricType)
+		outcome = OUTCOME_NXRRSET
 	} else if responseCode == NXDOMAIN {
-		IncrDNSStatsNXDomain(clientIP)
-		IncrDNSStatsNXDomainForPerView(clientIP, metricType)
+		s.IncrDNSStatsNXDomain(statIP)
+		s.IncrDNSStatsNXDomainForPerView(viewName, metricType)
+		outcome = OUTCOME_NXDOMAIN
 	} else if responseCode == SERVFAIL {
-		IncrDNSStatsServerFail(clientIP)
-		IncrDNSStatsServerFailForPerView(clientIP, metricType)
+		s.IncrDNSStatsServerFail(statIP)
+		s.IncrDNSStatsServerFailForPerView(viewName, metricType)
+		outcome = OUTCOME_SERVFAIL
 	} else if responseCode == REFUSED {
-		IncrDNSStatsRefused(clientIP)
-		IncrDNSStatsRefusedForPerView(clientIP, metricType)
+		s.IncrDNSStatsRefused(statIP)
+		s.IncrDNSStatsRefusedForPerView(viewName, metricType)
+		outcome = OUTCOME_REFUSED
 	} else if responseCode == FORMERR {
 		// Should not be run into here
 		// We already handled when parsing the packets
-		IncrDNSStatsFormatError(clientIP)
-		IncrDNSStatsFormatErrorForPerView(clientIP, metricType)
+		s.IncrDNSStatsFormatError(statIP)
+		s.IncrDNSStatsFormatErrorForPerView(viewName, metricType)
+		outcome = OUTCOME_FORMERR
 	} else {
-		IncrDNSStatsOtherRCode(clientIP)
-		IncrDNSStatsOtherRCodeForPerView(clientIP, metricType)
+		s.IncrDNSStatsOtherRCode(statIP)
+		s.IncrDNSStatsOtherRCodeForPerView(viewName, metricType)
+		outcome = OUTCOME_OTHER_RCODE
 	}
 
-	CalculateAverageTime(clientIP, responseTime)
-	CalculateAverageTimePerView(clientIP, responseTime, metricType)
+	if isTruncated {
+		s.IncrDNSStatsTruncated(statIP)
+		s.IncrDNSStatsTruncatedForPerView(viewName, metricType)
+	}
+
+	if metricType == CLIENT {
+		s.TopNames.Offer(recordQueryName(msg), viewName, responseCode)
+		s.ReceivedMessageForPerZone(msg, outcome)
+	}
+
+	if msg.DNS.Question != nil && msg.DNS.Question.Type != "" {
+		s.IncrDNSStatsQueryType(statIP, msg.DNS.Question.Type, outcome)
+		s.IncrDNSStatsQueryTypeForPerView(viewName, msg.DNS.Question.Type, outcome, metricType)
+	}
+
+	s.IncrDNSStatsSizes(statIP, msg.BytesIn, msg.BytesOut, metricType)
+	s.IncrDNSStatsSizesForPerView(viewName, msg.BytesIn, msg.BytesOut, metricType)
+
+	s.CalculateAverageTime(statIP, responseTime)
+	s.CalculateAverageTimePerView(viewName, responseTime, metricType)
 }
 
-func CheckMetricType(srcIp string, dstIp string, mode string) (statIP string, metricType string) {
-	if IsLocalIP(dstIp) {
+func (e *StatisticsEngine) CheckMetricType(srcIp string, dstIp string, mode string) (statIP string, metricType string) {
+	if e.IsLocalIP(dstIp) {
 		statIP = srcIp
 		switch mode {
 		case QUERY:
@@ -439,19 +459,17 @@ func CheckMetricType(srcIp string, dstIp string, mode string) (statIP string, me
 }
 
 //Create metric for the Client/AS/Forwarder
-func CreateCounterMetric(srcIp string, dstIp string, mode string) (statIP string) {
-	statIP, metricType := CheckMetricType(srcIp, dstIp, mode)
-	if !newStats(statIP, metricType) {
-		statIP = ""
-	}
+func (s *StatisticsService) CreateCounterMetric(srcIp string, dstIp string, mode string) (statIP string) {
+	ip, metricType := s.engine.CheckMetricType(srcIp, dstIp, mode)
+	statIP = s.newStatsKey(ip, metricType)
 	return
 }
 
 //Create metric for perView
-func CreateCounterMetricPerView(mapViewIPs map[int]map[string][]string) {
+func (s *StatisticsService) CreateCounterMetricPerView(mapViewIPs map[int]map[string][]string) {
 	for i := 0; i < len(mapViewIPs); i++ {
 		for viewName, _ := range mapViewIPs[i] {
-			status := newStats(viewName, VIEW)
+			status := s.newStats(viewName, VIEW)
 			if !status {
 				logp.Err("Couldn't Create View : %s", viewName)
 			}
@@ -460,393 +478,393 @@ func CreateCounterMetricPerView(mapViewIPs map[int]map[string][]string) {
 
 }
 
-func Queries(srcIp string, dstIp string) {
+func (s *StatisticsService) Queries(srcIp string, dstIp string) {
 	defer func() {
 		if err := recover(); err != nil {
-			// Default isDuplicated false in here
-			queryDNS := NewQueryDNS(srcIp, dstIp, false)
-			QStatDNS.PushQueryDNS(queryDNS)
 			logp.Debug("statsdns.Queries", " %s", err)
 			return
 		}
 	}()
-	if statIP := CreateCounterMetric(srcIp, dstIp, QUERY); statIP != "" {
-		IncrDNSStatsTotalQueries(statIP)
+	if statIP := s.CreateCounterMetric(srcIp, dstIp, QUERY); statIP != "" {
+		s.IncrDNSStatsTotalQueries(statIP)
 	}
 }
 
-func QueriesForPerView(srcIp string) {
-	if !IsLocalIP(srcIp) {
-		if viewName := FindClientInView(srcIp); viewName != "" {
-			IncrDNSStatsTotalQueries(viewName)
-		}
+func (s *StatisticsService) QueriesForPerView(viewName string) {
+	if s.newStats(viewName, VIEW) {
+		s.IncrDNSStatsTotalQueries(viewName)
 	}
 }
 
-func Response(srcIp string, dstIp string) {
-	if statIP := CreateCounterMetric(srcIp, dstIp, RESPONSE); statIP != "" {
-		IncrDNSStatsTotalResponses(statIP)
+func (s *StatisticsService) Response(srcIp string, dstIp string) {
+	if statIP := s.CreateCounterMetric(srcIp, dstIp, RESPONSE); statIP != "" {
+		s.IncrDNSStatsTotalResponses(statIP)
 	}
 }
 
-func ResponseForPerView(dstIp string) {
-	if !IsLocalIP(dstIp) {
-		if viewName := FindClientInView(dstIp); viewName != "" {
-			IncrDNSStatsTotalResponses(viewName)
-		}
+func (s *StatisticsService) ResponseForPerView(viewName string) {
+	if s.newStats(viewName, VIEW) {
+		s.IncrDNSStatsTotalResponses(viewName)
 	}
 }
 
-func IncreaseQueryCounter(srcIp string, dstIp string, mode string) {
-	// if IsInternalCall(srcIp, dstIp) {
+func (s *StatisticsService) IncreaseQueryCounter(srcIp string, dstIp string, mode string) {
+	// if s.engine.IsInternalCall(srcIp, dstIp) {
 	// 	return
 	// }
-	mutex.Lock()
-	defer mutex.Unlock()
 	switch mode {
 	case QUERY:
-		Queries(srcIp, dstIp)
+		s.Queries(srcIp, dstIp)
 		break
 	case RESPONSE:
-		Response(srcIp, dstIp)
+		s.Response(srcIp, dstIp)
 		break
 	}
 }
 
-func IncreaseQueryCounterForPerView(srcIp string, dstIp string, mode string) {
-	// if IsInternalCall(srcIp, dstIp) {
-	// 	return
-	// }
+func (s *StatisticsService) IncreaseQueryCounterForPerView(viewName string, mode string) {
 	switch mode {
 	case QUERY:
-		QueriesForPerView(srcIp)
+		s.QueriesForPerView(viewName)
 		break
 	case RESPONSE:
-		ResponseForPerView(dstIp)
+		s.ResponseForPerView(viewName)
 		break
 	}
 }
 
-func IncrDNSStatsTotalQueries(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.TotalQueries, 1)
+// Count the query that expired without response for the client or AS, the view of the query and the zone
+func (s *StatisticsService) IncreaseTimeoutCounter(srcIp string, dstIp string, queryName string, viewName string) {
+	if s.engine.IsInternalCall(srcIp, dstIp) {
+		return
+	}
+	if statIP := s.CreateCounterMetric(srcIp, dstIp, QUERY); statIP != "" {
+		s.IncrDNSStatsTimeouts(statIP)
+	}
+	if !s.engine.IsLocalIP(srcIp) {
+		s.IncrDNSStatsTimeoutsForPerView(viewName)
+		s.IncrDNSStatsTimeoutsForPerZone(queryName)
+	}
+}
+
+// Count the response from an AS that doesn't match any outgoing query
+func (s *StatisticsService) IncreaseOrphanedCounter(srcIp string, dstIp string) {
+	if s.engine.IsInternalCall(srcIp, dstIp) {
+		return
+	}
+	serverIP, metricType := s.engine.CheckMetricType(srcIp, dstIp, RESPONSE)
+	if metricType != AUTHSERVER {
+		return
+	}
+	if statIP := s.newStatsKey(serverIP, metricType); statIP != "" {
+		atomic.AddInt64(&s.StatsMap[statIP].DNSMetrics.OrphanedResponses, 1)
+	}
+}
+
+func (s *StatisticsService) IncrDNSStatsTotalQueries(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.TotalQueries, 1)
     }
 }
 
-func IncrDNSStatsTotalQueriesForPerView(clientIp string) {
-	if viewName := FindClientInView(clientIp); viewName != "" {
-		atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.TotalQueries, 1)
+func (s *StatisticsService) IncrDNSStatsTotalQueriesForPerView(viewName string) {
+	if s.newStats(viewName, VIEW) {
+		atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.TotalQueries, 1)
 	}
 }
 
-func IncrDNSStatsTotalResponses(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.TotalResponses, 1)
+func (s *StatisticsService) IncrDNSStatsTotalResponses(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.TotalResponses, 1)
     }
 }
 
-func IncrDNSStatsRecursive(clientIp string) {
-	if !newStats(clientIp, CLIENT) {
+func (s *StatisticsService) IncrDNSStatsTimeouts(clientIp string) {
+	if _, exist := s.StatsMap[clientIp]; exist {
+		atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.Timeouts, 1)
+	}
+}
+
+func (s *StatisticsService) IncrDNSStatsTimeoutsForPerView(viewName string) {
+	if s.newStats(viewName, VIEW) {
+		atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.Timeouts, 1)
+	}
+}
+
+func (s *StatisticsService) IncrDNSStatsRecursive(clientIp string) {
+	statIP := s.newStatsKey(clientIp, CLIENT)
+	if statIP == "" {
 		return
 	}
-	atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.Recursive, 1)
+	atomic.AddInt64(&s.StatsMap[statIP].DNSMetrics.Recursive, 1)
 }
 
-func IncrDNSStatsRecursiveForPerView(clientIp string) {
-	if viewName := FindClientInView(clientIp); viewName != "" {
-		atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.Recursive, 1)
+func (s *StatisticsService) IncrDNSStatsRecursiveForPerView(viewName string) {
+	if s.newStats(viewName, VIEW) {
+		atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.Recursive, 1)
 	}
 }
 
-func IncrDNSStatsDuplicated(clientIp string) {
-	if !IsLocalIP(clientIp) && newStats(clientIp, CLIENT) {
-		atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.Duplicated, 1)
+func (s *StatisticsService) IncrDNSStatsDuplicated(clientIp string) {
+	if s.engine.IsLocalIP(clientIp) {
+		return
+	}
+	if statIP := s.newStatsKey(clientIp, CLIENT); statIP != "" {
+		atomic.AddInt64(&s.StatsMap[statIP].DNSMetrics.Duplicated, 1)
 	}
 }
 
-func IncrDNSStatsDuplicatedForPerView(clientIp string) {
-	if !IsLocalIP(clientIp) {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.Duplicated, 1)
-		}
+func (s *StatisticsService) IncrDNSStatsDuplicatedForPerView(viewName string) {
+	if s.newStats(viewName, VIEW) {
+		atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.Duplicated, 1)
 	}
 }
 
-func IncrDNSStatsSuccessful(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.Successful, 1)
+func (s *StatisticsService) IncrDNSStatsSuccessful(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.Successful, 1)
     }
 }
 
-func IncrDNSStatsSuccessfulForPerView(clientIp string, metricType string) {
+func (s *StatisticsService) IncrDNSStatsSuccessfulForPerView(viewName string, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.Successful, 1)
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.Successful, 1)
 		}
 	}
 }
 
-func IncrDNSStatsSuccessfulNoAuthAns(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.SuccessfulNoAuthAns, 1)
+func (s *StatisticsService) IncrDNSStatsSuccessfulNoAuthAns(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.SuccessfulNoAuthAns, 1)
     }
 }
 
-func IncrDNSStatsSuccessfulNoAuthAnsForPerView(clientIp string) {
-	if viewName := FindClientInView(clientIp); viewName != "" {
-		atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.SuccessfulNoAuthAns, 1)
+func (s *StatisticsService) IncrDNSStatsSuccessfulNoAuthAnsForPerView(viewName string) {
+	if s.newStats(viewName, VIEW) {
+		atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.SuccessfulNoAuthAns, 1)
 	}
 }
 
-func IncrDNSStatsSuccessfulAuthAnsForPerView(clientIp string, metricType string) {
+func (s *StatisticsService) IncrDNSStatsSuccessfulAuthAnsForPerView(viewName string, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.SuccessfulAuthAns, 1)
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.SuccessfulAuthAns, 1)
 		}
 	}
 }
 
 
-func IncrDNSStatsSuccessfulRecursive(clientIp string) {
-	if !newStats(clientIp, CLIENT) {
+func (s *StatisticsService) IncrDNSStatsSuccessfulRecursive(clientIp string) {
+	statIP := s.newStatsKey(clientIp, CLIENT)
+	if statIP == "" {
 		return
 	}
-	atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.SuccessfulRecursive, 1)
+	atomic.AddInt64(&s.StatsMap[statIP].DNSMetrics.SuccessfulRecursive, 1)
 }
 
-func IncrDNSStatsSuccessfulRecursiveForPerView(clientIp string) {
-	if viewName := FindClientInView(clientIp); viewName != "" {
-		atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.SuccessfulRecursive, 1)
+func (s *StatisticsService) IncrDNSStatsSuccessfulRecursiveForPerView(viewName string) {
+	if s.newStats(viewName, VIEW) {
+		atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.SuccessfulRecursive, 1)
 	}
 }
 
-func IncrDNSStatsServerFail(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.ServerFail, 1)
+func (s *StatisticsService) IncrDNSStatsServerFail(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.ServerFail, 1)
     }
 }
 
-func IncrDNSStatsServerFailForPerView(clientIp string, metricType string) {
+func (s *StatisticsService) IncrDNSStatsServerFailForPerView(viewName string, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.ServerFail, 1)
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.ServerFail, 1)
 		}
 	}
 }
 
-func IncrDNSStatsNXDomain(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.NXDomain, 1)
+func (s *StatisticsService) IncrDNSStatsNXDomain(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.NXDomain, 1)
     }
 }
 
-func IncrDNSStatsNXDomainForPerView(clientIp string, metricType string) {
+func (s *StatisticsService) IncrDNSStatsNXDomainForPerView(viewName string, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.NXDomain, 1)
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.NXDomain, 1)
 		}
 	}
 }
 
-func IncrDNSStatsFormatError(clientIp string) {
-	if !IsLocalIP(clientIp) && newStats(clientIp, CLIENT) {
-		atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.FormatError, 1)
+func (s *StatisticsService) IncrDNSStatsFormatError(clientIp string) {
+	if s.engine.IsLocalIP(clientIp) {
+		return
+	}
+	if statIP := s.newStatsKey(clientIp, CLIENT); statIP != "" {
+		atomic.AddInt64(&s.StatsMap[statIP].DNSMetrics.FormatError, 1)
 	}
 }
 
-func IncrDNSStatsFormatErrorForPerView(clientIp string, metricType string) {
+func (s *StatisticsService) IncrDNSStatsFormatErrorForPerView(viewName string, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			if !IsLocalIP(clientIp) && newStats(viewName, VIEW) {
-				atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.FormatError, 1)
-			}
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.FormatError, 1)
 		}
 	}
 }
 
-func IncrDNSStatsNXRRSet(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.NXRRSet, 1)
+func (s *StatisticsService) IncrDNSStatsNXRRSet(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.NXRRSet, 1)
     }
 }
 
-func IncrDNSStatsNXRRSetForPerView(clientIp string, metricType string) {
+func (s *StatisticsService) IncrDNSStatsNXRRSetForPerView(viewName string, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.NXRRSet, 1)
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.NXRRSet, 1)
 		}
 	}
 }
 
-func IncrDNSStatsReferral(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.Referral, 1)
+func (s *StatisticsService) IncrDNSStatsReferral(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.Referral, 1)
     }
 }
 
-func IncrDNSStatsReferralForPerView(clientIp string, metricType string) {
+func (s *StatisticsService) IncrDNSStatsReferralForPerView(viewName string, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.Referral, 1)
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.Referral, 1)
 		}
 	}
 }
 
-func IncrDNSStatsRefused(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.Refused, 1)
+func (s *StatisticsService) IncrDNSStatsRefused(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.Refused, 1)
     }
 }
 
-func IncrDNSStatsRefusedForPerView(clientIp string, metricType string) {
+func (s *StatisticsService) IncrDNSStatsRefusedForPerView(viewName string, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.Refused, 1)
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.Refused, 1)
 		}
 	}
 }
 
-func IncrDNSStatsOtherRCode(clientIp string) {
-    if _, exist := StatSrv.StatsMap[clientIp]; exist {
-        atomic.AddInt64(&StatSrv.StatsMap[clientIp].DNSMetrics.OtherRcode, 1)
+func (s *StatisticsService) IncrDNSStatsOtherRCode(clientIp string) {
+    if _, exist := s.StatsMap[clientIp]; exist {
+        atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.OtherRcode, 1)
     }
 }
 
-func IncrDNSStatsOtherRCodeForPerView(clientIp string, metricType string) {
+func (s *StatisticsService) IncrDNSStatsOtherRCodeForPerView(viewName string, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			atomic.AddInt64(&StatSrv.StatsMap[viewName].DNSMetrics.OtherRcode, 1)
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.OtherRcode, 1)
 		}
 	}
 }
 
-func CalculateAverageTime(clientIp string, responseTime float64) {
-	statisticsDNS, ok := StatSrv.StatsMap[clientIp]
+// Record the response time in the latency histogram of the client/AS.
+// AverageTime is the mean of the recorded samples, so it doesn't depend on how queries and responses are counted.
+func (s *StatisticsService) CalculateAverageTime(clientIp string, responseTime float64) {
+	statisticsDNS, ok := s.StatsMap[clientIp]
 	if !ok {
 		return
 	}
-	averageTime := *statisticsDNS.DNSMetrics.AverageTime
-	toTalMessage := statisticsDNS.DNSMetrics.TotalQueries
-	if statisticsDNS.Type == AUTHSERVER {
-		toTalMessage = statisticsDNS.DNSMetrics.TotalResponses
-	}
-	if toTalMessage == 0 {
-		toTalMessage = 1
-	}
-	*statisticsDNS.DNSMetrics.AverageTime = (averageTime*float64(toTalMessage-1) + responseTime) / float64(toTalMessage)
+	observeResponseTime(statisticsDNS.DNSMetrics, responseTime)
 }
 
-func CalculateAverageTimePerView(clientIp string, responseTime float64, metricType string) {
+func (s *StatisticsService) CalculateAverageTimePerView(viewName string, responseTime float64, metricType string) {
 	if metricType == CLIENT {
-		if viewName := FindClientInView(clientIp); viewName != "" {
-			statisticsDNS, ok := StatSrv.StatsMap[viewName]
-			if !ok {
-				return
-			}
-			averageTime := *statisticsDNS.DNSMetrics.AverageTime
-			toTalMessage := statisticsDNS.DNSMetrics.TotalQueries
-			if toTalMessage == 0 {
-				toTalMessage = 1
-			}
-			*statisticsDNS.DNSMetrics.AverageTime = (averageTime*float64(toTalMessage-1) + responseTime) / float64(toTalMessage)
+		if s.newStats(viewName, VIEW) {
+			observeResponseTime(s.StatsMap[viewName].DNSMetrics, responseTime)
 		}
 	}
 }
 
-func FindClientInView(clientIP string) string {
-	result := ""
-	foundView := false
-	for i := 0; i < len(MapViewIPs); i++ {
-		for viewName := range MapViewIPs[i] {
-			for _, matchIP := range MapViewIPs[i][viewName] {
-				//Ingore case
-				if strings.Contains(matchIP, "!") {
-					// Case for Ignore IP range
-					matchIp := strings.TrimLeft(matchIP, "!")
-					// If / character in matchIP
-					if strings.Contains(matchIP, "/") {
-						_, ipNet, _ := net.ParseCIDR(matchIp)
-						if ipNet.Contains(net.ParseIP(clientIP)) {
-							result = ""
-							foundView = true
-							break
-						}
-					}
-					//Case for Ignore IP
-					if matchIp == clientIP {
-						result = ""
-						foundView = true
-						break
-					}
-				} else {
-					//Allow case range IP
-					if strings.Contains(matchIP, "/") {
-						if utils.CheckIpRangeFromString(clientIP, matchIP) {
-							result = viewName
-							foundView = true
-							break
-						}
-					}
-					//Matching IP case
-					if matchIP == clientIP || matchIP == "any" {
-						result = viewName
-						foundView = true
-						break
-					}
-				}
-			}
-			if foundView {
-				break
-			}
-		}
-		if foundView && result != "" {
-			break
-		}
-	}
-	return result
+func observeResponseTime(metrics *DNSMetrics, responseTime float64) {
+	metrics.Latency.Observe(responseTime)
+	*metrics.AverageTime = metrics.Latency.Mean()
+}
+
+// View named chooses for a query: the first view of named.conf whose match-clients, match-destinations,
+// match-recursive-only and keys accept it. Empty if no view accepts it or the views couldn't be read.
+func (e *StatisticsEngine) FindView(query *namedconf.ViewQuery) string {
+	return e.namedData().views.Match(query)
+}
+
+// View of the queries of a client when only its address is known: recursive, unsigned and sent to an
+// address accepted by match-destinations
+func (e *StatisticsEngine) FindClientInView(clientIP string) string {
+	return e.FindView(&namedconf.ViewQuery{Client: net.ParseIP(clientIP), Recursive: true})
 }
 
-func GetConfigDNSStatistics() {
-	logp.Info("GetConfigDNSStatistics")
-	//Init and read config dns statistic
-	config_statistics.Init()
-	StatInterval = config_statistics.ConfigStat.StatisticsInterval
-	MaximumClients = config_statistics.ConfigStat.MaximumClients
-	StatHTTPServerAddr = config_statistics.ConfigStat.StatHTTPServerAddr
-	UrlAnnouncementDeployFromBam = config_statistics.ConfigStat.UrlAnnouncementDeployFromBam
+// View of a client address, empty for the local addresses
+func (e *StatisticsEngine) clientView(clientIP string) string {
+	if e.IsLocalIP(clientIP) {
+		return ""
+	}
+	return e.FindClientInView(clientIP)
 }
 
-func ReloadNamedData(isInit bool) {
-	//Read named.conf get ACL Ips Range
-	IPServerRangesInACL, IPClientRangesInACL, IPsServerInACL, IPsClientInACL, MapViewIPsInMatchClients := config_statistics.ReadACLInNamedConfig()
+// View of a query received from a client, empty for the queries sent by the name server
+func (e *StatisticsEngine) viewOfQuery(query *QueryDNS) string {
+	return e.viewOfClientQuery(query.srcIP, query.dstIP, query.recursionDesired, query.tsigKey)
+}
 
-	IpNetsServer = IPServerRangesInACL
-	IpNetsClient = IPClientRangesInACL
-	IpsServer = IPsServerInACL
-	IpsClient = IPsClientInACL
-	MapViewIPs = MapViewIPsInMatchClients
+// View of the client query which caused a recursion
+func (e *StatisticsEngine) viewOfRecursive(recursive *RecursiveDNS) string {
+	return e.viewOfClientQuery(recursive.IP, recursive.dstIP, recursive.recursionDesired, recursive.tsigKey)
+}
 
-	logp.Info("IPs Range In ACL Server: %v", IpNetsServer)
-	logp.Info("IPs Range In ACL Client: %v", IpNetsClient)
-	logp.Info("IPs In ACL Server: %v", IpsServer)
-	logp.Info("IPs In ACL Client: %v", IpsClient)
-	logp.Info("Map View Client IPs %v", MapViewIPs)
+func (e *StatisticsEngine) viewOfClientQuery(srcIP, dstIP string, recursionDesired bool, tsigKey string) string {
+	if e.IsLocalIP(srcIP) {
+		return ""
+	}
+	return e.FindView(&namedconf.ViewQuery{
+		Client:      net.ParseIP(srcIP),
+		Destination: net.ParseIP(dstIP),
+		Recursive:   recursionDesired,
+		Key:         tsigKey,
+	})
+}
 
-	if isInit == true {
-		CreateCounterMetricPerView(MapViewIPs)
+// View of the query of a transaction, from its client and server and the flags and TSIG key of its message.
+// Empty for the queries sent by the name server.
+func (e *StatisticsEngine) viewOfRecord(record *model.Record) string {
+	query := &namedconf.ViewQuery{}
+	if record.Src != nil {
+		if e.IsLocalIP(record.Src.IP) {
+			return ""
+		}
+		query.Client = net.ParseIP(record.Src.IP)
+	}
+	if record.Dst != nil {
+		query.Destination = net.ParseIP(record.Dst.IP)
+	}
+	if record.DNS != nil {
+		query.Key = record.DNS.TSIGKey
+		if record.DNS.Flags != nil {
+			query.Recursive = record.DNS.Flags.RecursionDesired
+		}
 	}
+	return e.FindView(query)
 }
 
 // Store all request messages into the corresponding map for Incoming messages and Outgoing messages
-func AddRequestMsgMap(clientIP, srvIP string, reqID uint16, questions []mkdns.Question) {
-	// mutex.Lock()
-	// defer mutex.Unlock()
-	if len(questions) > 0 && !IsInternalCall(clientIP, srvIP) {
+func (e *StatisticsEngine) AddRequestMsgMap(clientIP, srvIP string, reqID uint16, questions []mkdns.Question) {
+	if e.IsActive() && len(questions) > 0 && !e.IsInternalCall(clientIP, srvIP) {
 		for _, question := range questions {
 			var rqItem string
 			var metricType string
 			rqKey := genKeyItem(question)
-			if !IsLocalIP(clientIP) {
+			if !e.IsLocalIP(clientIP) {
 				metricType = RQ_C_MAP
 				rqItem = genValueItem(reqID, clientIP, question)
 			} else {
@@ -854,13 +872,13 @@ func AddRequestMsgMap(clientIP, srvIP string, reqID uint16, questions []mkdns.Qu
 				// Outgoing map use the same key and value
 				rqItem = rqKey
 			}
-			mutex.Lock()
+			e.reqMutex.Lock()
 			// Make sure only the first received query will be added into the map
 			// The first received client's request will be counted as recursion in case recursion happened
-			if _, exist := ReqMaps[len(ReqMaps)-1].RequestMessage[metricType][rqKey]; !exist {
-				ReqMaps[len(ReqMaps)-1].RequestMessage[metricType][rqKey] = rqItem
+			if _, exist := e.reqMaps[len(e.reqMaps)-1].RequestMessage[metricType][rqKey]; !exist {
+				e.reqMaps[len(e.reqMaps)-1].RequestMessage[metricType][rqKey] = rqItem
 			}
-			mutex.Unlock()
+			e.reqMutex.Unlock()
 		}
 	}
 }
@@ -868,17 +886,16 @@ func AddRequestMsgMap(clientIP, srvIP string, reqID uint16, questions []mkdns.Qu
 // Extract the client question from the response and find it from the Outgoing messages
 // Then find client question from the Incoming messages
 // All found the client question, increase the recursive value for the client stat, then remove out the request from the maps
-func CalculateRecursiveMsg(clientIP, srvIP string, reqID uint16, questions []mkdns.Question, dnsMsg *mkdns.Msg) {
-	// mutex.Lock()
-	// defer mutex.Unlock()
-	if len(questions) > 0 && !IsInternalCall(clientIP, srvIP) {
+// recursionDesired and tsigKey are the RD bit and the TSIG key name of the client query, choosing its view.
+func (e *StatisticsEngine) CalculateRecursiveMsg(clientIP, srvIP string, reqID uint16, questions []mkdns.Question, dnsMsg *mkdns.Msg, recursionDesired bool, tsigKey string) {
+	if e.IsActive() && len(questions) > 0 && !e.IsInternalCall(clientIP, srvIP) {
 		for _, question := range questions {
 			rqKey := genKeyItem(question)
-			if !existQuery(rqKey, rqKey, RQ_S_MAP) {
+			if !e.existQuery(rqKey, rqKey, RQ_S_MAP) {
 				continue
 			}
 			rqItem := genValueItem(reqID, clientIP, question)
-			if !existQuery(rqKey, rqItem, RQ_C_MAP) || IsLocalIP(clientIP) {
+			if !e.existQuery(rqKey, rqItem, RQ_C_MAP) || e.IsLocalIP(clientIP) {
 				continue
 			}
 			isSuccess := false
@@ -886,14 +903,14 @@ func CalculateRecursiveMsg(clientIP, srvIP string, reqID uint16, questions []mkd
 			if (dnsMsg.MsgHdr.Rcode == 0 && len(dnsMsg.Answer) > 0) || dnsMsg.MsgHdr.Truncated {
 				isSuccess = true
 			}
-			recursiveDNS := NewRecursiveDNS(clientIP, isSuccess)
-			QStatDNS.PushRecursiveDNS(recursiveDNS)
-			mutex.Lock()
-			for _, reqMap := range ReqMaps {
+			recursiveDNS := NewRecursiveDNS(clientIP, isSuccess).WithViewSelection(srvIP, recursionDesired, tsigKey)
+			e.PushRecursiveDNS(recursiveDNS)
+			e.reqMutex.Lock()
+			for _, reqMap := range e.reqMaps {
 				delete(reqMap.RequestMessage[RQ_S_MAP], rqKey)
 				delete(reqMap.RequestMessage[RQ_C_MAP], rqKey)
 			}
-			mutex.Unlock()
+			e.reqMutex.Unlock()
 		}
 	}
 }
@@ -906,9 +923,11 @@ func genKeyItem(question mkdns.Question) string {
 	return fmt.Sprintf("%s %d %d", question.Name, question.Qtype, question.Qclass)
 }
 
-func existQuery(rqKey, rqItem, metricType string) bool {
+func (e *StatisticsEngine) existQuery(rqKey, rqItem, metricType string) bool {
+	e.reqMutex.Lock()
+	defer e.reqMutex.Unlock()
 	existing := false
-	for _, reqMap := range ReqMaps {
+	for _, reqMap := range e.reqMaps {
 		if value, exist := reqMap.RequestMessage[metricType][rqKey]; exist {
 			existing = value == rqItem
 			if existing {
@@ -919,26 +938,30 @@ func existQuery(rqKey, rqItem, metricType string) bool {
 	return existing
 }
 
-func HandleRequestDecodeErr(clientIP, srvIP string) {
-	if !IsInternalCall(clientIP, srvIP) {
-		if statIP := CreateCounterMetric(srvIP, clientIP, QUERY); statIP != "" {
-			IncrDNSStatsTotalQueries(statIP)
-			IncrDNSStatsTotalQueriesForPerView(statIP)
+func (s *StatisticsService) HandleRequestDecodeErr(clientIP, srvIP string) {
+	if !s.engine.IsInternalCall(clientIP, srvIP) {
+		if statIP := s.CreateCounterMetric(srvIP, clientIP, QUERY); statIP != "" {
+			// The message couldn't be decoded, its view is the one of its client alone
+			viewIP, _ := s.engine.CheckMetricType(srvIP, clientIP, QUERY)
+			s.IncrDNSStatsTotalQueries(statIP)
+			s.IncrDNSStatsTotalQueriesForPerView(s.engine.clientView(viewIP))
 		}
 	}
 }
 
-func HandleResponseDecodeErr(clientIP, srvIP string, RCodeString string) {
-	if !IsInternalCall(clientIP, srvIP) {
-		if statIP := CreateCounterMetric(srvIP, clientIP, RESPONSE); statIP != "" {
-			IncrDNSStatsTotalResponses(statIP)
-			ResponseForPerView(statIP)
+func (s *StatisticsService) HandleResponseDecodeErr(clientIP, srvIP string, RCodeString string) {
+	if !s.engine.IsInternalCall(clientIP, srvIP) {
+		if statIP := s.CreateCounterMetric(srvIP, clientIP, RESPONSE); statIP != "" {
+			viewIP, _ := s.engine.CheckMetricType(srvIP, clientIP, RESPONSE)
+			viewName := s.engine.clientView(viewIP)
+			s.IncrDNSStatsTotalResponses(statIP)
+			s.ResponseForPerView(viewName)
 			if RCodeString == FORMERR {
-				IncrDNSStatsFormatError(statIP)
-				IncrDNSStatsFormatErrorForPerView(statIP, CLIENT)
+				s.IncrDNSStatsFormatError(statIP)
+				s.IncrDNSStatsFormatErrorForPerView(viewName, CLIENT)
 			} else {
-				IncrDNSStatsOtherRCode(statIP)
-				IncrDNSStatsOtherRCodeForPerView(statIP, CLIENT)
+				s.IncrDNSStatsOtherRCode(statIP)
+				s.IncrDNSStatsOtherRCodeForPerView(viewName, CLIENT)
 			}
 		}
 	}
@@ -0,0 +1,53 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+func TestNewStatsKeyFoldsClientsBeyondMaximum(t *testing.T) {
+	config := config_statistics.DefaultConfigStat
+	config.MaximumClients = 2
+	_, clients, _ := net.ParseCIDR("10.0.0.0/8")
+	e := NewStatisticsEngine(config)
+	e.named.Store(&namedData{ipNetsClient: []*net.IPNet{clients}})
+	stats := e.newStatisticsService()
+
+	assert.Equal(t, "10.0.0.1", stats.newStatsKey("10.0.0.1", CLIENT))
+	assert.Equal(t, "10.0.0.2", stats.newStatsKey("10.0.0.2", CLIENT))
+	assert.Equal(t, OTHER_CLIENTS, stats.newStatsKey("10.0.0.3", CLIENT))
+	assert.Equal(t, OTHER_CLIENTS, stats.newStatsKey("10.0.0.4", CLIENT))
+	assert.Equal(t, OTHER_CLIENTS, stats.newStatsKey("10.0.0.4", CLIENT))
+	// Known clients keep their entry
+	assert.Equal(t, "10.0.0.1", stats.newStatsKey("10.0.0.1", CLIENT))
+	// Outside of the ACL
+	assert.Equal(t, "", stats.newStatsKey("192.0.2.1", CLIENT))
+
+	assert.Len(t, stats.StatsMap, 3)
+	assert.Equal(t, CLIENT, stats.StatsMap[OTHER_CLIENTS].Type)
+	assert.Equal(t, int64(2), stats.foldedClients.Count())
+	assert.Equal(t, int64(0), stats.foldedServers.Count())
+
+	stats.IncrDNSStatsDuplicated("10.0.0.5")
+	assert.Equal(t, int64(1), stats.StatsMap[OTHER_CLIENTS].DNSMetrics.Duplicated)
+}
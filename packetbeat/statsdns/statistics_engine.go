@@ -0,0 +1,430 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/agentx"
+	"github.com/elastic/beats/packetbeat/config_statistics"
+	"github.com/elastic/beats/packetbeat/model"
+	"github.com/elastic/beats/packetbeat/namedconf"
+	"github.com/elastic/beats/packetbeat/outstats"
+	"github.com/elastic/beats/packetbeat/querylog"
+)
+
+type (
+	// DNS statistics engine.
+	// The counters are sharded by client: each shard owns its interval statistics and updates them
+	// from its own goroutine without lock. At the end of the interval every shard swaps its double buffer
+	// and the engine merges the finished buffers into the exported statistics.
+	StatisticsEngine struct {
+		config            config_statistics.ConfigStatistics
+		interval          time.Duration
+		queryTypesAllowed map[string]bool
+		zones             []string
+		shards            []*QueueStatDNS
+
+		// *namedData and []net.Addr, replaced as a whole when they are reloaded
+		named      atomic.Value
+		localAddrs atomic.Value
+
+		// Query maps for recursion counting
+		reqMutex sync.Mutex
+		reqMaps  []*RequestMap
+
+		// Start of the current interval and the last completed intervals
+		intervalMutex sync.RWMutex
+		intervalStart time.Time
+		history       *IntervalHistory
+		// Number of messages pushed to the shards, per kind
+		events engineEvents
+
+		// Exporters added before Start, next to the ones of the configuration
+		extraExporters []outstats.Exporter
+
+		exporters  *outstats.Exporters
+		mib        *bcnMIB
+		subAgent   *agentx.SubAgent
+		queryLog   *querylog.QueryLog
+		mux        *http.ServeMux
+		httpServer *http.Server
+		isActive   int32
+		done       chan struct{}
+	}
+
+	engineEvents struct {
+		queries    int64
+		records    int64
+		recursives int64
+		timeouts   int64
+		orphans    int64
+		decodeErrs int64
+		intervals  int64
+	}
+
+	// ACLs and views read from named.conf
+	namedData struct {
+		ipNetsClient []*net.IPNet
+		ipNetsServer []*net.IPNet
+		ipsClient    []string
+		ipsServer    []string
+		mapViewIPs   map[int]map[string][]string
+		views        *namedconf.ViewMatcher
+	}
+)
+
+// Read statistics_config.json and create the engine
+func InitStatisticsDNS() *StatisticsEngine {
+	logp.Info("GetConfigDNSStatistics")
+	config_statistics.Init()
+	return NewStatisticsEngine(config_statistics.ConfigStat)
+}
+
+func NewStatisticsEngine(config config_statistics.ConfigStatistics) *StatisticsEngine {
+	e := &StatisticsEngine{
+		config:            config,
+		interval:          config.StatisticsInterval * time.Second,
+		queryTypesAllowed: makeQueryTypesAllowed(DefaultQueryTypes),
+		zones:             makeZones(config.Zones),
+		history:           NewIntervalHistory(config.HistorySize),
+		mux:               http.NewServeMux(),
+		done:              make(chan struct{}),
+	}
+	if len(config.QueryTypes) > 0 {
+		e.queryTypesAllowed = makeQueryTypesAllowed(config.QueryTypes)
+	}
+	e.named.Store(&namedData{mapViewIPs: make(map[int]map[string][]string)})
+	e.localAddrs.Store([]net.Addr{})
+
+	numberShards := config.Shards
+	if numberShards <= 0 {
+		numberShards = runtime.NumCPU()
+	}
+	e.shards = make([]*QueueStatDNS, numberShards)
+	for i := range e.shards {
+		e.shards[i] = NewQueueStatDNS(e)
+	}
+	e.rotateReqMaps()
+	e.newSubAgent(config.AgentX)
+	e.newQueryLog(config.QueryLog)
+	return e
+}
+
+// Start counting: load named.conf, start the shards, the HTTP server and the interval loop
+func (e *StatisticsEngine) Start() {
+	e.ReloadNamedData()
+	addrs, _ := net.InterfaceAddrs()
+	e.localAddrs.Store(addrs)
+	e.intervalMutex.Lock()
+	e.intervalStart = time.Now()
+	e.intervalMutex.Unlock()
+	atomic.StoreInt32(&e.isActive, 1)
+	for _, shard := range e.shards {
+		go shard.PopStatDNS()
+	}
+	e.exporters = outstats.NewExporters(e.config, e.extraExporters...)
+	if e.subAgent != nil {
+		go e.subAgent.Run()
+	}
+	go e.onLoadHTTPServer()
+	go e.watchLocalAddrs()
+	go e.run()
+}
+
+// Add an exporter of the intervals which isn't configured in statistics_config.json, before Start
+func (e *StatisticsEngine) AddExporter(exporter outstats.Exporter) {
+	e.extraExporters = append(e.extraExporters, exporter)
+}
+
+func (e *StatisticsEngine) Stop() {
+	if e == nil || !atomic.CompareAndSwapInt32(&e.isActive, 1, 0) {
+		return
+	}
+	logp.Info("StatisticsEngine Stop")
+	close(e.done)
+	e.exporters.Close()
+	e.subAgent.Close()
+	e.queryLog.Close()
+}
+
+func (e *StatisticsEngine) IsActive() bool {
+	return e != nil && atomic.LoadInt32(&e.isActive) == 1
+}
+
+func (e *StatisticsEngine) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case timeEnd := <-ticker.C:
+			stats := e.swapIntervals()
+			stats.Start = timeEnd.Add(-e.interval)
+			stats.End = timeEnd
+			e.intervalMutex.Lock()
+			e.intervalStart = timeEnd
+			e.intervalMutex.Unlock()
+			e.history.Add(stats)
+			e.updateMIB(stats)
+			atomic.AddInt64(&e.events.intervals, 1)
+			b, err := json.Marshal(stats)
+			if err != nil {
+				logp.Error(err)
+				continue
+			}
+			logp.Info("DNS_Statistics: %s", b)
+			e.exporters.Publish(outstats.Interval{Start: stats.Start, End: stats.End, Data: b})
+		}
+	}
+}
+
+// Swap the interval of every shard and merge the finished intervals
+func (e *StatisticsEngine) swapIntervals() *StatisticsService {
+	e.rotateReqMaps()
+	stats := e.newStatisticsService()
+	stats.CreateCounterMetricPerView(e.namedData().mapViewIPs)
+	for _, shard := range e.shards {
+		stats.Merge(shard.swapInterval())
+	}
+	stats.limitClients()
+	stats.limitZones()
+	return stats
+}
+
+// Return a copy of the statistics of the current interval, merged from all the shards
+func (e *StatisticsEngine) CurrentInterval() *StatisticsService {
+	stats := e.newStatisticsService()
+	stats.CreateCounterMetricPerView(e.namedData().mapViewIPs)
+	for _, shard := range e.shards {
+		stats.Merge(shard.snapshotInterval())
+	}
+	stats.limitClients()
+	stats.limitZones()
+	e.intervalMutex.RLock()
+	stats.Start = e.intervalStart
+	e.intervalMutex.RUnlock()
+	stats.End = time.Now()
+	return stats
+}
+
+// Return the statistics of the last completed interval, nil before the end of the first interval.
+// They aren't changed anymore.
+func (e *StatisticsEngine) LastInterval() *StatisticsService {
+	return e.history.Get(0)
+}
+
+// Return the n last completed intervals, the last one first
+func (e *StatisticsEngine) LastIntervals(n int) []*StatisticsService {
+	return e.history.Last(n)
+}
+
+// Create the statistics of one interval, of a shard or merged from all the shards. Every shard keeps the whole
+// maximumClients and maximumZones, the clients, servers and zones are limited again once merged.
+func (e *StatisticsEngine) newStatisticsService() *StatisticsService {
+	stats := &StatisticsService{
+		StatsMap:       make(map[string]*StatisticsDNS),
+		TopNames:       NewTopNames(e.config.TopNames),
+		Distinct:       NewDistinctCounters(),
+		engine:         e,
+		maximumClients: e.config.MaximumClients,
+		maximumZones:   e.config.MaximumZones,
+	}
+	return stats
+}
+
+// Return the shard counting the traffic of an IP address
+func (e *StatisticsEngine) shard(ip string) *QueueStatDNS {
+	if len(e.shards) == 1 {
+		return e.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(ip))
+	return e.shards[h.Sum32()%uint32(len(e.shards))]
+}
+
+// Return the shard of the client or AS of a message: the address which isn't local
+func (e *StatisticsEngine) shardOf(srcIp string, dstIp string) *QueueStatDNS {
+	if e.IsLocalIP(srcIp) {
+		return e.shard(dstIp)
+	}
+	return e.shard(srcIp)
+}
+
+func (e *StatisticsEngine) PushQueryDNS(queryDNS *QueryDNS) {
+	if e.IsActive() {
+		atomic.AddInt64(&e.events.queries, 1)
+		e.shardOf(queryDNS.srcIP, queryDNS.dstIP).PushQueryDNS(queryDNS)
+	}
+}
+
+func (e *StatisticsEngine) PushRecordDNS(record *model.Record) {
+	if e.IsActive() {
+		atomic.AddInt64(&e.events.records, 1)
+		e.shardOf(record.Src.IP, record.Dst.IP).PushRecordDNS(record)
+		e.queryLog.Log(record)
+	}
+}
+
+// Log a transaction without response (expired or replaced by a duplicate query), the statistics count it
+// from its query. Its DNS fields come from the query, whose rcode isn't logged.
+func (e *StatisticsEngine) LogUnansweredDNS(record *model.Record) {
+	if e.IsActive() {
+		logged := *record
+		if record.DNS != nil {
+			dns := *record.DNS
+			dns.ResponseCode = ""
+			logged.DNS = &dns
+		}
+		e.queryLog.Log(&logged)
+	}
+}
+
+func (e *StatisticsEngine) PushRecursiveDNS(recursiveDNS *RecursiveDNS) {
+	if e.IsActive() {
+		atomic.AddInt64(&e.events.recursives, 1)
+		e.shard(recursiveDNS.IP).PushRecursiveDNS(recursiveDNS)
+	}
+}
+
+// Query which has expired without any response
+func (e *StatisticsEngine) PushTimeoutDNS(queryDNS *QueryDNS) {
+	if e.IsActive() {
+		atomic.AddInt64(&e.events.timeouts, 1)
+		e.shardOf(queryDNS.srcIP, queryDNS.dstIP).PushTimeoutDNS(queryDNS)
+	}
+}
+
+// Response which doesn't match any query
+func (e *StatisticsEngine) PushOrphanedDNS(queryDNS *QueryDNS) {
+	if e.IsActive() {
+		atomic.AddInt64(&e.events.orphans, 1)
+		e.shardOf(queryDNS.srcIP, queryDNS.dstIP).PushOrphanedDNS(queryDNS)
+	}
+}
+
+// Message which couldn't be decoded
+func (e *StatisticsEngine) HandleRequestDecodeErr(clientIP, srvIP string) {
+	if e.IsActive() {
+		atomic.AddInt64(&e.events.decodeErrs, 1)
+		e.shardOf(clientIP, srvIP).PushDecodeErrDNS(&DecodeErrDNS{clientIP: clientIP, srvIP: srvIP})
+	}
+}
+
+func (e *StatisticsEngine) HandleResponseDecodeErr(clientIP, srvIP string, RCodeString string) {
+	if e.IsActive() {
+		atomic.AddInt64(&e.events.decodeErrs, 1)
+		e.shardOf(clientIP, srvIP).PushDecodeErrDNS(&DecodeErrDNS{clientIP: clientIP, srvIP: srvIP, isResponse: true, rcode: RCodeString})
+	}
+}
+
+// Check if the IP Address is the local IP Address
+func (e *StatisticsEngine) IsLocalIP(ip string) bool {
+	for _, addr := range e.localAddrs.Load().([]net.Addr) {
+		if strings.SplitN(addr.String(), "/", 2)[0] == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if both of source and destination IP Address are the local IP Address
+func (e *StatisticsEngine) IsInternalCall(srcIp string, dstIp string) bool {
+	if e == nil {
+		return false
+	}
+	return e.IsLocalIP(srcIp) && e.IsLocalIP(dstIp)
+}
+
+func (e *StatisticsEngine) namedData() *namedData {
+	return e.named.Load().(*namedData)
+}
+
+// Reload the ACL client, server and the views from named.conf
+func (e *StatisticsEngine) ReloadNamedData() {
+	//Read named.conf get ACL Ips Range
+	IPServerRangesInACL, IPClientRangesInACL, IPsServerInACL, IPsClientInACL, MapViewIPsInMatchClients, views := config_statistics.ReadACLInNamedConfig()
+	named := &namedData{
+		ipNetsServer: IPServerRangesInACL,
+		ipNetsClient: IPClientRangesInACL,
+		ipsServer:    IPsServerInACL,
+		ipsClient:    IPsClientInACL,
+		mapViewIPs:   MapViewIPsInMatchClients,
+		views:        views,
+	}
+	e.named.Store(named)
+
+	logp.Info("IPs Range In ACL Server: %v", named.ipNetsServer)
+	logp.Info("IPs Range In ACL Client: %v", named.ipNetsClient)
+	logp.Info("IPs In ACL Server: %v", named.ipsServer)
+	logp.Info("IPs In ACL Client: %v", named.ipsClient)
+	logp.Info("Map View Client IPs %v", named.mapViewIPs)
+	e.resetMIB()
+}
+
+// Check anycast service: reload the local addresses when the quagga daemons are changed
+func (e *StatisticsEngine) watchLocalAddrs() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logp.Error(err)
+		return
+	}
+	defer watcher.Close()
+
+	err = watcher.Add(DAEMONS_PATH)
+	if err != nil {
+		logp.Error(err)
+	}
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Write == fsnotify.Write {
+				localAddrs := e.localAddrs.Load().([]net.Addr)
+				for number := 1; number <= 60; number++ {
+					currentLocalAddrs, _ := net.InterfaceAddrs()
+					if !Equal(currentLocalAddrs, localAddrs) {
+						logp.Info("Local Addresses: %v ", currentLocalAddrs)
+						e.localAddrs.Store(currentLocalAddrs)
+						break
+					}
+					time.Sleep(1 * time.Second)
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logp.Error(err)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	mkdns "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/config_statistics"
+	"github.com/elastic/beats/packetbeat/model"
+	"github.com/elastic/beats/packetbeat/namedconf"
+)
+
+// Views choosing the queries signed with xfr-key, then the queries of 10.0.0.0/24
+func newTestViews() *namedconf.ViewMatcher {
+	config, err := namedconf.Parse("named.conf", `
+view "signed" { match-clients { key "xfr-key"; }; };
+view "internal" { match-clients { 10.0.0.0/24; }; };
+`)
+	if err != nil {
+		panic(err)
+	}
+	views, err := namedconf.CompileViews(config, namedconf.NewHostAddresses(nil))
+	if err != nil {
+		panic(err)
+	}
+	return views
+}
+
+// Engine counting the clients of 10.0.0.0/8 for the local address 192.0.2.53, without named.conf nor HTTP server
+func newTestStatisticsEngine(shards int) *StatisticsEngine {
+	config := config_statistics.DefaultConfigStat
+	config.Shards = shards
+	return newTestStatisticsEngineConfig(config)
+}
+
+func newTestStatisticsEngineConfig(config config_statistics.ConfigStatistics) *StatisticsEngine {
+	e := NewStatisticsEngine(config)
+	_, clients, _ := net.ParseCIDR("10.0.0.0/8")
+	e.named.Store(&namedData{ipNetsClient: []*net.IPNet{clients}, mapViewIPs: map[int]map[string][]string{
+		0: {"signed": {}},
+		1: {"internal": {"10.0.0.0/24"}},
+	}, views: newTestViews()})
+	e.localAddrs.Store([]net.Addr{&net.IPNet{IP: net.ParseIP("192.0.2.53"), Mask: net.CIDRMask(32, 32)}})
+	atomic.StoreInt32(&e.isActive, 1)
+	for _, shard := range e.shards {
+		go shard.PopStatDNS()
+	}
+	return e
+}
+
+func TestStatisticsEngineShardsClients(t *testing.T) {
+	e := newTestStatisticsEngine(4)
+	defer e.Stop()
+
+	assert.Len(t, e.shards, 4)
+	assert.Equal(t, e.shard("10.0.0.1"), e.shardOf("10.0.0.1", "192.0.2.53"))
+	assert.Equal(t, e.shard("10.0.0.1"), e.shardOf("192.0.2.53", "10.0.0.1"))
+
+	for i := 1; i <= 20; i++ {
+		e.PushQueryDNS(NewQueryDNS(fmt.Sprintf("10.0.0.%d", i), "192.0.2.53", "www.example.com.", false))
+	}
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "www.example.com.", true))
+
+	stats := e.swapIntervals()
+	assert.Len(t, stats.StatsMap, 20+2+1)
+	assert.Equal(t, int64(2), stats.StatsMap["10.0.0.1"].DNSMetrics.TotalQueries)
+	assert.Equal(t, int64(1), stats.StatsMap["10.0.0.1"].DNSMetrics.Duplicated)
+	assert.Equal(t, int64(1), stats.StatsMap["10.0.0.20"].DNSMetrics.TotalQueries)
+	assert.Equal(t, VIEW, stats.StatsMap["internal"].Type)
+	assert.Equal(t, int64(21), stats.StatsMap["internal"].DNSMetrics.TotalQueries)
+	assert.Equal(t, int64(21), stats.StatsMap["example.com."].DNSMetrics.TotalQueries)
+	assert.Equal(t, int64(20), stats.Distinct.clients.Count())
+}
+
+func TestStatisticsEngineViewSelection(t *testing.T) {
+	e := newTestStatisticsEngine(1)
+	defer e.Stop()
+
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "example.com.", false).WithViewSelection(false, "XFR-KEY."))
+	e.PushQueryDNS(NewQueryDNS("10.0.0.2", "192.0.2.53", "example.com.", true).WithViewSelection(true, ""))
+	e.PushQueryDNS(NewQueryDNS("10.0.1.1", "192.0.2.53", "example.com.", false).WithViewSelection(true, "xfr-key"))
+	e.PushQueryDNS(NewQueryDNS("10.0.1.2", "192.0.2.53", "example.com.", false))
+	e.PushTimeoutDNS(NewQueryDNS("10.0.1.1", "192.0.2.53", "example.com.", false).WithViewSelection(true, "xfr-key"))
+	e.PushRecordDNS(&model.Record{
+		Status: common.OK_STATUS,
+		Src:    &common.Endpoint{IP: "10.0.0.1"},
+		Dst:    &common.Endpoint{IP: "192.0.2.53"},
+		DNS:    &model.DNS{ResponseCode: NOERROR, AnswersCount: 1, TSIGKey: "xfr-key", Flags: &model.Flags{}},
+	})
+
+	// The signed queries go to the view of their key before the view of their client
+	stats := e.swapIntervals()
+	signed := stats.StatsMap["signed"].DNSMetrics
+	assert.Equal(t, int64(2), signed.TotalQueries)
+	assert.Equal(t, int64(1), signed.Timeouts)
+	assert.Equal(t, int64(1), signed.TotalResponses)
+	assert.Equal(t, int64(1), signed.Successful)
+	internal := stats.StatsMap["internal"].DNSMetrics
+	assert.Equal(t, int64(1), internal.TotalQueries)
+	assert.Equal(t, int64(1), internal.Duplicated)
+	assert.Equal(t, int64(0), internal.TotalResponses)
+}
+
+func TestStatisticsEngineRecursiveView(t *testing.T) {
+	e := newTestStatisticsEngine(1)
+	defer e.Stop()
+
+	questions := []mkdns.Question{{Name: "www.example.com.", Qtype: mkdns.TypeA, Qclass: mkdns.ClassINET}}
+	response := &mkdns.Msg{Answer: []mkdns.RR{&mkdns.A{Hdr: mkdns.RR_Header{Name: "www.example.com.", Rrtype: mkdns.TypeA}}}}
+	e.AddRequestMsgMap("10.0.0.1", "192.0.2.53", 1, questions)
+	e.AddRequestMsgMap("192.0.2.53", "198.51.100.1", 2, questions)
+	e.CalculateRecursiveMsg("10.0.0.1", "192.0.2.53", 1, questions, response, true, "xfr-key")
+
+	// The recursion of a signed query is counted in the view of its key like the query
+	stats := e.swapIntervals()
+	assert.Equal(t, int64(1), stats.StatsMap["signed"].DNSMetrics.Recursive)
+	assert.Equal(t, int64(1), stats.StatsMap["signed"].DNSMetrics.SuccessfulRecursive)
+	assert.Equal(t, int64(0), stats.StatsMap["internal"].DNSMetrics.Recursive)
+}
+
+func TestStatisticsEngineReloadNamedData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namedconf")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "named.conf")
+	err = ioutil.WriteFile(path, []byte(`
+acl "_TrafficStatisticsAgent_Clients" { 10.0.0.0/8; };
+view "added" { match-clients { 10.0.0.0/24; }; };
+`), 0644)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func(path, root string) {
+		config_statistics.NAMED_CONFIG_PATH, config_statistics.NAMED_ROOT_PATH = path, root
+	}(config_statistics.NAMED_CONFIG_PATH, config_statistics.NAMED_ROOT_PATH)
+	config_statistics.NAMED_CONFIG_PATH, config_statistics.NAMED_ROOT_PATH = path, dir
+
+	e := newTestStatisticsEngine(2)
+	defer e.Stop()
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "", false))
+
+	// The views added by the reload are counted from the current interval
+	e.ReloadNamedData()
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "", true))
+	e.PushRecursiveDNS(NewRecursiveDNS("10.0.0.1", true))
+	e.PushTimeoutDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "", false))
+
+	stats := e.swapIntervals()
+	if assert.Contains(t, stats.StatsMap, "added") {
+		added := stats.StatsMap["added"].DNSMetrics
+		assert.Equal(t, VIEW, stats.StatsMap["added"].Type)
+		assert.Equal(t, int64(1), added.TotalQueries)
+		assert.Equal(t, int64(1), added.Duplicated)
+		assert.Equal(t, int64(1), added.Recursive)
+		assert.Equal(t, int64(1), added.SuccessfulRecursive)
+		assert.Equal(t, int64(1), added.Timeouts)
+	}
+	assert.Equal(t, int64(2), stats.StatsMap["10.0.0.1"].DNSMetrics.TotalQueries)
+}
+
+func TestStatisticsEngineDoubleBuffer(t *testing.T) {
+	e := newTestStatisticsEngine(2)
+	defer e.Stop()
+
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "", false))
+	first := e.swapIntervals()
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "", false))
+	e.PushQueryDNS(NewQueryDNS("10.0.0.2", "192.0.2.53", "", false))
+	second := e.swapIntervals()
+
+	// The finished interval isn't changed by the next one
+	assert.Equal(t, int64(1), first.StatsMap["10.0.0.1"].DNSMetrics.TotalQueries)
+	assert.NotContains(t, first.StatsMap, "10.0.0.2")
+	assert.Equal(t, int64(1), second.StatsMap["10.0.0.1"].DNSMetrics.TotalQueries)
+	assert.Equal(t, int64(1), second.StatsMap["10.0.0.2"].DNSMetrics.TotalQueries)
+}
+
+func TestStatisticsEngineStop(t *testing.T) {
+	e := newTestStatisticsEngine(1)
+	e.Stop()
+
+	assert.False(t, e.IsActive())
+	// Nothing is counted once stopped and the push doesn't block
+	e.PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "", false))
+	e.shards[0].PushQueryDNS(NewQueryDNS("10.0.0.1", "192.0.2.53", "", false))
+	assert.Len(t, e.swapIntervals().StatsMap, 2)
+
+	var stopped *StatisticsEngine
+	assert.False(t, stopped.IsActive())
+	assert.False(t, stopped.IsInternalCall("192.0.2.53", "192.0.2.53"))
+}
+
+func TestStatisticsEngineQueryLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queries.log")
+
+	e := newTestStatisticsEngine(1)
+	e.newQueryLog(config_statistics.QueryLogConfig{Enabled: true, Path: path, Views: []string{"internal", "signed"}})
+	for _, client := range []string{"10.0.0.1", "10.0.1.1"} {
+		e.PushRecordDNS(&model.Record{
+			Src: &common.Endpoint{IP: client, Port: 53000},
+			Dst: &common.Endpoint{IP: "192.0.2.53", Port: 53},
+			DNS: &model.DNS{Flags: &model.Flags{}, ResponseCode: "NOERROR"},
+		})
+	}
+	// The query of the name server has no view, even signed with the key of a view
+	server := &model.Record{
+		Src: &common.Endpoint{IP: "192.0.2.53", Port: 53000},
+		Dst: &common.Endpoint{IP: "10.0.0.2", Port: 53},
+		DNS: &model.DNS{Flags: &model.Flags{}, ResponseCode: "NOERROR", TSIGKey: "xfr-key"},
+	}
+	assert.Equal(t, "", e.viewOfRecord(server))
+	e.PushRecordDNS(server)
+	e.Stop()
+
+	// Only the client of the internal view is logged
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 1) {
+		assert.Contains(t, lines[0], `"client":"10.0.0.1"`)
+		assert.Contains(t, lines[0], `"view":"internal"`)
+	}
+}
+
+func TestStatisticsEngineQueryLogUnanswered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queries.log")
+
+	e := newTestStatisticsEngine(1)
+	e.newQueryLog(config_statistics.QueryLogConfig{Enabled: true, Path: path})
+	// Expired query, its DNS fields come from the query message
+	expired := &model.Record{
+		Src:     &common.Endpoint{IP: "10.0.0.1", Port: 53000},
+		Dst:     &common.Endpoint{IP: "192.0.2.53", Port: 53},
+		Status:  common.ERROR_STATUS,
+		Notes:   "no response",
+		BytesIn: 40,
+		DNS: &model.DNS{Flags: &model.Flags{}, ResponseCode: "NOERROR",
+			Question: &model.Question{Name: "www.example.com.", Type: "A", Class: "IN"}},
+	}
+	e.LogUnansweredDNS(expired)
+	e.Stop()
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 1) {
+		assert.Contains(t, lines[0], `"client":"10.0.0.1"`)
+		assert.Contains(t, lines[0], `"view":"internal"`)
+		assert.Contains(t, lines[0], `"qname":"www.example.com."`)
+		assert.Contains(t, lines[0], `"status":"Error"`)
+		assert.Contains(t, lines[0], `"notes":"no response"`)
+		assert.NotContains(t, lines[0], `"rcode"`)
+	}
+	// The record published elsewhere isn't changed
+	assert.Equal(t, "NOERROR", expired.DNS.ResponseCode)
+}
+
+func TestStatisticsEngineMaximumClients(t *testing.T) {
+	config := config_statistics.DefaultConfigStat
+	config.Shards = 4
+	config.MaximumClients = 4
+	e := newTestStatisticsEngineConfig(config)
+	defer e.Stop()
+
+	// Clients hashed to the same shard have their own entry while the total is under maximum_clients
+	clients := []string{}
+	shard := e.shard("10.0.0.1")
+	for i := 1; len(clients) < config.MaximumClients; i++ {
+		if client := fmt.Sprintf("10.0.0.%d", i); e.shard(client) == shard {
+			clients = append(clients, client)
+		}
+	}
+	for _, client := range clients {
+		e.PushQueryDNS(NewQueryDNS(client, "192.0.2.53", "www.example.com.", false))
+	}
+	stats := e.swapIntervals()
+	for _, client := range clients {
+		assert.Contains(t, stats.StatsMap, client)
+	}
+	assert.NotContains(t, stats.StatsMap, OTHER_CLIENTS)
+	assert.Equal(t, int64(0), stats.FoldedClients)
+}
+
+func TestStatisticsServiceLimitClients(t *testing.T) {
+	config := config_statistics.DefaultConfigStat
+	config.MaximumClients = 2
+	e := newTestStatisticsEngineConfig(config)
+	defer e.Stop()
+	first, second := e.newStatisticsService(), e.newStatisticsService()
+	for _, client := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.2", "10.0.0.2"} {
+		first.IncreaseQueryCounter(client, "192.0.2.53", QUERY)
+	}
+	for _, client := range []string{"10.0.1.1", "10.0.1.1", "10.0.1.2"} {
+		second.IncreaseQueryCounter(client, "192.0.2.53", QUERY)
+	}
+
+	// The clients with the most queries of all the shards are kept, the others are folded
+	merged := e.newStatisticsService()
+	merged.Merge(first)
+	merged.Merge(second)
+	merged.limitClients()
+	assert.Len(t, merged.StatsMap, 3)
+	assert.Contains(t, merged.StatsMap, "10.0.0.2")
+	assert.Contains(t, merged.StatsMap, "10.0.1.1")
+	if assert.Contains(t, merged.StatsMap, OTHER_CLIENTS) {
+		assert.Equal(t, int64(2), merged.StatsMap[OTHER_CLIENTS].DNSMetrics.TotalQueries)
+	}
+	assert.Equal(t, int64(2), merged.FoldedClients)
+}
+
+func TestStatisticsEngineMaximumZones(t *testing.T) {
+	config := config_statistics.DefaultConfigStat
+	config.Shards = 4
+	config.MaximumZones = 4
+	config.Zones = []string{"a.example", "b.example", "c.example", "d.example"}
+	e := newTestStatisticsEngineConfig(config)
+	defer e.Stop()
+
+	// Every shard sees every zone, the zones aren't split between them
+	for i := 1; i <= 20; i++ {
+		zone := config.Zones[i%len(config.Zones)]
+		e.PushQueryDNS(NewQueryDNS(fmt.Sprintf("10.0.0.%d", i), "192.0.2.53", "www."+zone+".", false))
+	}
+	stats := e.swapIntervals()
+	for _, zone := range config.Zones {
+		if assert.Contains(t, stats.StatsMap, zone+".") {
+			assert.Equal(t, int64(5), stats.StatsMap[zone+"."].DNSMetrics.TotalQueries)
+		}
+	}
+}
+
+func TestStatisticsServiceLimitZones(t *testing.T) {
+	config := config_statistics.DefaultConfigStat
+	config.MaximumZones = 3
+	e := newTestStatisticsEngineConfig(config)
+	defer e.Stop()
+	first, second := e.newStatisticsService(), e.newStatisticsService()
+	for zone, queries := range map[string]int{"a.example.": 1, "b.example.": 3, "c.example.": 2} {
+		for i := 0; i < queries; i++ {
+			first.IncreaseQueryCounterForPerZone("10.0.0.1", "192.0.2.53", "www."+zone)
+		}
+	}
+	for _, queryName := range []string{"www.a.example.", "www.a.example.", "www.d.example."} {
+		second.IncreaseQueryCounterForPerZone("10.0.0.2", "192.0.2.53", queryName)
+	}
+
+	// The zones with the most queries of all the shards are kept
+	merged := e.newStatisticsService()
+	merged.Merge(first)
+	merged.Merge(second)
+	merged.limitZones()
+	assert.Len(t, merged.StatsMap, 3)
+	assert.NotContains(t, merged.StatsMap, "d.example.")
+	assert.Equal(t, int64(3), merged.StatsMap["a.example."].DNSMetrics.TotalQueries)
+}
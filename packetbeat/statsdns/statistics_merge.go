@@ -0,0 +1,138 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+// Add the statistics of a finished shard interval into s
+func (s *StatisticsService) Merge(other *StatisticsService) {
+	if other == nil {
+		return
+	}
+	for key, otherStats := range other.StatsMap {
+		stats, exist := s.StatsMap[key]
+		if !exist {
+			stats = newStatisticsDNS(otherStats.Type)
+			s.StatsMap[key] = stats
+			switch otherStats.Type {
+			case CLIENT:
+				s.clientCount++
+			case AUTHSERVER:
+				s.serverCount++
+			case ZONE:
+				s.zoneCount++
+			}
+		}
+		stats.DNSMetrics.Merge(otherStats.DNSMetrics)
+	}
+	s.TopNames.Merge(other.TopNames)
+	s.Distinct.Merge(other.Distinct)
+	s.foldedClients = mergeHyperLogLog(s.foldedClients, other.foldedClients)
+	s.foldedServers = mergeHyperLogLog(s.foldedServers, other.foldedServers)
+	s.FoldedClients = s.foldedClients.Count()
+	s.FoldedServers = s.foldedServers.Count()
+}
+
+// Add the counters of other into m
+func (m *DNSMetrics) Merge(other *DNSMetrics) {
+	m.TotalQueries += other.TotalQueries
+	m.TotalResponses += other.TotalResponses
+	m.Recursive += other.Recursive
+	m.SuccessfulRecursive += other.SuccessfulRecursive
+	m.SuccessfulNoAuthAns += other.SuccessfulNoAuthAns
+	m.SuccessfulAuthAns += other.SuccessfulAuthAns
+	m.Duplicated += other.Duplicated
+	m.Successful += other.Successful
+	m.ServerFail += other.ServerFail
+	m.NXDomain += other.NXDomain
+	m.FormatError += other.FormatError
+	m.NXRRSet += other.NXRRSet
+	m.Referral += other.Referral
+	m.Refused += other.Refused
+	m.OtherRcode += other.OtherRcode
+	m.Timeouts += other.Timeouts
+	m.OrphanedResponses += other.OrphanedResponses
+	m.UDPQueries += other.UDPQueries
+	m.TCPQueries += other.TCPQueries
+	m.EDNSQueries += other.EDNSQueries
+	m.NoEDNSQueries += other.NoEDNSQueries
+	m.DOQueries += other.DOQueries
+	m.TruncatedResponses += other.TruncatedResponses
+	m.BytesReceived += other.BytesReceived
+	m.BytesSent += other.BytesSent
+
+	m.Latency.Merge(other.Latency)
+	m.RequestSize.Merge(other.RequestSize)
+	m.ResponseSize.Merge(other.ResponseSize)
+	*m.AverageTime = m.Latency.Mean()
+
+	for queryType, otherOutcomes := range other.QueryTypes {
+		outcomes, exist := m.QueryTypes[queryType]
+		if !exist {
+			outcomes = make(map[string]int64)
+			m.QueryTypes[queryType] = outcomes
+		}
+		for outcome, count := range otherOutcomes {
+			outcomes[outcome] += count
+		}
+	}
+	for bucket, count := range other.UDPSizes {
+		m.UDPSizes[bucket] += count
+	}
+}
+
+func (t *TopNames) Merge(other *TopNames) {
+	if t == nil || other == nil {
+		return
+	}
+	t.all.Merge(other.all)
+	t.nxDomain.Merge(other.nxDomain)
+	for viewName, otherPerView := range other.perView {
+		perView, exist := t.perView[viewName]
+		if !exist {
+			perView = NewSpaceSaving(t.size * TOP_NAMES_CAPACITY_FACTOR)
+			t.perView[viewName] = perView
+		}
+		perView.Merge(otherPerView)
+	}
+}
+
+func (d *DistinctCounters) Merge(other *DistinctCounters) {
+	if d == nil || other == nil {
+		return
+	}
+	d.clients.Merge(other.clients)
+	d.qnames.Merge(other.qnames)
+	d.servers.Merge(other.servers)
+	for viewName, otherPerView := range other.perView {
+		perView, exist := d.perView[viewName]
+		if !exist {
+			perView = &distinctViewCounters{clients: NewHyperLogLog(), qnames: NewHyperLogLog()}
+			d.perView[viewName] = perView
+		}
+		perView.clients.Merge(otherPerView.clients)
+		perView.qnames.Merge(otherPerView.qnames)
+	}
+}
+
+// Merge two estimators which are nil until a value is added
+func mergeHyperLogLog(h *HyperLogLog, other *HyperLogLog) *HyperLogLog {
+	if other == nil {
+		return h
+	}
+	if h == nil {
+		h = NewHyperLogLog()
+	}
+	h.Merge(other)
+	return h
+}
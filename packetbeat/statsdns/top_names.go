@@ -0,0 +1,80 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Number of names counted for each reported name, the extra counters keep the top N accurate
+const TOP_NAMES_CAPACITY_FACTOR = 10
+
+type (
+	// Most queried names of the interval
+	TopNames struct {
+		size     int
+		all      *SpaceSaving
+		nxDomain *SpaceSaving
+		perView  map[string]*SpaceSaving
+	}
+
+	topNamesSummary struct {
+		All      []HeavyHitter            `json:"all"`
+		NXDomain []HeavyHitter            `json:"nx_domain"`
+		PerView  map[string][]HeavyHitter `json:"per_view"`
+	}
+)
+
+func NewTopNames(size int) *TopNames {
+	return &TopNames{
+		size:     size,
+		all:      NewSpaceSaving(size * TOP_NAMES_CAPACITY_FACTOR),
+		nxDomain: NewSpaceSaving(size * TOP_NAMES_CAPACITY_FACTOR),
+		perView:  make(map[string]*SpaceSaving),
+	}
+}
+
+// Count the queried name of a client response. viewName is empty if the client isn't in any view.
+func (t *TopNames) Offer(name string, viewName string, responseCode string) {
+	if t == nil || t.size <= 0 || name == "" {
+		return
+	}
+	name = strings.ToLower(name)
+	t.all.Offer(name)
+	if responseCode == NXDOMAIN {
+		t.nxDomain.Offer(name)
+	}
+	if viewName != "" {
+		perView, exist := t.perView[viewName]
+		if !exist {
+			perView = NewSpaceSaving(t.size * TOP_NAMES_CAPACITY_FACTOR)
+			t.perView[viewName] = perView
+		}
+		perView.Offer(name)
+	}
+}
+
+func (t *TopNames) MarshalJSON() ([]byte, error) {
+	summary := topNamesSummary{
+		All:      t.all.Top(t.size),
+		NXDomain: t.nxDomain.Top(t.size),
+		PerView:  make(map[string][]HeavyHitter, len(t.perView)),
+	}
+	for viewName, perView := range t.perView {
+		summary.PerView[viewName] = perView.Top(t.size)
+	}
+	return json.Marshal(summary)
+}
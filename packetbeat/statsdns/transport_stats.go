@@ -0,0 +1,92 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/elastic/beats/packetbeat/model"
+)
+
+const (
+	TRANSPORT_UDP = "udp"
+	TRANSPORT_TCP = "tcp"
+)
+
+var (
+	// Upper bounds of the advertised EDNS UDP buffer size buckets,
+	// 1232 is the size recommended by the DNS Flag Day 2020
+	UDPSizeBucketBounds = []uint16{512, 1232, 1452, 4096}
+)
+
+// Return the name of the bucket of an advertised UDP buffer size, e.g. "le_1232" or "gt_4096"
+func udpSizeBucket(size uint16) string {
+	for _, bound := range UDPSizeBucketBounds {
+		if size <= bound {
+			return "le_" + strconv.Itoa(int(bound))
+		}
+	}
+	return "gt_" + strconv.Itoa(int(UDPSizeBucketBounds[len(UDPSizeBucketBounds)-1]))
+}
+
+// Count the transport, the EDNS and the DO bit of a query
+func increaseTransportCounters(metrics *DNSMetrics, transport string, opt *model.Opt) {
+	switch transport {
+	case TRANSPORT_UDP:
+		atomic.AddInt64(&metrics.UDPQueries, 1)
+	case TRANSPORT_TCP:
+		atomic.AddInt64(&metrics.TCPQueries, 1)
+	}
+	if opt == nil {
+		atomic.AddInt64(&metrics.NoEDNSQueries, 1)
+		return
+	}
+	atomic.AddInt64(&metrics.EDNSQueries, 1)
+	if opt.Do {
+		atomic.AddInt64(&metrics.DOQueries, 1)
+	}
+	metrics.UDPSizes[udpSizeBucket(opt.UDPSize)]++
+}
+
+// Count the transport and EDNS of a query for the client or AS and its view
+func (s *StatisticsService) IncreaseTransportCounter(query *QueryDNS) {
+	if query.transport == "" {
+		return
+	}
+	if s.engine.IsInternalCall(query.srcIP, query.dstIP) {
+		return
+	}
+	if statIP := s.CreateCounterMetric(query.srcIP, query.dstIP, QUERY); statIP != "" {
+		increaseTransportCounters(s.StatsMap[statIP].DNSMetrics, query.transport, query.opt)
+	}
+	if s.newStats(query.view, VIEW) {
+		increaseTransportCounters(s.StatsMap[query.view].DNSMetrics, query.transport, query.opt)
+	}
+}
+
+func (s *StatisticsService) IncrDNSStatsTruncated(clientIp string) {
+	if _, exist := s.StatsMap[clientIp]; exist {
+		atomic.AddInt64(&s.StatsMap[clientIp].DNSMetrics.TruncatedResponses, 1)
+	}
+}
+
+func (s *StatisticsService) IncrDNSStatsTruncatedForPerView(viewName string, metricType string) {
+	if metricType == CLIENT {
+		if s.newStats(viewName, VIEW) {
+			atomic.AddInt64(&s.StatsMap[viewName].DNSMetrics.TruncatedResponses, 1)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/model"
+)
+
+func TestUDPSizeBucket(t *testing.T) {
+	assert.Equal(t, "le_512", udpSizeBucket(0))
+	assert.Equal(t, "le_512", udpSizeBucket(512))
+	assert.Equal(t, "le_1232", udpSizeBucket(1232))
+	assert.Equal(t, "le_4096", udpSizeBucket(4096))
+	assert.Equal(t, "gt_4096", udpSizeBucket(8192))
+}
+
+func TestIncreaseTransportCounters(t *testing.T) {
+	metrics := &DNSMetrics{UDPSizes: make(map[string]int64)}
+	increaseTransportCounters(metrics, TRANSPORT_UDP, nil)
+	increaseTransportCounters(metrics, TRANSPORT_UDP, &model.Opt{UDPSize: 4096, Do: true})
+	increaseTransportCounters(metrics, TRANSPORT_TCP, &model.Opt{UDPSize: 1232})
+
+	assert.Equal(t, int64(2), metrics.UDPQueries)
+	assert.Equal(t, int64(1), metrics.TCPQueries)
+	assert.Equal(t, int64(1), metrics.NoEDNSQueries)
+	assert.Equal(t, int64(2), metrics.EDNSQueries)
+	assert.Equal(t, int64(1), metrics.DOQueries)
+	assert.Equal(t, map[string]int64{"le_4096": 1, "le_1232": 1}, metrics.UDPSizes)
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdns
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/elastic/beats/packetbeat/model"
+)
+
+// Normalize a domain name: lower case with the trailing dot
+func canonicalName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// Authoritative zones to report, if empty the statistics are reported per eTLD+1
+func makeZones(zones []string) []string {
+	result := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			result = append(result, canonicalName(zone))
+		}
+	}
+	return result
+}
+
+// Return the queried name of a DNS message
+func recordQueryName(msg *model.Record) string {
+	if msg.Resource == "" && msg.DNS.Question != nil {
+		return msg.DNS.Question.Name
+	}
+	return msg.Resource
+}
+
+// Find the zone of a queried name: the longest configured zone containing the name,
+// or the eTLD+1 of the name if no zone is configured.
+func (e *StatisticsEngine) FindZone(queryName string) string {
+	if queryName == "" {
+		return ""
+	}
+	queryName = canonicalName(queryName)
+	if len(e.zones) > 0 {
+		result := ""
+		for _, zone := range e.zones {
+			if (queryName == zone || zone == "." || strings.HasSuffix(queryName, "."+zone)) && len(zone) > len(result) {
+				result = zone
+			}
+		}
+		return result
+	}
+	eTLDPlusOne, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimRight(queryName, "."))
+	if err != nil {
+		return ""
+	}
+	return eTLDPlusOne + "."
+}
+
+// Create the statistics of the zone of a queried name, return the zone name or empty
+// if the name isn't in any zone or the maximum number of zones is reached.
+func (s *StatisticsService) newZoneStats(queryName string) string {
+	zoneName := s.engine.FindZone(queryName)
+	if zoneName == "" {
+		return ""
+	}
+	if _, exist := s.StatsMap[zoneName]; !exist {
+		if s.zoneCount >= s.maximumZones {
+			return ""
+		}
+		s.zoneCount++
+	}
+	if !s.newStats(zoneName, ZONE) {
+		return ""
+	}
+	return zoneName
+}
+
+// Keep the maximumZones zones with the most queries. The zones aren't split between the shards:
+// each shard counts up to maximumZones zones and their union is limited once merged.
+func (s *StatisticsService) limitZones() {
+	if s.zoneCount <= s.maximumZones {
+		return
+	}
+	zones := make([]string, 0, s.zoneCount)
+	for key, stats := range s.StatsMap {
+		if stats.Type == ZONE {
+			zones = append(zones, key)
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		first, second := s.StatsMap[zones[i]].DNSMetrics, s.StatsMap[zones[j]].DNSMetrics
+		if first.TotalQueries != second.TotalQueries {
+			return first.TotalQueries > second.TotalQueries
+		}
+		return zones[i] < zones[j]
+	})
+	for _, zone := range zones[s.maximumZones:] {
+		delete(s.StatsMap, zone)
+	}
+	s.zoneCount = s.maximumZones
+}
+
+// Count the client query for the zone of the queried name
+func (s *StatisticsService) IncreaseQueryCounterForPerZone(srcIp string, dstIp string, queryName string) {
+	if s.engine.IsLocalIP(srcIp) || !s.engine.IsLocalIP(dstIp) {
+		return
+	}
+	if zoneName := s.newZoneStats(queryName); zoneName != "" {
+		atomic.AddInt64(&s.StatsMap[zoneName].DNSMetrics.TotalQueries, 1)
+	}
+}
+
+func (s *StatisticsService) IncrDNSStatsTimeoutsForPerZone(queryName string) {
+	if zoneName := s.newZoneStats(queryName); zoneName != "" {
+		atomic.AddInt64(&s.StatsMap[zoneName].DNSMetrics.Timeouts, 1)
+	}
+}
+
+// Update the zone statistics with the response sent to a client
+func (s *StatisticsService) ReceivedMessageForPerZone(msg *model.Record, outcome string) {
+	zoneName := s.newZoneStats(recordQueryName(msg))
+	if zoneName == "" {
+		return
+	}
+	metrics := s.StatsMap[zoneName].DNSMetrics
+	atomic.AddInt64(&metrics.TotalResponses, 1)
+	switch outcome {
+	case OUTCOME_SUCCESSFUL:
+		atomic.AddInt64(&metrics.Successful, 1)
+		if msg.DNS.Flags != nil && msg.DNS.Flags.Authoritative {
+			atomic.AddInt64(&metrics.SuccessfulAuthAns, 1)
+		} else {
+			atomic.AddInt64(&metrics.SuccessfulNoAuthAns, 1)
+		}
+	case OUTCOME_REFERRAL:
+		atomic.AddInt64(&metrics.Referral, 1)
+	case OUTCOME_NXRRSET:
+		atomic.AddInt64(&metrics.NXRRSet, 1)
+	case OUTCOME_NXDOMAIN:
+		atomic.AddInt64(&metrics.NXDomain, 1)
+	case OUTCOME_SERVFAIL:
+		atomic.AddInt64(&metrics.ServerFail, 1)
+	case OUTCOME_REFUSED:
+		atomic.AddInt64(&metrics.Refused, 1)
+	case OUTCOME_FORMERR:
+		atomic.AddInt64(&metrics.FormatError, 1)
+	case OUTCOME_OTHER_RCODE:
+		atomic.AddInt64(&metrics.OtherRcode, 1)
+	}
+	if msg.DNS.Question != nil && msg.DNS.Question.Type != "" {
+		s.increaseQueryTypeOutcome(metrics, msg.DNS.Question.Type, outcome)
+	}
+	observeResponseTime(metrics, msg.ResponseTime)
+}
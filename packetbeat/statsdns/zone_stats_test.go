@@ -0,0 +1,61 @@
+// Copyright 2020 BlueCat Networks (USA) Inc. and its affiliates
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package statsdns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/packetbeat/config_statistics"
+)
+
+func TestFindZoneETLDPlusOne(t *testing.T) {
+	e := NewStatisticsEngine(config_statistics.DefaultConfigStat)
+
+	assert.Equal(t, "example.com.", e.FindZone("WWW.Example.com."))
+	assert.Equal(t, "example.co.uk.", e.FindZone("a.b.example.co.uk"))
+	assert.Equal(t, "", e.FindZone("com."))
+	assert.Equal(t, "", e.FindZone(""))
+}
+
+func TestFindZoneConfigured(t *testing.T) {
+	config := config_statistics.DefaultConfigStat
+	config.Zones = []string{"example.com", "Sub.Example.com.", " "}
+	e := NewStatisticsEngine(config)
+
+	assert.Equal(t, []string{"example.com.", "sub.example.com."}, e.zones)
+	assert.Equal(t, "example.com.", e.FindZone("example.com."))
+	assert.Equal(t, "example.com.", e.FindZone("www.example.com."))
+	assert.Equal(t, "sub.example.com.", e.FindZone("host.sub.example.com."))
+	assert.Equal(t, "", e.FindZone("badexample.com."))
+	assert.Equal(t, "", e.FindZone("www.example.org."))
+}
+
+func TestZoneStatsMaximumZones(t *testing.T) {
+	config := config_statistics.DefaultConfigStat
+	config.MaximumZones = 1
+	stats := NewStatisticsEngine(config).newStatisticsService()
+
+	stats.IncrDNSStatsTimeoutsForPerZone("www.example.com.")
+	stats.IncrDNSStatsTimeoutsForPerZone("mail.example.com.")
+	stats.IncrDNSStatsTimeoutsForPerZone("www.example.org.")
+
+	assert.Len(t, stats.StatsMap, 1)
+	assert.Equal(t, ZONE, stats.StatsMap["example.com."].Type)
+	assert.Equal(t, int64(2), stats.StatsMap["example.com."].DNSMetrics.Timeouts)
+}